@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+// allowlistPollInterval is how often the namespace allowlist file is checked
+// for changes. Polling the content, rather than watching for file events,
+// also picks up ConfigMap volume updates, which swap a symlink.
+const allowlistPollInterval = time.Second * 10
+
+// namespaceAllowlist restricts reconciles to the namespaces listed in a file,
+// one per line. Blank lines and lines starting with # are ignored. A missing
+// or empty file allows every namespace.
+type namespaceAllowlist struct {
+	path string
+
+	mu         sync.RWMutex
+	content    []byte
+	namespaces map[string]bool
+}
+
+// newNamespaceAllowlist loads the allowlist from path.
+func newNamespaceAllowlist(path string) *namespaceAllowlist {
+	allowlist := &namespaceAllowlist{path: path}
+	allowlist.reload()
+
+	return allowlist
+}
+
+// allowed reports whether the namespace may be reconciled.
+func (a *namespaceAllowlist) allowed(namespace string) bool {
+	if a == nil {
+		return true
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return len(a.namespaces) == 0 || a.namespaces[namespace]
+}
+
+// reload reads the file again, and reports whether its content changed.
+func (a *namespaceAllowlist) reload() bool {
+	content, err := ioutil.ReadFile(a.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Errorf("error reading namespace allowlist %s: %v", a.path, err)
+			return false
+		}
+		content = nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.content != nil && bytes.Equal(content, a.content) {
+		return false
+	}
+
+	namespaces := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		namespaces[line] = true
+	}
+
+	a.content = content
+	if a.content == nil {
+		a.content = []byte{}
+	}
+	a.namespaces = namespaces
+
+	if len(namespaces) == 0 {
+		klog.Infof("loaded namespace allowlist %s: empty or missing, all namespaces allowed", a.path)
+	} else {
+		klog.Infof("loaded namespace allowlist %s: %d namespaces allowed", a.path, len(namespaces))
+	}
+
+	return true
+}
+
+// watch polls the file until stopCh is closed, calling onChange after every
+// reload which changed the allowlist.
+func (a *namespaceAllowlist) watch(stopCh <-chan struct{}, onChange func()) {
+	wait.Until(func() {
+		if a.reload() {
+			onChange()
+		}
+	}, allowlistPollInterval, stopCh)
+}
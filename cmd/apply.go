@@ -0,0 +1,311 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gccloudone-aurora/argo-controller/pkg/controllers/namespaces"
+	"github.com/gccloudone-aurora/argo-controller/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	rbacv1listers "k8s.io/client-go/listers/rbac/v1"
+	"k8s.io/klog"
+)
+
+// fieldManager is the field manager of the server-side applies made by the
+// controllers.
+const fieldManager = "argo-controller"
+
+// specHashMatches reports whether current was last applied from the same
+// controller-managed fields as desired, whose spec hash is already set.
+func specHashMatches(current, desired namespaces.Object) bool {
+	return current.GetAnnotations()[specHashAnnotation] == desired.GetAnnotations()[specHashAnnotation]
+}
+
+// ownedMetadataInSync reports whether current carries each of the labels and
+// annotations of desired, such as --common-labels and the managed-by label.
+// The spec hash only records what was last applied, so it does not catch
+// them being changed by hand since.
+func ownedMetadataInSync(current, desired namespaces.Object) bool {
+	return hasLabels(current, desired.GetLabels()) && hasAnnotations(current, desired.GetAnnotations())
+}
+
+// subjectsInSync reports whether the role binding current binds exactly the
+// subjects of desired. Subjects added by hand would otherwise keep the
+// access they were given.
+func subjectsInSync(current, desired *rbacv1.RoleBinding) bool {
+	if len(current.Subjects) == 0 && len(desired.Subjects) == 0 {
+		return true
+	}
+
+	return reflect.DeepEqual(current.Subjects, desired.Subjects)
+}
+
+// isManaged reports whether obj carries the managed-by label of the
+// controller.
+func isManaged(obj namespaces.Object) bool {
+	return hasLabels(obj, managedByLabels)
+}
+
+// reportUnmanaged returns an Unmanaged hook recording a Warning event on the
+// namespace for every object of the kind left alone, and counting it in the
+// summary of the reconcile.
+func reportUnmanaged(forbidden *forbiddenReporter, namespace *corev1.Namespace, kind, noun string) func(context.Context, namespaces.Object) {
+	return func(ctx context.Context, current namespaces.Object) {
+		changesFrom(ctx).recordSkipped(kind, current.GetName())
+		forbidden.recorder.Eventf(namespace, corev1.EventTypeWarning, "Unmanaged", "%s %s already exists and is not managed by the controller: delete it, or set --adopt-existing to take it over", noun, current.GetName())
+	}
+}
+
+// adoptApply returns an AdoptApply hook taking over an existing object of
+// the kind with a server-side apply of the desired object, sent by patch.
+// Without --force-apply, an object with fields owned by other field managers
+// is left alone, logging each conflicting field and its manager.
+func adoptApply(forbidden *forbiddenReporter, namespace *corev1.Namespace, kind, noun, resource string, patch func(ctx context.Context, obj namespaces.Object, data []byte) (namespaces.Object, error)) func(context.Context, namespaces.Object) (namespaces.Object, error) {
+	return func(ctx context.Context, desired namespaces.Object) (namespaces.Object, error) {
+		data, err := json.Marshal(desired)
+		if err != nil {
+			return nil, err
+		}
+
+		apiSpan := startAPISpan(ctx, "Apply", kind, desired)
+		applied, err := patch(ctx, desired, data)
+		apiSpan.End(err)
+		if conflicts := fieldManagerConflicts(err); len(conflicts) > 0 {
+			klog.Warningf("not adopting %s %s/%s, as other field managers own fields of it: %s; set --force-apply to take them over", noun, desired.GetNamespace(), desired.GetName(), strings.Join(conflicts, ", "))
+			changesFrom(ctx).recordSkipped(kind, desired.GetName())
+			return nil, nil
+		}
+		if err != nil {
+			return nil, forbidden.check(namespace, "patch", resource, desired.GetNamespace(), err)
+		}
+
+		return applied, nil
+	}
+}
+
+// fieldManagerConflicts returns the fields of a server-side apply rejected
+// because other field managers own them, along with their manager, such as
+// `.metadata.labels.team (conflict with "helm" using v1)`.
+func fieldManagerConflicts(err error) []string {
+	status, ok := err.(errors.APIStatus)
+	if !ok || !errors.IsConflict(err) || status.Status().Details == nil {
+		return nil
+	}
+
+	conflicts := []string{}
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type == metav1.CauseTypeFieldManagerConflict {
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s)", cause.Field, cause.Message))
+		}
+	}
+
+	return conflicts
+}
+
+// newServiceAccountApplier returns the Applier of the generated service
+// accounts of a namespace. Writes are traced, and forbidden writes reported
+// on the namespace. The generated labels and annotations, such as those of
+// workload identity, are restored if changed, even when the spec hash
+// matches.
+func newServiceAccountApplier(kubeClient kubernetes.Interface, lister corev1listers.ServiceAccountLister, forbidden *forbiddenReporter, namespace *corev1.Namespace) *namespaces.Applier {
+	return &namespaces.Applier{
+		Kind:      "ServiceAccount",
+		Noun:      "service account",
+		Managed:   isManaged,
+		Adopt:     adoptExisting,
+		Unmanaged: reportUnmanaged(forbidden, namespace, "ServiceAccount", "service account"),
+		AdoptApply: adoptApply(forbidden, namespace, "ServiceAccount", "service account", "serviceaccounts", func(ctx context.Context, obj namespaces.Object, data []byte) (namespaces.Object, error) {
+			return kubeClient.CoreV1().ServiceAccounts(obj.GetNamespace()).Patch(ctx, obj.GetName(), types.ApplyPatchType, data, applyOptions())
+		}),
+		Cached: func(ns, name string) (namespaces.Object, error) {
+			return lister.ServiceAccounts(ns).Get(name)
+		},
+		Get: func(ctx context.Context, ns, name string) (namespaces.Object, error) {
+			return kubeClient.CoreV1().ServiceAccounts(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		Create: func(ctx context.Context, obj namespaces.Object) (namespaces.Object, error) {
+			apiSpan := startAPISpan(ctx, "Create", "ServiceAccount", obj)
+			created, err := kubeClient.CoreV1().ServiceAccounts(obj.GetNamespace()).Create(ctx, obj.(*corev1.ServiceAccount), createOptions())
+			apiSpan.End(err)
+			return created, forbidden.check(namespace, "create", "serviceaccounts", obj.GetNamespace(), err)
+		},
+		Update: func(ctx context.Context, obj, previous namespaces.Object) error {
+			apiSpan := startAPISpan(ctx, "Update", "ServiceAccount", obj).previous(previous)
+			_, err := kubeClient.CoreV1().ServiceAccounts(obj.GetNamespace()).Update(ctx, obj.(*corev1.ServiceAccount), updateOptions())
+			apiSpan.End(err)
+			return forbidden.check(namespace, "update", "serviceaccounts", obj.GetNamespace(), err)
+		},
+		InSync: func(current, desired namespaces.Object) bool {
+			return specHashMatches(current, desired) && ownedMetadataInSync(current, desired)
+		},
+		Merge: func(live, desired namespaces.Object) {
+			updated, serviceAccount := live.(*corev1.ServiceAccount), desired.(*corev1.ServiceAccount)
+			mergeOwnedAnnotations(updated, serviceAccount)
+			mergeLabels(updated, serviceAccount.Labels)
+			updated.Secrets = serviceAccount.Secrets
+		},
+	}
+}
+
+// newRoleBindingApplier returns the Applier of the generated role bindings
+// of a namespace. The subjects and the generated labels and annotations, such
+// as --rolebinding-annotations, are restored if changed, even when the spec
+// hash matches. A role binding whose role reference changed is deleted and
+// created again, as the role reference cannot be updated.
+func newRoleBindingApplier(kubeClient kubernetes.Interface, lister rbacv1listers.RoleBindingLister, forbidden *forbiddenReporter, namespace *corev1.Namespace) *namespaces.Applier {
+	return &namespaces.Applier{
+		Kind:      "RoleBinding",
+		Noun:      "role binding",
+		Managed:   isManaged,
+		Adopt:     adoptExisting,
+		Unmanaged: reportUnmanaged(forbidden, namespace, "RoleBinding", "role binding"),
+		AdoptApply: adoptApply(forbidden, namespace, "RoleBinding", "role binding", "rolebindings", func(ctx context.Context, obj namespaces.Object, data []byte) (namespaces.Object, error) {
+			return kubeClient.RbacV1().RoleBindings(obj.GetNamespace()).Patch(ctx, obj.GetName(), types.ApplyPatchType, data, applyOptions())
+		}),
+		Cached: func(ns, name string) (namespaces.Object, error) {
+			return lister.RoleBindings(ns).Get(name)
+		},
+		Get: func(ctx context.Context, ns, name string) (namespaces.Object, error) {
+			return kubeClient.RbacV1().RoleBindings(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		Create: func(ctx context.Context, obj namespaces.Object) (namespaces.Object, error) {
+			apiSpan := startAPISpan(ctx, "Create", "RoleBinding", obj)
+			created, err := kubeClient.RbacV1().RoleBindings(obj.GetNamespace()).Create(ctx, obj.(*rbacv1.RoleBinding), createOptions())
+			apiSpan.End(err)
+			return created, forbidden.check(namespace, "create", "rolebindings", obj.GetNamespace(), err)
+		},
+		Update: func(ctx context.Context, obj, previous namespaces.Object) error {
+			apiSpan := startAPISpan(ctx, "Update", "RoleBinding", obj).previous(previous)
+			_, err := kubeClient.RbacV1().RoleBindings(obj.GetNamespace()).Update(ctx, obj.(*rbacv1.RoleBinding), updateOptions())
+			apiSpan.End(err)
+			return forbidden.check(namespace, "update", "rolebindings", obj.GetNamespace(), err)
+		},
+		Delete: func(ctx context.Context, obj namespaces.Object) error {
+			apiSpan := startAPISpan(ctx, "Delete", "RoleBinding", obj)
+			err := kubeClient.RbacV1().RoleBindings(obj.GetNamespace()).Delete(ctx, obj.GetName(), metav1.DeleteOptions{
+				Preconditions: metav1.NewUIDPreconditions(string(obj.GetUID())),
+				DryRun:        dryRun(),
+			})
+			apiSpan.End(err)
+			return forbidden.check(namespace, "delete", "rolebindings", obj.GetNamespace(), err)
+		},
+		InSync: func(current, desired namespaces.Object) bool {
+			return specHashMatches(current, desired) && ownedMetadataInSync(current, desired) &&
+				subjectsInSync(current.(*rbacv1.RoleBinding), desired.(*rbacv1.RoleBinding))
+		},
+		// The role reference of a role binding cannot be updated, such as
+		// after --user-interface-cluster-role-name is changed
+		Immutable: func(current, desired namespaces.Object) bool {
+			return current.(*rbacv1.RoleBinding).RoleRef != desired.(*rbacv1.RoleBinding).RoleRef
+		},
+		Merge: func(live, desired namespaces.Object) {
+			updated, roleBinding := live.(*rbacv1.RoleBinding), desired.(*rbacv1.RoleBinding)
+			updated.RoleRef = roleBinding.RoleRef
+			updated.Subjects = roleBinding.Subjects
+			mergeLabels(updated, roleBinding.Labels)
+			mergeAnnotations(updated, roleBinding.Annotations)
+		},
+		DryRun: writesDisabled(),
+	}
+}
+
+// countSecretWrite counts a successful create or update of a secret in
+// metrics.SecretWrites. Dry runs are not counted, as they write nothing.
+func countSecretWrite(secret *corev1.Secret, err error) {
+	if err != nil || writesDisabled() {
+		return
+	}
+	secretType := "storage"
+	if secret.Type == corev1.SecretTypeServiceAccountToken {
+		secretType = "token"
+	}
+	metrics.SecretWrites.Inc(secret.Namespace, secretType)
+}
+
+// newSecretApplier returns the Applier of the generated secrets of a
+// namespace. Only the data keys the controller generates are reconciled, so
+// keys added by other tools, such as a CA bundle, are kept. The generated
+// labels, such as --secret-labels which other tools select on, are restored
+// if removed even when the spec hash matches. Token secrets older than
+// --token-secret-max-age are recreated so the token controller issues a
+// fresh token, and secrets whose type changed are recreated as the type
+// cannot be updated. Secrets missing from lister are looked up in
+// storageSecretLister, if set.
+func newSecretApplier(kubeClient kubernetes.Interface, lister, storageSecretLister corev1listers.SecretLister, forbidden *forbiddenReporter, namespace *corev1.Namespace) *namespaces.Applier {
+	return &namespaces.Applier{
+		Kind:      "Secret",
+		Noun:      "secret",
+		Managed:   isManaged,
+		Adopt:     adoptExisting,
+		Unmanaged: reportUnmanaged(forbidden, namespace, "Secret", "secret"),
+		AdoptApply: adoptApply(forbidden, namespace, "Secret", "secret", "secrets", func(ctx context.Context, obj namespaces.Object, data []byte) (namespaces.Object, error) {
+			return kubeClient.CoreV1().Secrets(obj.GetNamespace()).Patch(ctx, obj.GetName(), types.ApplyPatchType, data, applyOptions())
+		}),
+		Cached: func(ns, name string) (namespaces.Object, error) {
+			secret, err := lister.Secrets(ns).Get(name)
+			if errors.IsNotFound(err) && storageSecretLister != nil {
+				return storageSecretLister.Secrets(ns).Get(name)
+			}
+			return secret, err
+		},
+		Get: func(ctx context.Context, ns, name string) (namespaces.Object, error) {
+			return kubeClient.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		Create: func(ctx context.Context, obj namespaces.Object) (namespaces.Object, error) {
+			apiSpan := startAPISpan(ctx, "Create", "Secret", obj)
+			created, err := kubeClient.CoreV1().Secrets(obj.GetNamespace()).Create(ctx, obj.(*corev1.Secret), createOptions())
+			apiSpan.End(err)
+			countSecretWrite(obj.(*corev1.Secret), err)
+			return created, forbidden.check(namespace, "create", "secrets", obj.GetNamespace(), err)
+		},
+		Update: func(ctx context.Context, obj, previous namespaces.Object) error {
+			apiSpan := startAPISpan(ctx, "Update", "Secret", obj).previous(previous)
+			_, err := kubeClient.CoreV1().Secrets(obj.GetNamespace()).Update(ctx, obj.(*corev1.Secret), updateOptions())
+			apiSpan.End(err)
+			countSecretWrite(obj.(*corev1.Secret), err)
+			return forbidden.check(namespace, "update", "secrets", obj.GetNamespace(), err)
+		},
+		Delete: func(ctx context.Context, obj namespaces.Object) error {
+			apiSpan := startAPISpan(ctx, "Delete", "Secret", obj)
+			err := kubeClient.CoreV1().Secrets(obj.GetNamespace()).Delete(ctx, obj.GetName(), metav1.DeleteOptions{
+				Preconditions: metav1.NewUIDPreconditions(string(obj.GetUID())),
+				DryRun:        dryRun(),
+			})
+			apiSpan.End(err)
+			return forbidden.check(namespace, "delete", "secrets", obj.GetNamespace(), err)
+		},
+		InSync: func(current, desired namespaces.Object) bool {
+			return specHashMatches(current, desired) && ownedMetadataInSync(current, desired) && hasData(current.(*corev1.Secret), desired.(*corev1.Secret).Data)
+		},
+		// The type of a secret cannot be updated, such as after
+		// --storage-secret-type is changed
+		Immutable: func(current, desired namespaces.Object) bool {
+			return current.(*corev1.Secret).Type != desired.(*corev1.Secret).Type
+		},
+		Merge: func(live, desired namespaces.Object) {
+			updated, secret := live.(*corev1.Secret), desired.(*corev1.Secret)
+			// The data of token secrets is filled by the token controller
+			// and must be kept
+			if secret.Type != corev1.SecretTypeServiceAccountToken {
+				mergeData(updated, secret.Data)
+			}
+			mergeLabels(updated, secret.Labels)
+			// Merge annotations, as the token controller adds its own
+			mergeAnnotations(updated, secret.Annotations)
+			mergeOwnerReferences(updated, secret.OwnerReferences)
+		},
+		Expired: func(current namespaces.Object) bool {
+			return tokenSecretExpired(current.(*corev1.Secret))
+		},
+		DryRun: writesDisabled(),
+	}
+}
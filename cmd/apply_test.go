@@ -0,0 +1,475 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gccloudone-aurora/argo-controller/pkg/controllers/namespaces"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	rbacv1listers "k8s.io/client-go/listers/rbac/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// desiredRoleBinding returns a generated role binding carrying its spec
+// hash, as applyRoleBinding passes it to the Applier.
+func desiredRoleBinding(t *testing.T) *rbacv1.RoleBinding {
+	t.Helper()
+
+	roleBinding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "argo-workflows",
+			Namespace:   "team-a",
+			Labels:      mergeMaps(map[string]string{"team": "a"}, managedByLabels),
+			Annotations: map[string]string{"audit": "true"},
+		},
+		RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "argo-workflows"},
+		Subjects: []rbacv1.Subject{
+			{Kind: "ServiceAccount", Name: "argo-workflows", Namespace: "team-a"},
+		},
+	}
+	if _, err := setSpecHash(roleBinding, roleBinding.Labels, roleBinding.Annotations, roleBinding.RoleRef, roleBinding.Subjects); err != nil {
+		t.Fatalf("setSpecHash: %v", err)
+	}
+
+	return roleBinding
+}
+
+func TestRoleBindingInSync(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(live *rbacv1.RoleBinding)
+		want bool
+	}{
+		{name: "unchanged", edit: func(live *rbacv1.RoleBinding) {}, want: true},
+		{
+			name: "subject added",
+			edit: func(live *rbacv1.RoleBinding) {
+				live.Subjects = append(live.Subjects, rbacv1.Subject{APIGroup: rbacv1.GroupName, Kind: "Group", Name: "intruders"})
+			},
+		},
+		{name: "subject removed", edit: func(live *rbacv1.RoleBinding) { live.Subjects = nil }},
+		{
+			name: "subject replaced",
+			edit: func(live *rbacv1.RoleBinding) { live.Subjects[0].Name = "default" },
+		},
+		{name: "common label removed", edit: func(live *rbacv1.RoleBinding) { delete(live.Labels, "team") }},
+		{
+			name: "managed-by label changed",
+			edit: func(live *rbacv1.RoleBinding) { live.Labels["app.kubernetes.io/managed-by"] = "someone" },
+		},
+		{name: "annotation removed", edit: func(live *rbacv1.RoleBinding) { delete(live.Annotations, "audit") }},
+		{
+			name: "spec hash changed",
+			edit: func(live *rbacv1.RoleBinding) { live.Annotations[specHashAnnotation] = "stale" },
+		},
+		{
+			name: "foreign metadata added",
+			edit: func(live *rbacv1.RoleBinding) {
+				live.Labels["foreign"] = "x"
+				live.Annotations["foreign"] = "x"
+			},
+			want: true,
+		},
+	}
+
+	applier := newRoleBindingApplier(nil, nil, nil, nil)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			desired := desiredRoleBinding(t)
+			live := desired.DeepCopy()
+			test.edit(live)
+
+			if got := applier.InSync(live, desired); got != test.want {
+				t.Errorf("got in sync %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestServiceAccountInSync(t *testing.T) {
+	desired := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "argo-workflows",
+			Namespace:   "team-a",
+			Labels:      mergeMaps(managedByLabels, map[string]string{workloadIdentityUseLabel: "true"}),
+			Annotations: map[string]string{workloadIdentityClientIDKey: "client"},
+		},
+	}
+	setOwnedAnnotations(desired)
+	if _, err := setSpecHash(desired, desired.Labels, desired.Annotations, desired.Secrets); err != nil {
+		t.Fatalf("setSpecHash: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		edit func(live *corev1.ServiceAccount)
+		want bool
+	}{
+		{name: "unchanged", edit: func(live *corev1.ServiceAccount) {}, want: true},
+		{
+			name: "workload identity label removed",
+			edit: func(live *corev1.ServiceAccount) { delete(live.Labels, workloadIdentityUseLabel) },
+		},
+		{
+			name: "client id changed",
+			edit: func(live *corev1.ServiceAccount) { live.Annotations[workloadIdentityClientIDKey] = "other" },
+		},
+		{
+			name: "managed-by label removed",
+			edit: func(live *corev1.ServiceAccount) { delete(live.Labels, "app.kubernetes.io/managed-by") },
+		},
+		{
+			name: "foreign annotation added",
+			edit: func(live *corev1.ServiceAccount) { live.Annotations["foreign"] = "x" },
+			want: true,
+		},
+	}
+
+	applier := newServiceAccountApplier(nil, nil, nil, nil)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			live := desired.DeepCopy()
+			test.edit(live)
+
+			if got := applier.InSync(live, desired); got != test.want {
+				t.Errorf("got in sync %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSecretInSync(t *testing.T) {
+	desired := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "argo-artifacts",
+			Namespace: "team-a",
+			Labels:    mergeMaps(managedByLabels, map[string]string{"backup": "true"}),
+		},
+		Data: map[string][]byte{"accesskey": []byte("key")},
+	}
+	if _, err := setSpecHash(desired, desired.Labels, desired.Annotations, desired.Data); err != nil {
+		t.Fatalf("setSpecHash: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		edit func(live *corev1.Secret)
+		want bool
+	}{
+		{name: "unchanged", edit: func(live *corev1.Secret) {}, want: true},
+		{name: "label removed", edit: func(live *corev1.Secret) { delete(live.Labels, "backup") }},
+		{name: "key changed", edit: func(live *corev1.Secret) { live.Data["accesskey"] = []byte("leaked") }},
+		{name: "external key added", edit: func(live *corev1.Secret) { live.Data["ca.crt"] = []byte("ca") }, want: true},
+	}
+
+	applier := newSecretApplier(nil, nil, nil, nil, nil)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			live := desired.DeepCopy()
+			test.edit(live)
+
+			if got := applier.InSync(live, desired); got != test.want {
+				t.Errorf("got in sync %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+// applierTest is a fake cluster holding live, whose informer cache holds the
+// objects of live named in cached. The fake clientset does not support
+// server-side apply, so apply patches are decoded onto the existing object,
+// merging into its maps as the API server would, or rejected with conflicts
+// if set.
+type applierTest struct {
+	kubeClient *fake.Clientset
+	recorder   *record.FakeRecorder
+	lister     cache.Indexer
+	conflicts  []metav1.StatusCause
+}
+
+func newApplierTest(live []runtime.Object, cached ...runtime.Object) *applierTest {
+	a := &applierTest{
+		kubeClient: fake.NewSimpleClientset(live...),
+		recorder:   record.NewFakeRecorder(10),
+		lister:     newIndexer(cached...),
+	}
+	a.kubeClient.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		if len(a.conflicts) > 0 {
+			return true, nil, &apierrors.StatusError{ErrStatus: metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    http.StatusConflict,
+				Reason:  metav1.StatusReasonConflict,
+				Details: &metav1.StatusDetails{Causes: a.conflicts},
+			}}
+		}
+
+		obj, err := a.kubeClient.Tracker().Get(patch.GetResource(), patch.GetNamespace(), patch.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		if err := json.Unmarshal(patch.GetPatch(), obj); err != nil {
+			return true, nil, err
+		}
+		return true, obj, a.kubeClient.Tracker().Update(patch.GetResource(), obj, patch.GetNamespace())
+	})
+
+	return a
+}
+
+func (a *applierTest) roleBindingApplier() *namespaces.Applier {
+	return newRoleBindingApplier(a.kubeClient, rbacv1listers.NewRoleBindingLister(a.lister), newForbiddenReporter(a.recorder), newNamespace("team-a"))
+}
+
+func (a *applierTest) secretApplier() *namespaces.Applier {
+	return newSecretApplier(a.kubeClient, corev1listers.NewSecretLister(a.lister), nil, newForbiddenReporter(a.recorder), newNamespace("team-a"))
+}
+
+func TestRoleBindingApplier(t *testing.T) {
+	defer func(adopt bool) { adoptExisting = adopt }(adoptExisting)
+
+	desired := desiredRoleBinding(t)
+	edited := func(edit func(live *rbacv1.RoleBinding)) *rbacv1.RoleBinding {
+		live := desired.DeepCopy()
+		edit(live)
+		return live
+	}
+	outOfSync := edited(func(live *rbacv1.RoleBinding) {
+		live.Subjects = append(live.Subjects, groupSubject("intruders"))
+	})
+	unmanaged := edited(func(live *rbacv1.RoleBinding) { live.Labels = nil })
+
+	tests := []struct {
+		name       string
+		live       []runtime.Object
+		cached     []runtime.Object
+		adopt      bool
+		conflicts  int
+		owned      []metav1.StatusCause
+		wantWrites []string
+		wantEvent  string
+		wantLeft   bool
+	}{
+		{
+			name:       "created when missing",
+			wantWrites: []string{"create rolebindings"},
+		},
+		{
+			name:       "not cached and in sync",
+			live:       []runtime.Object{desired.DeepCopy()},
+			wantWrites: []string{"create rolebindings"},
+		},
+		{
+			name:       "not cached and out of sync",
+			live:       []runtime.Object{outOfSync.DeepCopy()},
+			wantWrites: []string{"create rolebindings", "update rolebindings"},
+		},
+		{
+			name:   "in sync",
+			live:   []runtime.Object{desired.DeepCopy()},
+			cached: []runtime.Object{desired.DeepCopy()},
+		},
+		{
+			name:       "out of sync",
+			live:       []runtime.Object{outOfSync.DeepCopy()},
+			cached:     []runtime.Object{outOfSync.DeepCopy()},
+			wantWrites: []string{"update rolebindings"},
+		},
+		{
+			name:      "unmanaged",
+			live:      []runtime.Object{unmanaged.DeepCopy()},
+			cached:    []runtime.Object{unmanaged.DeepCopy()},
+			wantEvent: "Unmanaged",
+			wantLeft:  true,
+		},
+		{
+			name:       "adopted",
+			live:       []runtime.Object{unmanaged.DeepCopy()},
+			cached:     []runtime.Object{unmanaged.DeepCopy()},
+			adopt:      true,
+			wantWrites: []string{"patch rolebindings"},
+		},
+		{
+			name:   "adopted with fields owned by another manager",
+			live:   []runtime.Object{unmanaged.DeepCopy()},
+			cached: []runtime.Object{unmanaged.DeepCopy()},
+			adopt:  true,
+			owned: []metav1.StatusCause{
+				{Type: metav1.CauseTypeFieldManagerConflict, Message: `conflict with "helm" using rbac.authorization.k8s.io/v1`, Field: ".subjects"},
+			},
+			wantWrites: []string{"patch rolebindings"},
+			wantLeft:   true,
+		},
+		{
+			name: "role reference changed",
+			live: []runtime.Object{edited(func(live *rbacv1.RoleBinding) { live.RoleRef.Name = "argo-workflows-old" })},
+			cached: []runtime.Object{
+				edited(func(live *rbacv1.RoleBinding) { live.RoleRef.Name = "argo-workflows-old" }),
+			},
+			wantWrites: []string{"delete rolebindings", "create rolebindings"},
+		},
+		{
+			name:       "deleted since cached",
+			cached:     []runtime.Object{outOfSync.DeepCopy()},
+			wantWrites: []string{"create rolebindings"},
+		},
+		{
+			name:       "conflict retried",
+			live:       []runtime.Object{outOfSync.DeepCopy()},
+			cached:     []runtime.Object{outOfSync.DeepCopy()},
+			conflicts:  1,
+			wantWrites: []string{"update rolebindings", "update rolebindings"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			adoptExisting = test.adopt
+			applierTest := newApplierTest(test.live, test.cached...)
+			applierTest.conflicts = test.owned
+			conflicts := test.conflicts
+			applierTest.kubeClient.PrependReactor("update", "rolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if conflicts == 0 {
+					return false, nil, nil
+				}
+				conflicts--
+				return true, nil, apierrors.NewConflict(rbacv1.Resource("rolebindings"), desired.Name, errors.New("modified"))
+			})
+
+			if err := applierTest.roleBindingApplier().Apply(context.Background(), desired.DeepCopy()); err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+
+			if got := writes(applierTest.kubeClient); !equalStrings(got, test.wantWrites) {
+				t.Errorf("got writes %v, want %v", got, test.wantWrites)
+			}
+			select {
+			case event := <-applierTest.recorder.Events:
+				if test.wantEvent == "" || !strings.Contains(event, test.wantEvent) {
+					t.Errorf("got event %q, want %q", event, test.wantEvent)
+				}
+			default:
+				if test.wantEvent != "" {
+					t.Errorf("got no event, want %q", test.wantEvent)
+				}
+			}
+			if test.wantLeft {
+				return
+			}
+
+			live, err := applierTest.kubeClient.RbacV1().RoleBindings("team-a").Get(context.Background(), desired.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("getting the role binding: %v", err)
+			}
+			if !newRoleBindingApplier(nil, nil, nil, nil).InSync(live, desired) {
+				t.Errorf("got %+v, want it in sync with %+v", live, desired)
+			}
+		})
+	}
+}
+
+func TestSecretApplierExpired(t *testing.T) {
+	defer func(maxAge time.Duration) { tokenSecretMaxAge = maxAge }(tokenSecretMaxAge)
+	tokenSecretMaxAge = time.Hour
+
+	desired := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "argo-workflows-ui-token",
+			Namespace:   "team-a",
+			Labels:      managedByLabels,
+			Annotations: map[string]string{corev1.ServiceAccountNameKey: "argo-workflows-ui"},
+		},
+		Type: corev1.SecretTypeServiceAccountToken,
+	}
+	if _, err := setSpecHash(desired, desired.Labels, desired.Annotations, desired.Data); err != nil {
+		t.Fatalf("setSpecHash: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		created    time.Time
+		wantWrites []string
+	}{
+		{name: "fresh", created: time.Now().Add(-time.Minute)},
+		{name: "expired", created: time.Now().Add(-2 * time.Hour), wantWrites: []string{"delete secrets", "create secrets"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			existing := desired.DeepCopy()
+			existing.CreationTimestamp = metav1.NewTime(test.created)
+			applierTest := newApplierTest([]runtime.Object{existing}, existing.DeepCopy())
+
+			if err := applierTest.secretApplier().Apply(context.Background(), desired.DeepCopy()); err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+
+			if got := writes(applierTest.kubeClient); !equalStrings(got, test.wantWrites) {
+				t.Errorf("got writes %v, want %v", got, test.wantWrites)
+			}
+		})
+	}
+}
+
+func TestApplyOptions(t *testing.T) {
+	defer func(force bool) { forceApply = force }(forceApply)
+
+	for _, force := range []bool{false, true} {
+		forceApply = force
+
+		options := applyOptions()
+		if options.FieldManager != fieldManager {
+			t.Errorf("got field manager %q, want %q", options.FieldManager, fieldManager)
+		}
+		if options.Force == nil || *options.Force != force {
+			t.Errorf("got force %v, want %t", options.Force, force)
+		}
+	}
+}
+
+func TestFieldManagerConflicts(t *testing.T) {
+	conflict := &apierrors.StatusError{ErrStatus: metav1.Status{
+		Status: metav1.StatusFailure,
+		Code:   http.StatusConflict,
+		Reason: metav1.StatusReasonConflict,
+		Details: &metav1.StatusDetails{Causes: []metav1.StatusCause{
+			{Type: metav1.CauseTypeFieldManagerConflict, Message: `conflict with "helm" using v1`, Field: ".metadata.labels.team"},
+			{Type: metav1.CauseTypeFieldValueInvalid, Message: "unrelated", Field: ".data"},
+		}},
+	}}
+
+	tests := []struct {
+		name string
+		err  error
+		want []string
+	}{
+		{name: "no error"},
+		{name: "other error", err: apierrors.NewConflict(rbacv1.Resource("rolebindings"), "argo-workflows", errors.New("modified"))},
+		{name: "field manager conflict", err: conflict, want: []string{`.metadata.labels.team (conflict with "helm" using v1)`}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := fieldManagerConflicts(test.err); !equalStrings(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// auditAPIVersion versions the audit records. Fields may be added to a
+// version, but are never renamed, removed or given a new meaning.
+const auditAPIVersion = "argo-controller.aurora/audit/v1"
+
+// lastAppliedAnnotation is left out of the audit records of secrets, as it
+// may hold their data.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+var auditLog string
+
+// auditRecord is a line of the audit log, describing a successful write.
+type auditRecord struct {
+	APIVersion string        `json:"apiVersion"`
+	Time       time.Time     `json:"time"`
+	Actor      string        `json:"actor"`
+	Verb       string        `json:"verb"`
+	Kind       string        `json:"kind"`
+	Namespace  string        `json:"namespace"`
+	Name       string        `json:"name"`
+	Group      string        `json:"group,omitempty"`
+	DryRun     bool          `json:"dryRun,omitempty"`
+	Before     *auditSummary `json:"before,omitempty"`
+	After      *auditSummary `json:"after,omitempty"`
+}
+
+// auditSummary is the state of an object before or after a write. The values
+// of secrets are never recorded, only their keys and a hash of each value.
+type auditSummary struct {
+	Labels           map[string]string `json:"labels,omitempty"`
+	Annotations      map[string]string `json:"annotations,omitempty"`
+	Finalizers       []string          `json:"finalizers,omitempty"`
+	RoleRef          string            `json:"roleRef,omitempty"`
+	Subjects         []string          `json:"subjects,omitempty"`
+	Secrets          []string          `json:"secrets,omitempty"`
+	ImagePullSecrets []string          `json:"imagePullSecrets,omitempty"`
+	Type             string            `json:"type,omitempty"`
+	DataHashes       map[string]string `json:"dataHashes,omitempty"`
+}
+
+// auditWriter writes audit records as JSON lines, one at a time.
+type auditWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// audit is the destination of --audit-log, or nil when it is not set.
+var audit *auditWriter
+
+// openAuditLog opens --audit-log: stdout, or a file appended to.
+func openAuditLog() error {
+	switch auditLog {
+	case "":
+		return nil
+	case "stdout":
+		audit = &auditWriter{w: os.Stdout}
+	default:
+		file, err := os.OpenFile(auditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return fmt.Errorf("error opening --audit-log: %v", err)
+		}
+		audit = &auditWriter{w: file}
+	}
+
+	klog.Infof("writing the audit log to %s", auditLog)
+	return nil
+}
+
+// record writes a record of a successful write.
+func (a *auditWriter) record(record auditRecord) {
+	if a == nil {
+		return
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		klog.Errorf("error encoding audit record: %v", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, err := a.w.Write(append(line, '\n')); err != nil {
+		klog.Errorf("error writing audit record: %v", err)
+	}
+}
+
+// auditActor names who makes the writes: the user impersonated with --as,
+// or else the controller itself.
+func auditActor() string {
+	if impersonateUser != "" {
+		return impersonateUser
+	}
+
+	return "argo-controller"
+}
+
+// summarize returns the audit summary of obj, or nil if obj only carries a
+// name, as for deletes made by name.
+func summarize(obj metav1.Object) *auditSummary {
+	summary := &auditSummary{
+		Labels:      obj.GetLabels(),
+		Annotations: obj.GetAnnotations(),
+		Finalizers:  obj.GetFinalizers(),
+	}
+
+	switch o := obj.(type) {
+	case *corev1.ServiceAccount:
+		for _, secret := range o.Secrets {
+			summary.Secrets = append(summary.Secrets, secret.Name)
+		}
+		for _, secret := range o.ImagePullSecrets {
+			summary.ImagePullSecrets = append(summary.ImagePullSecrets, secret.Name)
+		}
+	case *rbacv1.RoleBinding:
+		summary.RoleRef = o.RoleRef.Kind + "/" + o.RoleRef.Name
+		for _, subject := range o.Subjects {
+			summary.Subjects = append(summary.Subjects, fmt.Sprintf("%s:%s/%s", subject.Kind, subject.Namespace, subject.Name))
+		}
+	case *corev1.Secret:
+		if _, ok := summary.Annotations[lastAppliedAnnotation]; ok {
+			summary.Annotations = mergeMaps(summary.Annotations)
+			delete(summary.Annotations, lastAppliedAnnotation)
+		}
+		summary.Type = string(o.Type)
+		summary.DataHashes = map[string]string{}
+		keys := []string{}
+		for key := range o.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			hash := sha256.Sum256(o.Data[key])
+			summary.DataHashes[key] = "sha256:" + hex.EncodeToString(hash[:])
+		}
+	case *metav1.ObjectMeta:
+		return nil
+	}
+
+	return summary
+}
+
+type auditGroupKey struct{}
+
+// withAuditGroup returns a context recording group as the group affected by
+// the writes made with it.
+func withAuditGroup(ctx context.Context, group string) context.Context {
+	return context.WithValue(ctx, auditGroupKey{}, group)
+}
+
+// auditGroupFrom returns the group affected by the writes made with ctx, if
+// any.
+func auditGroupFrom(ctx context.Context) string {
+	group, _ := ctx.Value(auditGroupKey{}).(string)
+	return group
+}
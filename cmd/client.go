@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog"
+)
+
+// impersonateUIDHeader is the header used to impersonate a user's UID. It is
+// not supported by rest.ImpersonationConfig in this version of client-go.
+const impersonateUIDHeader = "Impersonate-Uid"
+
+// buildConfig creates the Kubernetes client configuration from the command
+// line flags. Without --apiserver, --kubeconfig or --context, the in-cluster
+// configuration is used. --context selects a context of the kubeconfig found
+// by the usual rules, the KUBECONFIG environment variable then
+// ~/.kube/config, unless --kubeconfig names one.
+func buildConfig() (*rest.Config, error) {
+	var cfg *rest.Config
+	var err error
+	if kubeContext == "" {
+		cfg, err = clientcmd.BuildConfigFromFlags(apiserver, kubeconfig)
+	} else {
+		loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+		if kubeconfig != "" {
+			loadingRules.ExplicitPath = kubeconfig
+		}
+		cfg, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			loadingRules,
+			&clientcmd.ConfigOverrides{
+				ClusterInfo:    clientcmdapi.Cluster{Server: apiserver},
+				CurrentContext: kubeContext,
+			},
+		).ClientConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.QPS = kubeAPIQPS
+	cfg.Burst = kubeAPIBurst
+	klog.Infof("kubernetes client rate limit: %v qps, %d burst", cfg.QPS, cfg.Burst)
+
+	if impersonateUser != "" {
+		cfg.Impersonate = rest.ImpersonationConfig{
+			UserName: impersonateUser,
+			Groups:   impersonateGroups,
+		}
+
+		if impersonateUID != "" {
+			uid := impersonateUID
+			cfg.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+				return &impersonateUIDRoundTripper{uid: uid, delegate: rt}
+			})
+		}
+	}
+
+	return cfg, nil
+}
+
+// impersonateUIDRoundTripper sets the impersonated UID on every request.
+type impersonateUIDRoundTripper struct {
+	uid      string
+	delegate http.RoundTripper
+}
+
+func (rt *impersonateUIDRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = utilnet.CloneRequest(req)
+	req.Header.Set(impersonateUIDHeader, rt.uid)
+	return rt.delegate.RoundTrip(req)
+}
+
+// verifyImpersonation checks, using the controller's own identity, that it
+// is allowed to impersonate the configured user, groups and UID. Denials are
+// logged as warnings; the API server will reject requests regardless.
+func verifyImpersonation(cfg *rest.Config) {
+	if impersonateUser == "" {
+		return
+	}
+
+	// Check as ourselves, not as the impersonated user
+	selfConfig := rest.CopyConfig(cfg)
+	selfConfig.Impersonate = rest.ImpersonationConfig{}
+	selfConfig.WrapTransport = nil
+
+	kubeClient, err := kubernetes.NewForConfig(selfConfig)
+	if err != nil {
+		klog.Warningf("unable to verify impersonation: %v", err)
+		return
+	}
+
+	checks := []authorizationv1.ResourceAttributes{
+		{Verb: "impersonate", Resource: "users", Name: impersonateUser},
+	}
+	for _, group := range impersonateGroups {
+		checks = append(checks, authorizationv1.ResourceAttributes{Verb: "impersonate", Resource: "groups", Name: group})
+	}
+	if impersonateUID != "" {
+		checks = append(checks, authorizationv1.ResourceAttributes{Verb: "impersonate", Group: "authentication.k8s.io", Resource: "uids", Name: impersonateUID})
+	}
+
+	for i := range checks {
+		review, err := kubeClient.AuthorizationV1().SelfSubjectAccessReviews().Create(context.Background(), &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &checks[i],
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			klog.Warningf("unable to verify impersonation of %s %q: %v", checks[i].Resource, checks[i].Name, err)
+			continue
+		}
+
+		if !review.Status.Allowed {
+			klog.Warningf("the controller is not allowed to impersonate %s %q; requests will be rejected", checks[i].Resource, checks[i].Name)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: production
+clusters:
+- name: production
+  cluster:
+    server: https://production.example.com
+- name: staging
+  cluster:
+    server: https://staging.example.com
+contexts:
+- name: production
+  context:
+    cluster: production
+    user: admin
+- name: staging
+  context:
+    cluster: staging
+    user: admin
+users:
+- name: admin
+  user:
+    token: token
+`
+
+func TestBuildConfigContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := ioutil.WriteFile(path, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { kubeconfig, kubeContext, apiserver = "", "", "" }()
+
+	tests := []struct {
+		name       string
+		kubeconfig string
+		env        string
+		context    string
+		want       string
+	}{
+		{name: "current context", kubeconfig: path, want: "https://production.example.com"},
+		{name: "context of --kubeconfig", kubeconfig: path, context: "staging", want: "https://staging.example.com"},
+		{name: "context of KUBECONFIG", env: path, context: "staging", want: "https://staging.example.com"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("KUBECONFIG", test.env)
+			kubeconfig, kubeContext, apiserver = test.kubeconfig, test.context, ""
+
+			cfg, err := buildConfig()
+			if err != nil {
+				t.Fatalf("buildConfig: %v", err)
+			}
+			if cfg.Host != test.want {
+				t.Errorf("got host %q, want %q", cfg.Host, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// dryRun returns the dry-run option of write requests. Under
+// --server-dry-run, or while frozen, requests go through validation and
+// admission, including admission webhooks, but nothing is persisted.
+func dryRun() []string {
+	if writesDisabled() {
+		return []string{metav1.DryRunAll}
+	}
+
+	return nil
+}
+
+// createOptions returns the options of every create made by the controllers.
+func createOptions() metav1.CreateOptions {
+	return metav1.CreateOptions{DryRun: dryRun()}
+}
+
+// updateOptions returns the options of every update made by the controllers.
+func updateOptions() metav1.UpdateOptions {
+	return metav1.UpdateOptions{DryRun: dryRun()}
+}
+
+// deleteOptions returns the options of every delete made by the controllers.
+func deleteOptions() metav1.DeleteOptions {
+	return metav1.DeleteOptions{DryRun: dryRun()}
+}
+
+// patchOptions returns the options of every patch made by the controllers.
+func patchOptions() metav1.PatchOptions {
+	return metav1.PatchOptions{DryRun: dryRun()}
+}
+
+// applyOptions returns the options of every server-side apply made by the
+// controllers. With --force-apply, fields owned by other field managers are
+// taken over rather than rejected as a conflict.
+func applyOptions() metav1.PatchOptions {
+	force := forceApply
+	return metav1.PatchOptions{FieldManager: fieldManager, Force: &force, DryRun: dryRun()}
+}
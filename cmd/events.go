@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+// forbiddenLogInterval is the minimum time between two log lines for the same
+// denied verb and resource.
+const forbiddenLogInterval = time.Minute * 5
+
+// newEventRecorder creates an event recorder which publishes events to the API server.
+func newEventRecorder(kubeClient kubernetes.Interface) record.EventRecorder {
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+
+	return eventBroadcaster.NewRecorder(kubescheme.Scheme, corev1.EventSource{Component: "argo-controller"})
+}
+
+// forbiddenReporter turns Forbidden API errors into an actionable message.
+// Each denied verb/resource pair is reported once per namespace as a Warning
+// event, and logged at most once per forbiddenLogInterval.
+type forbiddenReporter struct {
+	recorder record.EventRecorder
+
+	mu       sync.Mutex
+	events   map[string]bool
+	lastLogs map[string]time.Time
+}
+
+// newForbiddenReporter creates a forbiddenReporter publishing events through recorder.
+func newForbiddenReporter(recorder record.EventRecorder) *forbiddenReporter {
+	return &forbiddenReporter{
+		recorder: recorder,
+		events:   map[string]bool{},
+		lastLogs: map[string]time.Time{},
+	}
+}
+
+// check reports err against obj if it is a Forbidden error, naming the verb
+// and resource which were denied. The error is always returned unchanged.
+func (r *forbiddenReporter) check(obj runtime.Object, verb, resource, namespace string, err error) error {
+	if !errors.IsForbidden(err) {
+		return err
+	}
+
+	message := fmt.Sprintf("forbidden to %s %s in namespace %s: grant the controller permission to %s %s", verb, resource, namespace, verb, resource)
+	key := fmt.Sprintf("%s/%s/%s", verb, resource, namespace)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.events[key] {
+		r.events[key] = true
+		r.recorder.Event(obj, corev1.EventTypeWarning, "Forbidden", message)
+	}
+
+	if last, ok := r.lastLogs[key]; !ok || time.Since(last) >= forbiddenLogInterval {
+		r.lastLogs[key] = time.Now()
+		klog.Warning(message)
+	}
+
+	return err
+}
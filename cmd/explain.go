@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	rbacv1listers "k8s.io/client-go/listers/rbac/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+var explainNamespace string
+
+var workflowsExplainCmd = &cobra.Command{
+	Use:   "explain",
+	Short: "Print the resources the workflows controller would generate for a namespace",
+	Long: `Read the namespace admins role bindings of --namespace and print, as YAML,
+the service accounts, role bindings and token secrets the workflows controller
+would generate for it, along with the rbac rules given to each group. Nothing
+is written to the cluster. Accepts the same flags as the workflows command.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateNamespace("namespace", explainNamespace); err != nil {
+			return err
+		}
+
+		return validateWorkflowsFlags()
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+
+		// Create Kubernetes config
+		cfg, err := buildConfig()
+		if err != nil {
+			klog.Fatalf("error building kubeconfig: %v", err)
+		}
+
+		kubeClient, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			klog.Fatalf("error building kubernetes clientset: %v", err)
+		}
+
+		// The namespace annotations decide whether user interface access
+		// is enabled. Without permission to read the namespace, it is
+		// explained as if it had none.
+		namespace, err := kubeClient.CoreV1().Namespaces().Get(ctx, explainNamespace, metav1.GetOptions{})
+		if errors.IsForbidden(err) {
+			klog.Warningf("cannot read namespace %s, assuming it has no annotations: %v", explainNamespace, err)
+			namespace = &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: explainNamespace}}
+		} else if err != nil {
+			klog.Fatalf("error getting namespace %s: %v", explainNamespace, err)
+		}
+
+		roleBindingLister, err := listRoleBindings(ctx, kubeClient, explainNamespace)
+		if err != nil {
+			klog.Fatalf("error listing role bindings: %v", err)
+		}
+
+		var annotations *groupAnnotations
+		if groupAnnotationsConfigMap != "" {
+			annotations = &groupAnnotations{}
+			configMap, err := kubeClient.CoreV1().ConfigMaps(groupAnnotationsConfigMapNamespace).Get(ctx, groupAnnotationsConfigMapName, metav1.GetOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				klog.Fatalf("error getting configmap %s: %v", groupAnnotationsConfigMap, err)
+			}
+			if err == nil {
+				annotations.update(configMap)
+			}
+		}
+
+		if err := explain(cmd.OutOrStdout(), namespace, roleBindingLister, annotations); err != nil {
+			klog.Fatalf("error explaining namespace %s: %v", explainNamespace, err)
+		}
+	},
+}
+
+// listRoleBindings lists the role bindings of namespace once, returning a
+// lister over them for the generate functions.
+func listRoleBindings(ctx context.Context, kubeClient kubernetes.Interface, namespace string) (rbacv1listers.RoleBindingLister, error) {
+	roleBindings, err := kubeClient.RbacV1().RoleBindings(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for i := range roleBindings.Items {
+		if err := indexer.Add(&roleBindings.Items[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return rbacv1listers.NewRoleBindingLister(indexer), nil
+}
+
+// explain writes the groups of namespace, with their rbac rules, and the
+// objects generated for it to out. The generated storage secrets are left
+// out, as they hold credentials.
+func explain(out io.Writer, namespace *corev1.Namespace, roleBindingLister rbacv1listers.RoleBindingLister, annotations *groupAnnotations) error {
+	groups, found, err := uiAccessGroups(namespace, roleBindingLister)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case namespace.Name == "argo-workflows-system":
+		fmt.Fprintf(out, "# %s is not reconciled\n", namespace.Name)
+		return nil
+	case isSelfNamespace(namespace.Name):
+		fmt.Fprintf(out, "# %s is the namespace of the controller, which is not reconciled under --skip-self-namespace\n", namespace.Name)
+		return nil
+	case !found:
+		fmt.Fprintf(out, "# no namespace admins role binding found in %s, nothing is generated\n", namespace.Name)
+		return nil
+	case !uiAccessEnabled(namespace):
+		fmt.Fprintf(out, "# user interface access is disabled in %s, only the runner objects are generated\n", namespace.Name)
+	}
+	for _, group := range groups {
+		fmt.Fprintf(out, "# group %s: rbac-rule \"'%s' in groups\", precedence %d\n", group, group, groupPrecedence(group))
+	}
+
+	serviceAccounts, err := generateServiceAccounts(namespace, roleBindingLister, annotations)
+	if err != nil {
+		return err
+	}
+	roleBindings, err := generateRoleBindings(namespace, roleBindingLister)
+	if err != nil {
+		return err
+	}
+	secrets, err := generateTokenSecrets(namespace, roleBindingLister)
+	if err != nil {
+		return err
+	}
+
+	objects := []interface{}{}
+	for _, serviceAccount := range serviceAccounts {
+		objects = append(objects, serviceAccount)
+	}
+	for _, roleBinding := range roleBindings {
+		objects = append(objects, roleBinding)
+	}
+	for _, secret := range secrets {
+		objects = append(objects, secret)
+	}
+
+	for _, obj := range objects {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "---\n%s", data)
+	}
+
+	return nil
+}
+
+func init() {
+	addWorkflowsFlags(workflowsExplainCmd.Flags())
+	workflowsExplainCmd.Flags().StringVar(&explainNamespace, "namespace", "", "The namespace to explain.")
+
+	workflowsExplainCmd.MarkFlagRequired("argo-workflows-cluster-role-name")
+	workflowsExplainCmd.MarkFlagRequired("namespace")
+
+	workflowsCmd.AddCommand(workflowsExplainCmd)
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	rbacv1listers "k8s.io/client-go/listers/rbac/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+)
+
+// adminsFinalizer is added to the namespace admins role bindings under
+// --use-finalizers, so the objects provisioned for their groups are removed
+// before the role binding is deleted.
+const adminsFinalizer = "argo-workflows.aurora/cleanup"
+
+// reconcileAdminsFinalizers adds the finalizer to the namespace admins role
+// bindings of the namespace when --use-finalizers is set, and cleans up after
+// the role bindings being deleted before releasing them. Role bindings being
+// deleted are always released, even with --use-finalizers unset, so turning
+// the flag off does not leave them stuck.
+func reconcileAdminsFinalizers(ctx context.Context, kubeClient kubernetes.Interface, namespace *corev1.Namespace, roleBindingLister rbacv1listers.RoleBindingLister) error {
+	roleBindings, err := namespaceAdminsRoleBindings(namespace, roleBindingLister)
+	if err != nil {
+		return err
+	}
+
+	// Groups still granted access by another admins role binding, or by
+	// --default-ui-groups, are kept
+	remaining, _, err := adminGroups(namespace, roleBindingLister)
+	if err != nil {
+		return err
+	}
+	keep := map[string]bool{}
+	for _, group := range withDefaultUIGroups(remaining) {
+		keep[group] = true
+	}
+
+	for _, roleBinding := range roleBindings {
+		switch {
+		case roleBinding.DeletionTimestamp != nil && hasFinalizer(roleBinding.Finalizers, adminsFinalizer):
+			for _, group := range subjectGroups([]*rbacv1.RoleBinding{roleBinding}) {
+				if keep[group] {
+					continue
+				}
+				if err := deleteGroupObjects(ctx, kubeClient, namespace.Name, group); err != nil {
+					return err
+				}
+				changesFrom(ctx).recordGroupRemoved(group)
+			}
+
+			klog.Infof("removing finalizer from role binding %s/%s", roleBinding.Namespace, roleBinding.Name)
+			if err := updateFinalizers(ctx, kubeClient, roleBinding.Namespace, roleBinding.Name, func(finalizers []string) []string {
+				return removeFinalizer(finalizers, adminsFinalizer)
+			}); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+
+		case roleBinding.DeletionTimestamp == nil && useFinalizers && !hasFinalizer(roleBinding.Finalizers, adminsFinalizer):
+			klog.Infof("adding finalizer to role binding %s/%s", roleBinding.Namespace, roleBinding.Name)
+			if err := updateFinalizers(ctx, kubeClient, roleBinding.Namespace, roleBinding.Name, func(finalizers []string) []string {
+				return append(finalizers, adminsFinalizer)
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// deleteGroupObjects deletes the role binding, service account and token
+// secret provisioned for a group.
+func deleteGroupObjects(ctx context.Context, kubeClient kubernetes.Interface, namespace, group string) error {
+	names, err := namesForGroup(namespace, group)
+	if err != nil {
+		return err
+	}
+	klog.Infof("deleting objects of group %s in namespace %s", group, namespace)
+	ctx = withAuditGroup(ctx, group)
+
+	del := func(kind, name string, deleteFunc func(context.Context, string, metav1.DeleteOptions) error) error {
+		apiSpan := startAPISpan(ctx, "Delete", kind, &metav1.ObjectMeta{Name: name, Namespace: namespace})
+		err := deleteFunc(ctx, name, deleteOptions())
+		apiSpan.End(err)
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, roleBinding := range names.roleBindings {
+		if err := del("RoleBinding", roleBinding, kubeClient.RbacV1().RoleBindings(namespace).Delete); err != nil {
+			return err
+		}
+	}
+	if err := del("ServiceAccount", names.serviceAccount, kubeClient.CoreV1().ServiceAccounts(namespace).Delete); err != nil {
+		return err
+	}
+
+	return del("Secret", names.secret, kubeClient.CoreV1().Secrets(namespace).Delete)
+}
+
+// updateFinalizers replaces the finalizers of a role binding with the result
+// of mutate, retrying on conflicts.
+func updateFinalizers(ctx context.Context, kubeClient kubernetes.Interface, namespace, name string, mutate func([]string) []string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		roleBinding, err := kubeClient.RbacV1().RoleBindings(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		previous := roleBinding.DeepCopy()
+		roleBinding.Finalizers = mutate(roleBinding.Finalizers)
+
+		apiSpan := startAPISpan(ctx, "Update", "RoleBinding", roleBinding).previous(previous)
+		_, err = kubeClient.RbacV1().RoleBindings(namespace).Update(ctx, roleBinding, updateOptions())
+		apiSpan.End(err)
+		return err
+	})
+}
+
+// hasFinalizer reports whether finalizers contains finalizer.
+func hasFinalizer(finalizers []string, finalizer string) bool {
+	for _, f := range finalizers {
+		if f == finalizer {
+			return true
+		}
+	}
+
+	return false
+}
+
+// removeFinalizer returns finalizers without finalizer.
+func removeFinalizer(finalizers []string, finalizer string) []string {
+	result := []string{}
+	for _, f := range finalizers {
+		if f != finalizer {
+			result = append(result, f)
+		}
+	}
+
+	return result
+}
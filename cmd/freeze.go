@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gccloudone-aurora/argo-controller/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// freezeConfigMapKey is the key of --freeze-configmap which freezes the
+// controller when set to "true" and unfreezes it when set to "false".
+const freezeConfigMapKey = "frozen"
+
+var freeze bool
+var freezeConfigMap string
+
+// The namespace and name of --freeze-configmap, set by validateFreezeFlags.
+var freezeConfigMapNamespace string
+var freezeConfigMapName string
+
+// freezeState is whether the controller is frozen. thawed is closed, and
+// replaced, every time the controller is unfrozen.
+var freezeState = struct {
+	mu     sync.Mutex
+	frozen bool
+	thawed chan struct{}
+}{thawed: make(chan struct{})}
+
+// validateFreezeFlags checks --freeze-configmap is of the form
+// namespace/name.
+func validateFreezeFlags() error {
+	if freezeConfigMap == "" {
+		return nil
+	}
+
+	parts := strings.Split(freezeConfigMap, "/")
+	if len(parts) != 2 {
+		return fmt.Errorf("--freeze-configmap: must be of the form namespace/name, got %q", freezeConfigMap)
+	}
+	if err := validateNamespace("freeze-configmap", parts[0]); err != nil {
+		return err
+	}
+	if err := validateName("freeze-configmap", parts[1]); err != nil {
+		return err
+	}
+	freezeConfigMapNamespace, freezeConfigMapName = parts[0], parts[1]
+
+	return nil
+}
+
+// frozen reports whether the controller is frozen, in which case every write
+// is sent as a server dry run.
+func frozen() bool {
+	freezeState.mu.Lock()
+	defer freezeState.mu.Unlock()
+
+	return freezeState.frozen
+}
+
+// setFrozen freezes or unfreezes the controller, logging the change.
+func setFrozen(frozen bool, source string) {
+	freezeState.mu.Lock()
+	defer freezeState.mu.Unlock()
+
+	if frozen {
+		metrics.Frozen.Set(1)
+	} else {
+		metrics.Frozen.Set(0)
+	}
+
+	if frozen == freezeState.frozen {
+		return
+	}
+	freezeState.frozen = frozen
+
+	if frozen {
+		klog.Warningf("frozen by %s: changes are logged but not persisted", source)
+		return
+	}
+	klog.Infof("unfrozen by %s", source)
+	close(freezeState.thawed)
+	freezeState.thawed = make(chan struct{})
+}
+
+// thawed returns a channel closed the next time the controller is unfrozen.
+func thawed() <-chan struct{} {
+	freezeState.mu.Lock()
+	defer freezeState.mu.Unlock()
+
+	return freezeState.thawed
+}
+
+// enqueueAllOnThaw calls enqueueAll every time the controller is unfrozen
+// until stopCh is closed, so that the changes held back while frozen are
+// made.
+func enqueueAllOnThaw(stopCh <-chan struct{}, enqueueAll func()) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-thawed():
+			enqueueAll()
+		}
+	}
+}
+
+// writesDisabled reports whether writes are sent as server dry runs, under
+// --server-dry-run or while frozen.
+func writesDisabled() bool {
+	return serverDryRun || frozen()
+}
+
+// watchFreezeConfigMap applies --freeze, then keeps the freeze state in step
+// with --freeze-configmap, if set, until stopCh is closed. A missing
+// ConfigMap, or one without the frozen key, falls back to --freeze. It
+// returns the function reporting whether the ConfigMap informer has synced.
+func watchFreezeConfigMap(kubeClient kubernetes.Interface, stopCh <-chan struct{}) cache.InformerSynced {
+	setFrozen(freeze, "--freeze")
+	if freezeConfigMap == "" {
+		return func() bool { return true }
+	}
+
+	factory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 0,
+		kubeinformers.WithNamespace(freezeConfigMapNamespace),
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", freezeConfigMapName).String()
+		}),
+	)
+	informer := factory.Core().V1().ConfigMaps().Informer()
+
+	source := "configmap " + freezeConfigMap
+	apply := func(obj interface{}) {
+		configMap, ok := obj.(*corev1.ConfigMap)
+		if !ok {
+			return
+		}
+
+		value, ok := configMap.Data[freezeConfigMapKey]
+		if !ok {
+			setFrozen(freeze, source)
+			return
+		}
+		frozen, err := strconv.ParseBool(value)
+		if err != nil {
+			klog.Errorf("%s: invalid %s value %q, keeping the current freeze state: %v", source, freezeConfigMapKey, value, err)
+			return
+		}
+		setFrozen(frozen, source)
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: apply,
+		UpdateFunc: func(old, new interface{}) {
+			apply(new)
+		},
+		DeleteFunc: func(obj interface{}) {
+			setFrozen(freeze, source)
+		},
+	})
+
+	factory.Start(stopCh)
+
+	return informer.HasSynced
+}
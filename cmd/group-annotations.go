@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+// groupAnnotationsKey is the key of --group-annotations-configmap holding a
+// YAML mapping of group names to the annotations of their service accounts.
+const groupAnnotationsKey = "groups.yaml"
+
+var groupAnnotationsConfigMap string
+
+// The namespace and name of --group-annotations-configmap, set by
+// validateGroupAnnotationsFlags.
+var groupAnnotationsConfigMapNamespace string
+var groupAnnotationsConfigMapName string
+
+// validateGroupAnnotationsFlags checks --group-annotations-configmap is of
+// the form namespace/name.
+func validateGroupAnnotationsFlags() error {
+	if groupAnnotationsConfigMap == "" {
+		return nil
+	}
+
+	parts := strings.Split(groupAnnotationsConfigMap, "/")
+	if len(parts) != 2 {
+		return fmt.Errorf("--group-annotations-configmap: must be of the form namespace/name, got %q", groupAnnotationsConfigMap)
+	}
+	if err := validateNamespace("group-annotations-configmap", parts[0]); err != nil {
+		return err
+	}
+	if err := validateName("group-annotations-configmap", parts[1]); err != nil {
+		return err
+	}
+	groupAnnotationsConfigMapNamespace, groupAnnotationsConfigMapName = parts[0], parts[1]
+
+	return nil
+}
+
+// groupAnnotations holds the annotations of the user interface service
+// account of each group, read from --group-annotations-configmap. Groups
+// missing from the ConfigMap get none.
+type groupAnnotations struct {
+	mu     sync.RWMutex
+	groups map[string]map[string]string
+}
+
+// forGroup returns the annotations of the service account of group.
+func (g *groupAnnotations) forGroup(group string) map[string]string {
+	if g == nil {
+		return nil
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.groups[group]
+}
+
+// update replaces the annotations with those of configMap, or removes them
+// all if configMap is nil, and reports whether they changed. Invalid content
+// is logged and the current annotations kept.
+func (g *groupAnnotations) update(configMap *corev1.ConfigMap) bool {
+	groups := map[string]map[string]string{}
+	if configMap != nil {
+		parsed, err := parseGroupAnnotations(configMap.Data[groupAnnotationsKey])
+		if err != nil {
+			klog.Errorf("configmap %s: invalid %s, keeping the current group annotations: %v", groupAnnotationsConfigMap, groupAnnotationsKey, err)
+			return false
+		}
+		groups = parsed
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if reflect.DeepEqual(groups, g.groups) {
+		return false
+	}
+	g.groups = groups
+
+	return true
+}
+
+// parseGroupAnnotations parses the YAML mapping of group names to
+// annotations, which must not set any annotation managed by the controller.
+func parseGroupAnnotations(data string) (map[string]map[string]string, error) {
+	groups := map[string]map[string]string{}
+	if err := yaml.Unmarshal([]byte(data), &groups); err != nil {
+		return nil, err
+	}
+
+	for group, annotations := range groups {
+		if err := validateAnnotations("group-annotations-configmap", annotations, reservedAnnotations); err != nil {
+			return nil, fmt.Errorf("group %q: %v", group, err)
+		}
+	}
+
+	return groups, nil
+}
+
+// watch keeps the annotations in step with --group-annotations-configmap
+// until stopCh is closed, calling onChange whenever they change. It returns
+// the function reporting whether the ConfigMap informer has synced.
+func (g *groupAnnotations) watch(kubeClient kubernetes.Interface, stopCh <-chan struct{}, onChange func()) cache.InformerSynced {
+	factory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 0,
+		kubeinformers.WithNamespace(groupAnnotationsConfigMapNamespace),
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", groupAnnotationsConfigMapName).String()
+		}),
+	)
+	informer := factory.Core().V1().ConfigMaps().Informer()
+
+	apply := func(configMap *corev1.ConfigMap) {
+		if g.update(configMap) {
+			klog.Infof("group annotations changed in configmap %s", groupAnnotationsConfigMap)
+			onChange()
+		}
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if configMap, ok := obj.(*corev1.ConfigMap); ok {
+				apply(configMap)
+			}
+		},
+		UpdateFunc: func(old, new interface{}) {
+			if configMap, ok := new.(*corev1.ConfigMap); ok {
+				apply(configMap)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			apply(nil)
+		},
+	})
+
+	factory.Start(stopCh)
+
+	return informer.HasSynced
+}
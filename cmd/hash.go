@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// specHashAnnotation holds a hash of the controller-managed fields of a
+// generated object. Comparing it against the hash of the desired object
+// avoids false positives from fields the API server defaults or reorders.
+const specHashAnnotation = "argo-controller/spec-hash"
+
+// computeSpecHash returns a stable hash of the given fields.
+func computeSpecHash(fields ...interface{}) (string, error) {
+	// encoding/json sorts map keys, so the output is deterministic
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// setSpecHash computes the hash of the given controller-managed fields and
+// stores it in the spec hash annotation of obj.
+func setSpecHash(obj metav1.Object, fields ...interface{}) (string, error) {
+	hash, err := computeSpecHash(fields...)
+	if err != nil {
+		return "", err
+	}
+
+	setAnnotation(obj, specHashAnnotation, hash)
+	return hash, nil
+}
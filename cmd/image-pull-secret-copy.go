@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog"
+)
+
+// imagePullSecretSourceAnnotation records the secret an image pull secret
+// was copied from.
+const imagePullSecretSourceAnnotation = "argo-controller/image-pull-secret-source"
+
+// newImagePullSecretSourceInformerFactory returns an informer factory which
+// only watches the source image pull secret, so that the secrets of the
+// source namespace are not all cached.
+func newImagePullSecretSourceInformerFactory(kubeClient kubernetes.Interface) kubeinformers.SharedInformerFactory {
+	return kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 0,
+		kubeinformers.WithNamespace(imagePullSecretSourceNamespace),
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", imagePullSecretName).String()
+		}),
+	)
+}
+
+// newImagePullSecretCopyInformerFactory returns an informer factory which
+// only watches the secrets named --image-pull-secret in --watch-namespace,
+// or in every namespace, so that the copies are read from the cache rather
+// than fetched on every reconcile.
+func newImagePullSecretCopyInformerFactory(kubeClient kubernetes.Interface) kubeinformers.SharedInformerFactory {
+	return kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 0,
+		kubeinformers.WithNamespace(watchNamespace),
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", imagePullSecretName).String()
+		}),
+	)
+}
+
+// copyImagePullSecret ensures namespace holds an up to date copy of the
+// source image pull secret, reading the current copy from lister. A secret
+// of the same name not created by the controller is left untouched, unless
+// --overwrite-image-pull-secret is set. The type of a secret cannot be
+// updated, so a copy of another type is deleted and created again. Forbidden
+// errors are reported against obj, naming the verb which was denied.
+func copyImagePullSecret(ctx context.Context, kubeClient kubernetes.Interface, forbidden *forbiddenReporter, obj runtime.Object, sourceLister, lister corev1listers.SecretLister, namespace string) error {
+	if namespace == imagePullSecretSourceNamespace {
+		return nil
+	}
+
+	source, err := sourceLister.Secrets(imagePullSecretSourceNamespace).Get(imagePullSecretName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("source image pull secret %s/%s not found", imagePullSecretSourceNamespace, imagePullSecretName)
+		}
+		return err
+	}
+
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      imagePullSecretName,
+			Namespace: namespace,
+			Labels:    mergeMaps(managedByLabels),
+			Annotations: map[string]string{
+				imagePullSecretSourceAnnotation: fmt.Sprintf("%s/%s", source.Namespace, source.Name),
+			},
+		},
+		Type: source.Type,
+		Data: source.Data,
+	}
+
+	create := func() error {
+		apiSpan := startAPISpan(ctx, "Create", "Secret", secret)
+		_, err := kubeClient.CoreV1().Secrets(namespace).Create(ctx, secret, createOptions())
+		apiSpan.End(err)
+		if errors.IsAlreadyExists(err) {
+			// Created by the reconcile of another service account of
+			// the namespace since the cache was read
+			return nil
+		}
+		return forbidden.check(obj, "create", "secrets", namespace, err)
+	}
+
+	current, err := lister.Secrets(namespace).Get(imagePullSecretName)
+	if errors.IsNotFound(err) {
+		klog.Infof("copying image pull secret %s/%s to namespace %s", source.Namespace, source.Name, namespace)
+		return create()
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, ok := current.Annotations[imagePullSecretSourceAnnotation]; !ok && !overwriteImagePullSecret {
+		klog.V(4).Infof("not overwriting user managed image pull secret %s/%s", namespace, imagePullSecretName)
+		return nil
+	}
+
+	if current.Type == secret.Type && equality.Semantic.DeepEqual(current.Data, secret.Data) && current.Annotations[imagePullSecretSourceAnnotation] == secret.Annotations[imagePullSecretSourceAnnotation] {
+		return nil
+	}
+
+	if current.Type != secret.Type {
+		klog.Infof("recreating image pull secret %s/%s, whose type changed from %s to %s", namespace, imagePullSecretName, current.Type, secret.Type)
+		apiSpan := startAPISpan(ctx, "Delete", "Secret", current)
+		err := kubeClient.CoreV1().Secrets(namespace).Delete(ctx, current.Name, metav1.DeleteOptions{
+			Preconditions: metav1.NewUIDPreconditions(string(current.UID)),
+			DryRun:        dryRun(),
+		})
+		apiSpan.End(err)
+		if err != nil && !errors.IsNotFound(err) {
+			return forbidden.check(obj, "delete", "secrets", namespace, err)
+		}
+		return create()
+	}
+
+	klog.Infof("updating image pull secret %s/%s from %s/%s", namespace, imagePullSecretName, source.Namespace, source.Name)
+	updated := current.DeepCopy()
+	updated.TypeMeta = secret.TypeMeta
+	updated.Data = secret.Data
+	mergeLabels(updated, secret.Labels)
+	mergeAnnotations(updated, secret.Annotations)
+
+	apiSpan := startAPISpan(ctx, "Update", "Secret", updated).previous(current)
+	_, err = kubeClient.CoreV1().Secrets(namespace).Update(ctx, updated, updateOptions())
+	apiSpan.End(err)
+	return forbidden.check(obj, "update", "secrets", namespace, err)
+}
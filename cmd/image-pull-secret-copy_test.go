@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestCopyImagePullSecret(t *testing.T) {
+	imagePullSecretName = "image-pull-secret"
+	imagePullSecretSourceNamespace = "source"
+	defer func() { imagePullSecretSourceNamespace = "" }()
+
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "image-pull-secret", Namespace: "source"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte("{}")},
+	}
+	copied := func(secretType corev1.SecretType, data string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "image-pull-secret",
+				Namespace:   "team-a",
+				UID:         "uid",
+				Labels:      mergeMaps(managedByLabels),
+				Annotations: map[string]string{imagePullSecretSourceAnnotation: "source/image-pull-secret"},
+			},
+			Type: secretType,
+			Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(data)},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		current *corev1.Secret
+		want    []string
+	}{
+		{name: "missing copy is created", current: nil, want: []string{"create"}},
+		{name: "up to date copy is left alone", current: copied(corev1.SecretTypeDockerConfigJson, "{}"), want: []string{}},
+		{name: "outdated copy is updated", current: copied(corev1.SecretTypeDockerConfigJson, "old"), want: []string{"update"}},
+		{name: "copy of another type is recreated", current: copied(corev1.SecretTypeOpaque, "{}"), want: []string{"delete", "create"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objects := []runtime.Object{}
+			if test.current != nil {
+				objects = append(objects, test.current)
+			}
+			kubeClient := fake.NewSimpleClientset(objects...)
+			forbidden := newForbiddenReporter(record.NewFakeRecorder(10))
+			namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}
+
+			err := copyImagePullSecret(context.Background(), kubeClient, forbidden, namespace, newSecretLister(source), newSecretLister(objects...), "team-a")
+			if err != nil {
+				t.Fatalf("copyImagePullSecret: %v", err)
+			}
+
+			verbs := []string{}
+			for _, action := range kubeClient.Actions() {
+				verbs = append(verbs, action.GetVerb())
+			}
+			if !equalStrings(verbs, test.want) {
+				t.Errorf("got actions %v, want %v", verbs, test.want)
+			}
+
+			if len(test.want) > 0 {
+				secret, err := kubeClient.CoreV1().Secrets("team-a").Get(context.Background(), "image-pull-secret", metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("get copy: %v", err)
+				}
+				if secret.Type != source.Type || string(secret.Data[corev1.DockerConfigJsonKey]) != "{}" {
+					t.Errorf("got copy of type %s with data %q, want a copy of the source", secret.Type, secret.Data[corev1.DockerConfigJsonKey])
+				}
+			}
+		})
+	}
+}
+
+func TestCopyImagePullSecretReportsFailedVerb(t *testing.T) {
+	imagePullSecretName = "image-pull-secret"
+	imagePullSecretSourceNamespace = "source"
+	defer func() { imagePullSecretSourceNamespace = "" }()
+
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "image-pull-secret", Namespace: "source"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte("{}")},
+	}
+	current := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "image-pull-secret",
+			Namespace:   "team-a",
+			Annotations: map[string]string{imagePullSecretSourceAnnotation: "source/image-pull-secret"},
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+	}
+
+	kubeClient := fake.NewSimpleClientset(current)
+	kubeClient.PrependReactor("update", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, forbiddenError("image-pull-secret")
+	})
+	recorder := record.NewFakeRecorder(10)
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}
+
+	err := copyImagePullSecret(context.Background(), kubeClient, newForbiddenReporter(recorder), namespace, newSecretLister(source), newSecretLister(current), "team-a")
+	if err == nil {
+		t.Fatal("got no error, want a Forbidden error")
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if want := "forbidden to update secrets"; !strings.Contains(event, want) {
+			t.Errorf("got event %q, want it to mention %q", event, want)
+		}
+	default:
+		t.Error("got no event, want a Forbidden event")
+	}
+}
@@ -1,110 +1,457 @@
 package cmd
 
 import (
-	"context"
-	"time"
+	"fmt"
+	"strings"
 
 	"github.com/gccloudone-aurora/argo-controller/pkg/controllers/serviceaccounts"
+	"github.com/gccloudone-aurora/argo-controller/pkg/debug"
+	"github.com/gccloudone-aurora/argo-controller/pkg/reconcile"
 	"github.com/gccloudone-aurora/argo-controller/pkg/signals"
+	"github.com/gccloudone-aurora/argo-controller/pkg/tracing"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/validation"
 	kubeinformers "k8s.io/client-go/informers"
+	corev1informers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/klog"
 )
 
 var imagePullSecretName string
+var imagePullSecretWorkers int
+var imagePullSecretSourceNamespace string
+var overwriteImagePullSecret bool
+var targetPartOfValues []string
+var targetLabelSelectorFlag string
+var alwaysTargetServiceAccountsFlag []string
+var includeDefaultServiceAccount bool
+var defaultServiceAccountNamespaceSelectorFlag string
+
+// defaultServiceAccountNamespaceSelector is the parsed
+// --default-sa-namespace-selector, set by validateImagePullSecretsFlags.
+var defaultServiceAccountNamespaceSelector labels.Selector
+
+// targetSelector is the parsed --target-label-selector or, when unset, a
+// selector of the --target-part-of-values, set by
+// validateImagePullSecretsFlags.
+var targetSelector labels.Selector
+
+// alwaysTargetServiceAccounts holds the namespace/name keys of the service
+// accounts given the image pull secret whatever their labels.
+var alwaysTargetServiceAccounts map[string]bool
+
+// imagePullSecretsAnnotation records, on a service account, the comma
+// separated image pull secrets added by the controller, so they can be
+// removed again once the service account no longer matches the selector.
+const imagePullSecretsAnnotation = "argo-controller/image-pull-secrets"
 
 var imagePullSecretsCmd = &cobra.Command{
 	Use:   "image-pull-secrets",
 	Short: "Configure image pull secrets for Argo resources",
 	Long:  `Configure image pull secrets for Argo resources`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return validateImagePullSecretsFlags()
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		// Setup signals so we can shutdown cleanly
-		stopCh := signals.SetupSignalHandler()
+		stopCh, _ := signals.SetupSignalHandler()
 
-		// Create Kubernetes config
-		cfg, err := clientcmd.BuildConfigFromFlags(apiserver, kubeconfig)
-		if err != nil {
-			klog.Fatalf("error building kubeconfig: %v", err)
-		}
+		kubeClient, forbidden := setupController(stopCh, imagePullSecretsPermissions())
 
-		kubeClient, err := kubernetes.NewForConfig(cfg)
-		if err != nil {
-			klog.Fatalf("Error building kubernetes clientset: %s", err.Error())
-		}
+		runAsLeader(kubeClient, "argo-controller-image-pull-secrets", stopCh, func(stopCh <-chan struct{}) {
+			kubeInformerFactory := newInformerFactory(kubeClient)
 
-		// Setup informers
-		kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Minute*5)
-
-		// Serviceaccount informer
-		serviceAccountsInformer := kubeInformerFactory.Core().V1().ServiceAccounts()
-		// serviceAccountsLister := serviceAccountsInformer.Lister()
-
-		// Setup controller
-		controller := serviceaccounts.NewController(
-			serviceAccountsInformer,
-			func(serviceAccount *corev1.ServiceAccount) error {
-				if val, ok := serviceAccount.Labels["app.kubernetes.io/part-of"]; ok && val == "argocd" {
-					found := false
-					for _, imagePullSecret := range serviceAccount.ImagePullSecrets {
-						if imagePullSecret.Name == imagePullSecretName {
-							found = true
-							break
-						}
-					}
+			// Setup controller
+			controller, synced := newImagePullSecretsController(stopCh, kubeClient, kubeInformerFactory, forbidden)
+			setReadyCheck("informers", syncedCheck(synced))
+
+			// Start informers
+			kubeInformerFactory.Start(stopCh)
+
+			// Wait for caches
+			klog.Info("Waiting for informer caches to sync")
+			if ok := waitForCacheSync(stopCh, synced...); !ok {
+				if stopped(stopCh) {
+					return
+				}
+				klog.Fatalf("failed to wait for caches to sync within %s, see --cache-sync-timeout", cacheSyncTimeoutOrDefault())
+			}
+
+			// Run the controller
+			if err := controller.Run(imagePullSecretWorkers, stopCh); err != nil && !stopped(stopCh) {
+				klog.Fatalf("error running controller: %v", err)
+			}
+		})
+	},
+}
+
+// newImagePullSecretsController creates the image pull secrets controller,
+// registering the informers it needs with kubeInformerFactory. It returns the
+// controller and the functions reporting whether those informers have synced.
+// When the image pull secret is copied from a source namespace, the informer
+// watching the source secret is started here until stopCh is closed.
+func newImagePullSecretsController(stopCh <-chan struct{}, kubeClient kubernetes.Interface, kubeInformerFactory kubeinformers.SharedInformerFactory, forbidden *forbiddenReporter) (*serviceaccounts.Controller, []cache.InformerSynced) {
+	// Serviceaccount informer
+	serviceAccountsInformer := kubeInformerFactory.Core().V1().ServiceAccounts()
+	// serviceAccountsLister := serviceAccountsInformer.Lister()
+
+	// Source image pull secret informer, and informer of its copies
+	var sourceFactory, copyFactory kubeinformers.SharedInformerFactory
+	var sourceSecretsInformer, copySecretsInformer corev1informers.SecretInformer
+	if imagePullSecretSourceNamespace != "" {
+		sourceFactory = newImagePullSecretSourceInformerFactory(kubeClient)
+		sourceSecretsInformer = sourceFactory.Core().V1().Secrets()
+		copyFactory = newImagePullSecretCopyInformerFactory(kubeClient)
+		copySecretsInformer = copyFactory.Core().V1().Secrets()
+	}
+
+	// Namespace informer, only to select the default service accounts by
+	// the labels of their namespace
+	var namespaceInformer corev1informers.NamespaceInformer
+	var namespaceLister corev1listers.NamespaceLister
+	if includeDefaultServiceAccount && !defaultServiceAccountNamespaceSelector.Empty() {
+		namespaceInformer = kubeInformerFactory.Core().V1().Namespaces()
+		namespaceLister = namespaceInformer.Lister()
+	}
+	targeted := func(serviceAccount *corev1.ServiceAccount) bool {
+		return matchesImagePullSecretSelector(serviceAccount) || matchesDefaultServiceAccount(serviceAccount, namespaceLister)
+	}
+
+	// Reconciles in flight are cancelled when stopCh is closed, such as when
+	// leadership is lost
+	stopCtx := stopContext(stopCh)
 
-					if !found {
-						klog.Infof("Adding image pull secret to %s/%s", serviceAccount.Namespace, serviceAccount.Name)
+	// Setup controller
+	controller := serviceaccounts.NewController(
+		"image-pull-secrets",
+		serviceAccountsInformer,
+		func(serviceAccount *corev1.ServiceAccount) (err error) {
+			ctx, span := tracing.Start(stopCtx, "Reconcile",
+				tracing.String("k8s.namespace.name", serviceAccount.Namespace),
+				tracing.String("k8s.serviceaccount.name", serviceAccount.Name),
+			)
+			defer func() { span.End(err) }()
 
-						// Add the image pull secret
-						updated := serviceAccount.DeepCopy()
-						updated.ImagePullSecrets = append(serviceAccount.ImagePullSecrets, corev1.LocalObjectReference{Name: imagePullSecretName})
-						if _, err := kubeClient.CoreV1().ServiceAccounts(serviceAccount.Namespace).Update(context.Background(), updated, metav1.UpdateOptions{}); err != nil {
-							return err
+			// Make sure the referenced secret exists before referencing it
+			if targeted(serviceAccount) && sourceSecretsInformer != nil {
+				if err := copyImagePullSecret(ctx, kubeClient, forbidden, serviceAccount, sourceSecretsInformer.Lister(), copySecretsInformer.Lister(), serviceAccount.Namespace); err != nil {
+					return reconcile.Wrap(reconcile.PhaseApply, "Secret", err)
+				}
+			}
+
+			if desired, added := desiredImagePullSecretState(serviceAccount, targeted(serviceAccount)); !sameImagePullSecrets(serviceAccount.ImagePullSecrets, desired) || serviceAccount.Annotations[imagePullSecretsAnnotation] != added {
+				klog.Infof("Updating image pull secrets of %s/%s", serviceAccount.Namespace, serviceAccount.Name)
+
+				err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+					// Someone else may have changed them since we last looked
+					desired, added := desiredImagePullSecretState(serviceAccount, targeted(serviceAccount))
+					if sameImagePullSecrets(serviceAccount.ImagePullSecrets, desired) && serviceAccount.Annotations[imagePullSecretsAnnotation] == added {
+						return nil
+					}
+
+					// Add or remove the image pull secrets
+					updated := serviceAccount.DeepCopy()
+					updated.ImagePullSecrets = desired
+					if added != "" {
+						setAnnotation(updated, imagePullSecretsAnnotation, added)
+					} else {
+						delete(updated.Annotations, imagePullSecretsAnnotation)
+					}
+					apiSpan := startAPISpan(ctx, "Update", "ServiceAccount", updated).previous(serviceAccount)
+					_, err := kubeClient.CoreV1().ServiceAccounts(serviceAccount.Namespace).Update(ctx, updated, updateOptions())
+					apiSpan.End(err)
+					if errors.IsConflict(err) {
+						// The informer copy is stale, fetch the live object before retrying
+						if live, getErr := kubeClient.CoreV1().ServiceAccounts(serviceAccount.Namespace).Get(ctx, serviceAccount.Name, metav1.GetOptions{}); getErr == nil {
+							serviceAccount = live
 						}
 					}
+					return err
+				})
+				if err != nil {
+					return reconcile.Wrap(reconcile.PhaseApply, "ServiceAccount", forbidden.check(serviceAccount, "update", "serviceaccounts", serviceAccount.Namespace, err))
 				}
+			}
 
-				return nil
-			},
-		)
+			return nil
+		},
+	)
+
+	serviceAccountsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.HandleObject,
+		UpdateFunc: func(old, new interface{}) {
+			// Periodic resyncs deliver the same version of the object
+			if !resourceVersionChanged(old, new) {
+				return
+			}
+
+			controller.HandleObject(new)
+		},
+	})
 
-		serviceAccountsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-			AddFunc: controller.HandleObject,
+	synced := []cache.InformerSynced{serviceAccountsInformer.Informer().HasSynced}
+	debug.RegisterInformer("serviceaccounts", serviceAccountsInformer.Informer().HasSynced)
+
+	if namespaceInformer != nil {
+		// Add or remove the image pull secret of the default service
+		// account when its namespace starts or stops matching
+		namespaceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 			UpdateFunc: func(old, new interface{}) {
-				newNP := new.(*corev1.ServiceAccount)
-				oldNP := old.(*corev1.ServiceAccount)
+				oldNamespace, oldOk := old.(*corev1.Namespace)
+				newNamespace, newOk := new.(*corev1.Namespace)
+				if !oldOk || !newOk || labels.Equals(oldNamespace.Labels, newNamespace.Labels) {
+					return
+				}
+
+				controller.EnqueueServiceAccount(cache.ExplicitKey(newNamespace.Name + "/default"))
+			},
+		})
+
+		synced = append(synced, namespaceInformer.Informer().HasSynced)
+		debug.RegisterInformer("namespaces", namespaceInformer.Informer().HasSynced)
+	}
 
-				if newNP.ResourceVersion == oldNP.ResourceVersion {
+	if sourceSecretsInformer != nil {
+		// Propagate changes of the source secret to every copy
+		sourceSecretsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(old, new interface{}) {
+				if !resourceVersionChanged(old, new) {
 					return
 				}
 
-				controller.HandleObject(new)
+				klog.Infof("source image pull secret %s/%s changed", imagePullSecretSourceNamespace, imagePullSecretName)
+				controller.EnqueueAll()
 			},
 		})
 
-		// Start informers
-		kubeInformerFactory.Start(stopCh)
+		sourceFactory.Start(stopCh)
+		synced = append(synced, sourceSecretsInformer.Informer().HasSynced)
+		debug.RegisterInformer("image-pull-secret-source", sourceSecretsInformer.Informer().HasSynced)
 
-		// Wait for caches
-		klog.Info("Waiting for informer caches to sync")
-		if ok := cache.WaitForCacheSync(stopCh, serviceAccountsInformer.Informer().HasSynced); !ok {
-			klog.Fatalf("failed to wait for caches to sync")
+		// The copies are only read from the cache, their changes are
+		// picked up by the next resync of the service accounts
+		synced = append(synced, copySecretsInformer.Informer().HasSynced)
+		debug.RegisterInformer("image-pull-secret-copies", copySecretsInformer.Informer().HasSynced)
+		copyFactory.Start(stopCh)
+	}
+
+	// Make the changes held back while frozen once unfrozen
+	go enqueueAllOnThaw(stopCh, controller.EnqueueAll)
+
+	return controller, synced
+}
+
+// managedImagePullSecrets returns the names of the image pull secrets added
+// by the controller, in the order they are listed on service accounts.
+func managedImagePullSecrets() []string {
+	return []string{imagePullSecretName}
+}
+
+// matchesImagePullSecretSelector reports whether the controller adds the
+// image pull secrets to a service account: it must be listed in
+// --always-target-service-accounts, or its labels must match
+// --target-label-selector, by default the --target-part-of-values.
+func matchesImagePullSecretSelector(serviceAccount *corev1.ServiceAccount) bool {
+	if alwaysTargetServiceAccounts[serviceAccount.Namespace+"/"+serviceAccount.Name] {
+		return true
+	}
+
+	return targetSelector.Matches(labels.Set(serviceAccount.Labels))
+}
+
+// matchesDefaultServiceAccount reports whether the controller adds the image
+// pull secrets to a service account because it is the default service account
+// of a namespace matching --default-sa-namespace-selector, under
+// --include-default-sa. namespaceLister is nil when the selector is empty.
+func matchesDefaultServiceAccount(serviceAccount *corev1.ServiceAccount, namespaceLister corev1listers.NamespaceLister) bool {
+	if !includeDefaultServiceAccount || serviceAccount.Name != "default" {
+		return false
+	}
+	if namespaceLister == nil {
+		return true
+	}
+
+	namespace, err := namespaceLister.Get(serviceAccount.Namespace)
+	if err != nil {
+		return false
+	}
+
+	return defaultServiceAccountNamespaceSelector.Matches(labels.Set(namespace.Labels))
+}
+
+// desiredImagePullSecretState returns the image pull secrets a service
+// account should reference, and the value of its image pull secrets
+// annotation, which is empty when it should not be set. A service account
+// targeted by the controller gets the managed image pull secrets; one which
+// is not loses those recorded as added by the controller. Secrets the service
+// account already referenced before the controller added them are not
+// recorded, so they are never removed.
+func desiredImagePullSecretState(serviceAccount *corev1.ServiceAccount, targeted bool) ([]corev1.LocalObjectReference, string) {
+	added := map[string]bool{}
+	for _, name := range strings.Split(serviceAccount.Annotations[imagePullSecretsAnnotation], ",") {
+		if name != "" {
+			added[name] = true
 		}
+	}
 
-		// Run the controller
-		if err = controller.Run(2, stopCh); err != nil {
-			klog.Fatalf("error running controller: %v", err)
+	if !targeted {
+		kept := []corev1.LocalObjectReference{}
+		for _, imagePullSecret := range serviceAccount.ImagePullSecrets {
+			if !added[imagePullSecret.Name] {
+				kept = append(kept, imagePullSecret)
+			}
 		}
-	},
+
+		return kept, ""
+	}
+
+	referenced := map[string]bool{}
+	for _, imagePullSecret := range serviceAccount.ImagePullSecrets {
+		referenced[imagePullSecret.Name] = true
+	}
+
+	recorded := []string{}
+	for _, name := range managedImagePullSecrets() {
+		if added[name] || !referenced[name] {
+			recorded = append(recorded, name)
+		}
+	}
+
+	return desiredImagePullSecrets(serviceAccount.ImagePullSecrets), strings.Join(recorded, ",")
+}
+
+// desiredImagePullSecrets returns the image pull secrets a service account
+// should reference: the secrets added by users, in their original order,
+// followed by the secrets managed by the controller in a fixed order.
+// Duplicates are removed, so the result is stable across reconciles.
+func desiredImagePullSecrets(current []corev1.LocalObjectReference) []corev1.LocalObjectReference {
+	managed := map[string]bool{}
+	for _, name := range managedImagePullSecrets() {
+		managed[name] = true
+	}
+
+	seen := map[string]bool{}
+	desired := []corev1.LocalObjectReference{}
+	for _, imagePullSecret := range current {
+		if managed[imagePullSecret.Name] || seen[imagePullSecret.Name] {
+			continue
+		}
+		seen[imagePullSecret.Name] = true
+		desired = append(desired, imagePullSecret)
+	}
+
+	for _, name := range managedImagePullSecrets() {
+		desired = append(desired, corev1.LocalObjectReference{Name: name})
+	}
+
+	return desired
+}
+
+// sameImagePullSecrets reports whether a and b reference the same image pull
+// secrets in the same order.
+func sameImagePullSecrets(a, b []corev1.LocalObjectReference) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validateImagePullSecretsFlags validates the flags of the image pull
+// secrets controller.
+func validateImagePullSecretsFlags() error {
+	if imagePullSecretWorkers < 1 {
+		return fmt.Errorf("--image-pull-secret-workers: must be at least 1, got %d", imagePullSecretWorkers)
+	}
+
+	if targetLabelSelectorFlag != "" {
+		selector, err := labels.Parse(targetLabelSelectorFlag)
+		if err != nil {
+			return fmt.Errorf("--target-label-selector: %v", err)
+		}
+		if selector.Empty() {
+			return fmt.Errorf("--target-label-selector: must select at least one label")
+		}
+		targetSelector = selector
+	} else {
+		if len(targetPartOfValues) == 0 {
+			return fmt.Errorf("--target-part-of-values: at least one value is required")
+		}
+		for _, value := range targetPartOfValues {
+			if errs := validation.IsValidLabelValue(value); value == "" || len(errs) > 0 {
+				return fmt.Errorf("--target-part-of-values: invalid label value %q: %s", value, strings.Join(errs, "; "))
+			}
+		}
+		requirement, err := labels.NewRequirement("app.kubernetes.io/part-of", selection.In, targetPartOfValues)
+		if err != nil {
+			return fmt.Errorf("--target-part-of-values: %v", err)
+		}
+		targetSelector = labels.NewSelector().Add(*requirement)
+	}
+
+	alwaysTargetServiceAccounts = map[string]bool{}
+	for _, entry := range alwaysTargetServiceAccountsFlag {
+		parts := strings.Split(entry, "/")
+		if len(parts) != 2 {
+			return fmt.Errorf("--always-target-service-accounts: entry %q must be of the form namespace/name", entry)
+		}
+		if err := validateNamespace("always-target-service-accounts", parts[0]); err != nil {
+			return err
+		}
+		if err := validateName("always-target-service-accounts", parts[1]); err != nil {
+			return err
+		}
+		alwaysTargetServiceAccounts[entry] = true
+	}
+
+	selector, err := labels.Parse(defaultServiceAccountNamespaceSelectorFlag)
+	if err != nil {
+		return fmt.Errorf("--default-sa-namespace-selector: %v", err)
+	}
+	if !selector.Empty() && !includeDefaultServiceAccount {
+		return fmt.Errorf("--default-sa-namespace-selector: requires --include-default-sa")
+	}
+	defaultServiceAccountNamespaceSelector = selector
+
+	if imagePullSecretSourceNamespace != "" {
+		if err := validateNamespace("image-pull-secret-source-namespace", imagePullSecretSourceNamespace); err != nil {
+			return err
+		}
+	}
+
+	return validateName("image-pull-secret", imagePullSecretName)
 }
 
 func init() {
-	imagePullSecretsCmd.Flags().StringVar(&imagePullSecretName, "image-pull-secret", "image-pull-secret", "Name of the secret containing the image pull credentials.")
+	addImagePullSecretsFlags(imagePullSecretsCmd.Flags())
 
 	rootCmd.AddCommand(imagePullSecretsCmd)
 }
+
+// addImagePullSecretsFlags registers the flags of the image pull secrets
+// controller.
+func addImagePullSecretsFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&imagePullSecretName, "image-pull-secret", "image-pull-secret", "Name of the secret containing the image pull credentials.")
+	flags.StringVar(&imagePullSecretSourceNamespace, "image-pull-secret-source-namespace", "", "Namespace holding the image pull secret, which is copied into every namespace where it is referenced and kept in sync. When unset, the secret must already exist in each namespace.")
+	flags.BoolVar(&overwriteImagePullSecret, "overwrite-image-pull-secret", false, "Overwrite an existing image pull secret not created by the controller with the copy from --image-pull-secret-source-namespace.")
+	flags.StringSliceVar(&targetPartOfValues, "target-part-of-values", []string{"argocd"}, "Values of the app.kubernetes.io/part-of label, comma separated, of the service accounts given the image pull secret. Ignored when --target-label-selector is set.")
+	flags.StringVar(&targetLabelSelectorFlag, "target-label-selector", "", "Label selector of the service accounts given the image pull secret, replacing --target-part-of-values. Supports =, !=, in, notin, and existence (key and !key) requirements, such as app.kubernetes.io/instance to target the service accounts of any Argo CD instance.")
+	flags.StringSliceVar(&alwaysTargetServiceAccountsFlag, "always-target-service-accounts", []string{}, "Service accounts (namespace/name), comma separated, given the image pull secret whatever their labels.")
+	flags.BoolVar(&includeDefaultServiceAccount, "include-default-sa", false, "Also add the image pull secret to the default service account of every namespace, or of the namespaces matching --default-sa-namespace-selector.")
+	flags.StringVar(&defaultServiceAccountNamespaceSelectorFlag, "default-sa-namespace-selector", "", "Label selector of the namespaces whose default service account is given the image pull secret under --include-default-sa. Empty selects every namespace.")
+	flags.IntVar(&imagePullSecretWorkers, "image-pull-secret-workers", 2, "Number of service accounts reconciled in parallel by the image pull secrets controller.")
+}
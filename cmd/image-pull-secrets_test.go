@@ -0,0 +1,318 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// setupImagePullSecretsFlags sets the flags of the image pull secrets
+// controller to their defaults, with the given overrides applied by set, and
+// validates them.
+func setupImagePullSecretsFlags(t *testing.T, set func()) {
+	t.Helper()
+
+	imagePullSecretName = "image-pull-secret"
+	imagePullSecretWorkers = 2
+	imagePullSecretSourceNamespace = ""
+	targetPartOfValues = []string{"argocd"}
+	targetLabelSelectorFlag = ""
+	alwaysTargetServiceAccountsFlag = []string{}
+	includeDefaultServiceAccount = false
+	defaultServiceAccountNamespaceSelectorFlag = ""
+	if set != nil {
+		set()
+	}
+
+	if err := validateImagePullSecretsFlags(); err != nil {
+		t.Fatalf("validateImagePullSecretsFlags: %v", err)
+	}
+}
+
+func imagePullSecretNames(references []corev1.LocalObjectReference) []string {
+	names := []string{}
+	for _, reference := range references {
+		names = append(names, reference.Name)
+	}
+
+	return names
+}
+
+func references(names ...string) []corev1.LocalObjectReference {
+	refs := []corev1.LocalObjectReference{}
+	for _, name := range names {
+		refs = append(refs, corev1.LocalObjectReference{Name: name})
+	}
+
+	return refs
+}
+
+func TestImagePullSecretsOrdering(t *testing.T) {
+	setupImagePullSecretsFlags(t, nil)
+
+	tests := []struct {
+		name    string
+		current []string
+		want    []string
+	}{
+		{name: "added after the user secrets", current: []string{"user-b", "user-a"}, want: []string{"user-b", "user-a", "image-pull-secret"}},
+		{name: "moved after the user secrets", current: []string{"image-pull-secret", "user-a"}, want: []string{"user-a", "image-pull-secret"}},
+		{name: "duplicates removed", current: []string{"user-a", "image-pull-secret", "user-a", "image-pull-secret"}, want: []string{"user-a", "image-pull-secret"}},
+		{name: "already in order", current: []string{"user-a", "image-pull-secret"}, want: []string{"user-a", "image-pull-secret"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := imagePullSecretNames(desiredImagePullSecrets(references(test.current...)))
+			if !equalStrings(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestImagePullSecretsStableAcrossReconciles(t *testing.T) {
+	setupImagePullSecretsFlags(t, nil)
+
+	serviceAccount := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "argocd-server",
+			Namespace: "argocd",
+			Labels:    map[string]string{"app.kubernetes.io/part-of": "argocd"},
+		},
+		ImagePullSecrets: references("user-b", "user-a"),
+	}
+
+	// The first reconcile updates the service account
+	desired, added := desiredImagePullSecretState(serviceAccount, matchesImagePullSecretSelector(serviceAccount))
+	if sameImagePullSecrets(serviceAccount.ImagePullSecrets, desired) {
+		t.Fatalf("first reconcile: got no update, want %v", imagePullSecretNames(desired))
+	}
+	serviceAccount.ImagePullSecrets = desired
+	serviceAccount.Annotations = map[string]string{imagePullSecretsAnnotation: added}
+
+	// The next two find it already correct
+	for i := 0; i < 2; i++ {
+		desired, added := desiredImagePullSecretState(serviceAccount, matchesImagePullSecretSelector(serviceAccount))
+		if !sameImagePullSecrets(serviceAccount.ImagePullSecrets, desired) || serviceAccount.Annotations[imagePullSecretsAnnotation] != added {
+			t.Errorf("reconcile %d: got an update to %v (annotation %q), want none", i+2, imagePullSecretNames(desired), added)
+		}
+	}
+}
+
+// reconcileImagePullSecrets applies the desired image pull secret state to
+// serviceAccount, as a reconcile would.
+func reconcileImagePullSecrets(serviceAccount *corev1.ServiceAccount) {
+	desired, added := desiredImagePullSecretState(serviceAccount, matchesImagePullSecretSelector(serviceAccount))
+	serviceAccount.ImagePullSecrets = desired
+	if added != "" {
+		setAnnotation(serviceAccount, imagePullSecretsAnnotation, added)
+	} else {
+		delete(serviceAccount.Annotations, imagePullSecretsAnnotation)
+	}
+}
+
+func TestImagePullSecretsRemovedOnceUntargeted(t *testing.T) {
+	setupImagePullSecretsFlags(t, nil)
+
+	tests := []struct {
+		name          string
+		initial       []string
+		wantTargeted  []string
+		wantAnnotated string
+		wantRemoved   []string
+	}{
+		{
+			name:          "added by the controller",
+			initial:       []string{"user-a"},
+			wantTargeted:  []string{"user-a", "image-pull-secret"},
+			wantAnnotated: "image-pull-secret",
+			wantRemoved:   []string{"user-a"},
+		},
+		{
+			name:          "referenced by the user first",
+			initial:       []string{"image-pull-secret", "user-a"},
+			wantTargeted:  []string{"user-a", "image-pull-secret"},
+			wantAnnotated: "",
+			wantRemoved:   []string{"user-a", "image-pull-secret"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serviceAccount := &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd-server",
+					Namespace: "argocd",
+					Labels:    map[string]string{"app.kubernetes.io/part-of": "argocd"},
+				},
+				ImagePullSecrets: references(test.initial...),
+			}
+
+			reconcileImagePullSecrets(serviceAccount)
+			if got := imagePullSecretNames(serviceAccount.ImagePullSecrets); !equalStrings(got, test.wantTargeted) {
+				t.Errorf("targeted: got %v, want %v", got, test.wantTargeted)
+			}
+			if got := serviceAccount.Annotations[imagePullSecretsAnnotation]; got != test.wantAnnotated {
+				t.Errorf("targeted: got annotation %q, want %q", got, test.wantAnnotated)
+			}
+
+			// The service account is relabelled and no longer targeted
+			serviceAccount.Labels = map[string]string{"app.kubernetes.io/part-of": "other"}
+			reconcileImagePullSecrets(serviceAccount)
+			if got := imagePullSecretNames(serviceAccount.ImagePullSecrets); !equalStrings(got, test.wantRemoved) {
+				t.Errorf("untargeted: got %v, want %v", got, test.wantRemoved)
+			}
+			if got, ok := serviceAccount.Annotations[imagePullSecretsAnnotation]; ok {
+				t.Errorf("untargeted: got annotation %q, want none", got)
+			}
+		})
+	}
+}
+
+func TestMatchesTargetPartOfValues(t *testing.T) {
+	setupImagePullSecretsFlags(t, func() {
+		targetPartOfValues = []string{"argocd", "argo-cd"}
+	})
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   bool
+	}{
+		{name: "argocd", labels: map[string]string{"app.kubernetes.io/part-of": "argocd"}, want: true},
+		{name: "argo-cd", labels: map[string]string{"app.kubernetes.io/part-of": "argo-cd"}, want: true},
+		{name: "other value", labels: map[string]string{"app.kubernetes.io/part-of": "argo-workflows"}, want: false},
+		{name: "different case", labels: map[string]string{"app.kubernetes.io/part-of": "ArgoCD"}, want: false},
+		{name: "value on another label", labels: map[string]string{"app.kubernetes.io/name": "argocd"}, want: false},
+		{name: "no labels", want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serviceAccount := &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{Name: "argocd-server", Namespace: "argocd", Labels: test.labels},
+			}
+
+			if got := matchesImagePullSecretSelector(serviceAccount); got != test.want {
+				t.Errorf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateTargetPartOfValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		wantErr bool
+	}{
+		{name: "several values", values: []string{"argocd", "argo-cd"}},
+		{name: "none", values: []string{}, wantErr: true},
+		{name: "empty value", values: []string{"argocd", ""}, wantErr: true},
+		{name: "invalid value", values: []string{"argo cd"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupImagePullSecretsFlags(t, nil)
+			targetPartOfValues = test.values
+
+			if err := validateImagePullSecretsFlags(); (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestMatchesTargetLabelSelector(t *testing.T) {
+	instance := map[string]string{"app.kubernetes.io/instance": "argocd-prod"}
+	partOf := map[string]string{"app.kubernetes.io/part-of": "argocd"}
+
+	tests := []struct {
+		name     string
+		selector string
+		always   []string
+		account  string
+		labels   map[string]string
+		want     bool
+	}{
+		{name: "equality", selector: "app.kubernetes.io/part-of=argocd", labels: partOf, want: true},
+		{name: "equality mismatch", selector: "app.kubernetes.io/part-of=argo-cd", labels: partOf, want: false},
+		{name: "in", selector: "app.kubernetes.io/part-of in (argocd,argo-cd)", labels: partOf, want: true},
+		{name: "notin", selector: "app.kubernetes.io/part-of notin (argocd)", labels: partOf, want: false},
+		{name: "exists", selector: "app.kubernetes.io/instance", labels: instance, want: true},
+		{name: "exists without the label", selector: "app.kubernetes.io/instance", labels: partOf, want: false},
+		{name: "does not exist", selector: "!app.kubernetes.io/instance", labels: partOf, want: true},
+		{name: "several requirements", selector: "app.kubernetes.io/instance,app.kubernetes.io/part-of", labels: instance, want: false},
+		{
+			name:     "named and label selected",
+			selector: "app.kubernetes.io/instance",
+			always:   []string{"tools/deployer"},
+			account:  "argocd/argocd-server",
+			labels:   instance,
+			want:     true,
+		},
+		{
+			name:     "named but not label selected",
+			selector: "app.kubernetes.io/instance",
+			always:   []string{"tools/deployer"},
+			account:  "tools/deployer",
+			want:     true,
+		},
+		{
+			name:     "neither named nor label selected",
+			selector: "app.kubernetes.io/instance",
+			always:   []string{"tools/deployer"},
+			account:  "tools/other",
+			want:     false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupImagePullSecretsFlags(t, func() {
+				targetLabelSelectorFlag = test.selector
+				alwaysTargetServiceAccountsFlag = test.always
+			})
+
+			namespace, name := "argocd", "argocd-server"
+			if test.account != "" {
+				parts := strings.SplitN(test.account, "/", 2)
+				namespace, name = parts[0], parts[1]
+			}
+			serviceAccount := &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: test.labels},
+			}
+
+			if got := matchesImagePullSecretSelector(serviceAccount); got != test.want {
+				t.Errorf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateTargetLabelSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector string
+		wantErr  bool
+	}{
+		{name: "set based", selector: "app.kubernetes.io/part-of in (argocd,argo-cd),!legacy"},
+		{name: "invalid", selector: "app.kubernetes.io/part-of in argocd", wantErr: true},
+		{name: "empty after parsing", selector: " ", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupImagePullSecretsFlags(t, nil)
+			targetLabelSelectorFlag = test.selector
+
+			if err := validateImagePullSecretsFlags(); (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
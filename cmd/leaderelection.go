@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog"
+)
+
+// The timings of the leader election lease. A leader which cannot renew its
+// lease within leaderElectRenewDeadline stops leading, well before another
+// instance may take over after leaderElectLeaseDuration.
+const (
+	leaderElectLeaseDuration = time.Second * 15
+	leaderElectRenewDeadline = time.Second * 10
+	leaderElectRetryPeriod   = time.Second * 2
+)
+
+var leaderElect bool
+var leaderElectNamespace string
+
+// runAsLeader calls run every time this instance becomes the leader, with a
+// channel closed as soon as it stops leading, until stopCh is closed. run must
+// stop all work and return once its channel is closed; a new term does not
+// start until the previous call has returned, so the writes of two terms never
+// overlap. Without --leader-elect, run is called once with stopCh.
+func runAsLeader(kubeClient kubernetes.Interface, name string, stopCh <-chan struct{}, run func(stopCh <-chan struct{})) {
+	if !leaderElect {
+		startTerm()
+		defer endTerm()
+		run(stopCh)
+		return
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		klog.Fatalf("error getting hostname for leader election: %v", err)
+	}
+	// The suffix tells apart restarts of the same pod
+	identity := hostname + "_" + rand.String(8)
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: leaderElectNamespace,
+		},
+		Client: kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	ctx := stopContext(stopCh)
+
+	// Held for the whole of a term, so that a term only starts once the
+	// previous one has wound down
+	var term sync.Mutex
+
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:          lock,
+			Name:          name,
+			LeaseDuration: leaderElectLeaseDuration,
+			RenewDeadline: leaderElectRenewDeadline,
+			RetryPeriod:   leaderElectRetryPeriod,
+			// The lease is not released on shutdown, as the reconciles
+			// of the term may still be finishing
+			ReleaseOnCancel: false,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(termCtx context.Context) {
+					term.Lock()
+					defer term.Unlock()
+
+					// The term may already be over by the time the
+					// previous one has wound down
+					if termCtx.Err() != nil {
+						return
+					}
+
+					klog.Infof("acquired leadership of %s/%s as %s", leaderElectNamespace, name, identity)
+					startTerm()
+					defer endTerm()
+					run(termCtx.Done())
+				},
+				OnStoppedLeading: func() {
+					klog.Infof("stopped leading %s/%s", leaderElectNamespace, name)
+				},
+			},
+		})
+	}
+
+	// Wait for the last term to wind down
+	term.Lock()
+	term.Unlock()
+}
+
+// stopContext returns a context cancelled when stopCh is closed.
+func stopContext(stopCh <-chan struct{}) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	return ctx
+}
+
+// stopped reports whether stopCh is closed.
+func stopped(stopCh <-chan struct{}) bool {
+	select {
+	case <-stopCh:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gccloudone-aurora/argo-controller/pkg/controllers/namespaces"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// TestRapidLeaderChanges runs many short terms in a row, each ending while a
+// reconcile is in flight, and checks every term cancels its reconciles and
+// winds down before the next one starts.
+func TestRapidLeaderChanges(t *testing.T) {
+	leaderElect = false
+
+	const terms = 20
+	var inFlight int32
+	for i := 0; i < terms; i++ {
+		termStopCh := make(chan struct{})
+		started := make(chan struct{}, 1)
+		go func() {
+			<-started
+			close(termStopCh)
+		}()
+
+		begin := time.Now()
+		runAsLeader(nil, "test", termStopCh, func(stopCh <-chan struct{}) {
+			ctx := stopContext(stopCh)
+			controller := namespaces.NewNamespacedController("test", "team-a", time.Hour, func(*corev1.Namespace) error {
+				atomic.AddInt32(&inFlight, 1)
+				defer atomic.AddInt32(&inFlight, -1)
+				select {
+				case started <- struct{}{}:
+				default:
+				}
+
+				// A reconcile outliving its term would run for a minute
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(time.Minute):
+					return nil
+				}
+			})
+
+			if err := controller.Run(2, stopCh); err != nil {
+				t.Errorf("term %d: Run() = %v", i, err)
+			}
+		})
+
+		if n := atomic.LoadInt32(&inFlight); n != 0 {
+			t.Fatalf("term %d: %d reconciles still running once it ended", i, n)
+		}
+		if elapsed := time.Since(begin); elapsed > 10*time.Second {
+			t.Fatalf("term %d: took %s to wind down, want its reconciles cancelled", i, elapsed)
+		}
+	}
+}
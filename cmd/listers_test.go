@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	rbacv1listers "k8s.io/client-go/listers/rbac/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newIndexer returns an indexer holding objects, as an informer cache would.
+func newIndexer(objects ...runtime.Object) cache.Indexer {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, obj := range objects {
+		if err := indexer.Add(obj); err != nil {
+			panic(err)
+		}
+	}
+
+	return indexer
+}
+
+func newSecretLister(objects ...runtime.Object) corev1listers.SecretLister {
+	return corev1listers.NewSecretLister(newIndexer(objects...))
+}
+
+func newServiceAccountLister(objects ...runtime.Object) corev1listers.ServiceAccountLister {
+	return corev1listers.NewServiceAccountLister(newIndexer(objects...))
+}
+
+func newNamespaceLister(objects ...runtime.Object) corev1listers.NamespaceLister {
+	return corev1listers.NewNamespaceLister(newIndexer(objects...))
+}
+
+func newRoleBindingLister(objects ...runtime.Object) rbacv1listers.RoleBindingLister {
+	return rbacv1listers.NewRoleBindingLister(newIndexer(objects...))
+}
+
+// equalStrings reports whether a and b hold the same strings in the same
+// order.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/gccloudone-aurora/argo-controller/pkg/reconcile"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// defaultGroupNameTemplate is the name of the objects provisioned for a group
+// when no name template is set.
+const defaultGroupNameTemplate = "argo-workflows-{{.Group}}"
+
+var serviceAccountNameTemplate string
+var roleBindingNameTemplate string
+var secretNameTemplate string
+
+// The parsed name templates, set by validateNameTemplates.
+var serviceAccountNameTmpl *template.Template
+var roleBindingNameTmpl *template.Template
+var secretNameTmpl *template.Template
+
+// groupNameData is what the name templates are evaluated with.
+type groupNameData struct {
+	Namespace string
+	Group     string
+}
+
+// validateNameTemplates parses the name templates, checking that they render
+// valid names which differ between groups.
+func validateNameTemplates() error {
+	var err error
+	if serviceAccountNameTmpl, err = parseNameTemplate("sa-name-template", serviceAccountNameTemplate); err != nil {
+		return err
+	}
+	if roleBindingNameTmpl, err = parseNameTemplate("rolebinding-name-template", roleBindingNameTemplate); err != nil {
+		return err
+	}
+	if secretNameTmpl, err = parseNameTemplate("secret-name-template", secretNameTemplate); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// parseNameTemplate parses the template supplied through flag and renders it
+// for two sample groups, so that mistakes are reported at startup rather than
+// on every reconcile.
+func parseNameTemplate(flag, text string) (*template.Template, error) {
+	tmpl, err := template.New(flag).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("--%s: invalid template: %v", flag, err)
+	}
+
+	first, err := renderName(tmpl, "namespace", "group-a")
+	if err != nil {
+		return nil, fmt.Errorf("--%s: %v", flag, err)
+	}
+	second, err := renderName(tmpl, "namespace", "group-b")
+	if err != nil {
+		return nil, fmt.Errorf("--%s: %v", flag, err)
+	}
+	if first == second {
+		return nil, fmt.Errorf("--%s: must include {{.Group}}, so that every group gets its own name", flag)
+	}
+
+	return tmpl, nil
+}
+
+// renderName evaluates a name template for a group of a namespace, and checks
+// the result is a valid object name.
+func renderName(tmpl *template.Template, namespace, group string) (string, error) {
+	var name strings.Builder
+	if err := tmpl.Execute(&name, groupNameData{Namespace: namespace, Group: group}); err != nil {
+		return "", fmt.Errorf("error rendering name template: %v", err)
+	}
+
+	if errs := validation.IsDNS1123Subdomain(name.String()); len(errs) > 0 {
+		return "", fmt.Errorf("%w %q rendered for group %q in namespace %q: %s", reconcile.ErrInvalidName, name.String(), group, namespace, strings.Join(errs, "; "))
+	}
+
+	return name.String(), nil
+}
+
+// groupNames are the names of the objects provisioned for a group.
+type groupNames struct {
+	serviceAccount string
+	secret         string
+
+	// roleBindings holds a role binding name for each of the user
+	// interface cluster roles, in the same order
+	roleBindings []string
+}
+
+// namesForGroup renders the names of the objects provisioned for a group of
+// a namespace. The role binding to the first user interface cluster role is
+// named after the template; the role bindings to any further cluster roles
+// add the name of the cluster role as a suffix.
+func namesForGroup(namespace, group string) (groupNames, error) {
+	var names groupNames
+	var err error
+	if names.serviceAccount, err = renderName(serviceAccountNameTmpl, namespace, group); err != nil {
+		return groupNames{}, err
+	}
+	if names.secret, err = renderName(secretNameTmpl, namespace, group); err != nil {
+		return groupNames{}, err
+	}
+
+	roleBinding, err := renderName(roleBindingNameTmpl, namespace, group)
+	if err != nil {
+		return groupNames{}, err
+	}
+	for i, clusterRole := range userInterfaceCRs {
+		name := roleBinding
+		if i > 0 {
+			name = fmt.Sprintf("%s-%s", roleBinding, clusterRole)
+		}
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return groupNames{}, fmt.Errorf("%w %q for group %q in namespace %q: %s", reconcile.ErrInvalidName, name, group, namespace, strings.Join(errs, "; "))
+		}
+		names.roleBindings = append(names.roleBindings, name)
+	}
+
+	return names, nil
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ownedAnnotationsAnnotation lists, comma-separated, the annotation keys set
+// by the controller on a generated service account, so that the keys it no
+// longer sets can be removed without touching those added by others.
+const ownedAnnotationsAnnotation = "argo-controller/owned-annotations"
+
+// setOwnedAnnotations records the annotation keys of obj as owned by the
+// controller. The spec hash is left out, as it is always set.
+func setOwnedAnnotations(obj metav1.Object) {
+	keys := []string{}
+	for key := range obj.GetAnnotations() {
+		if key != ownedAnnotationsAnnotation && key != specHashAnnotation {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	setAnnotation(obj, ownedAnnotationsAnnotation, strings.Join(keys, ","))
+}
+
+// ownedAnnotations returns the annotation keys of obj recorded as owned by the
+// controller. Objects last updated before the keys were recorded own none.
+func ownedAnnotations(obj metav1.Object) []string {
+	value := obj.GetAnnotations()[ownedAnnotationsAnnotation]
+	if value == "" {
+		return nil
+	}
+
+	return strings.Split(value, ",")
+}
+
+// mergeOwnedAnnotations sets the annotations of desired on live, and removes
+// those live owned which desired no longer sets. Annotations added by others
+// are left untouched.
+func mergeOwnedAnnotations(live, desired metav1.Object) {
+	annotations := live.GetAnnotations()
+	for _, key := range ownedAnnotations(live) {
+		if _, ok := desired.GetAnnotations()[key]; !ok {
+			delete(annotations, key)
+		}
+	}
+	live.SetAnnotations(annotations)
+
+	mergeAnnotations(live, desired.GetAnnotations())
+}
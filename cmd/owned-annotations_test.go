@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func annotatedServiceAccount(annotations map[string]string) *corev1.ServiceAccount {
+	return &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "argo-workflows", Namespace: "team-a", Annotations: annotations},
+	}
+}
+
+func TestSetOwnedAnnotations(t *testing.T) {
+	serviceAccount := annotatedServiceAccount(map[string]string{
+		"b":                "2",
+		"a":                "1",
+		specHashAnnotation: "hash",
+	})
+
+	setOwnedAnnotations(serviceAccount)
+
+	if got := serviceAccount.Annotations[ownedAnnotationsAnnotation]; got != "a,b" {
+		t.Errorf("got owned annotations %q, want %q", got, "a,b")
+	}
+	if got := ownedAnnotations(serviceAccount); !equalStrings(got, []string{"a", "b"}) {
+		t.Errorf("got %v, want [a b]", got)
+	}
+}
+
+func TestMergeOwnedAnnotations(t *testing.T) {
+	tests := []struct {
+		name    string
+		live    map[string]string
+		desired map[string]string
+		want    map[string]string
+	}{
+		{
+			name:    "add",
+			live:    map[string]string{"a": "1"},
+			desired: map[string]string{"a": "1", "b": "2"},
+			want:    map[string]string{"a": "1", "b": "2", ownedAnnotationsAnnotation: "a,b"},
+		},
+		{
+			name:    "update",
+			live:    map[string]string{"a": "1", ownedAnnotationsAnnotation: "a"},
+			desired: map[string]string{"a": "2"},
+			want:    map[string]string{"a": "2", ownedAnnotationsAnnotation: "a"},
+		},
+		{
+			name:    "remove",
+			live:    map[string]string{"a": "1", "b": "2", ownedAnnotationsAnnotation: "a,b"},
+			desired: map[string]string{"a": "1"},
+			want:    map[string]string{"a": "1", ownedAnnotationsAnnotation: "a"},
+		},
+		{
+			name:    "preserve foreign",
+			live:    map[string]string{"a": "1", "b": "2", "foreign": "x", ownedAnnotationsAnnotation: "a,b"},
+			desired: map[string]string{"a": "1"},
+			want:    map[string]string{"a": "1", "foreign": "x", ownedAnnotationsAnnotation: "a"},
+		},
+		{
+			// Objects updated before the keys were recorded own none, so
+			// nothing is removed from them
+			name:    "nothing recorded",
+			live:    map[string]string{"a": "1", "b": "2"},
+			desired: map[string]string{"a": "1"},
+			want:    map[string]string{"a": "1", "b": "2", ownedAnnotationsAnnotation: "a"},
+		},
+		{
+			name:    "no live annotations",
+			desired: map[string]string{"a": "1"},
+			want:    map[string]string{"a": "1", ownedAnnotationsAnnotation: "a"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			live := annotatedServiceAccount(test.live)
+			desired := annotatedServiceAccount(test.desired)
+			setOwnedAnnotations(desired)
+
+			mergeOwnedAnnotations(live, desired)
+
+			if !reflect.DeepEqual(live.Annotations, test.want) {
+				t.Errorf("got %v, want %v", live.Annotations, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"sync"
+
+	"github.com/gccloudone-aurora/argo-controller/pkg/controllers/namespaces"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var tokenSecretOwnerReferences bool
+
+// serviceAccountUIDs records the UIDs of the service accounts applied during
+// a reconcile, by name. It is safe for concurrent use.
+type serviceAccountUIDs struct {
+	mu   sync.Mutex
+	uids map[string]types.UID
+}
+
+func newServiceAccountUIDs() *serviceAccountUIDs {
+	return &serviceAccountUIDs{uids: map[string]types.UID{}}
+}
+
+// record is the Applied hook of the service account Applier.
+func (s *serviceAccountUIDs) record(current namespaces.Object) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.uids[current.GetName()] = current.GetUID()
+}
+
+// setOwner makes the token secret owned by its service account, so that it is
+// garbage collected when the service account is deleted. Nothing is set if the
+// service account was not applied, such as when it is not managed.
+func (s *serviceAccountUIDs) setOwner(secret *corev1.Secret) {
+	name := secret.Annotations["kubernetes.io/service-account.name"]
+
+	s.mu.Lock()
+	uid, ok := s.uids[name]
+	s.mu.Unlock()
+	if !ok || uid == "" {
+		return
+	}
+
+	secret.OwnerReferences = []metav1.OwnerReference{
+		{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "ServiceAccount",
+			Name:       name,
+			UID:        uid,
+		},
+	}
+}
+
+// mergeOwnerReferences sets each of the given owner references on obj,
+// replacing any reference to an owner of the same kind and name, such as a
+// service account since recreated, and leaving the others untouched.
+func mergeOwnerReferences(obj metav1.Object, references []metav1.OwnerReference) {
+	merged := obj.GetOwnerReferences()
+	for _, reference := range references {
+		replaced := false
+		for i, existing := range merged {
+			if existing.APIVersion == reference.APIVersion && existing.Kind == reference.Kind && existing.Name == reference.Name {
+				merged[i] = reference
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, reference)
+		}
+	}
+	obj.SetOwnerReferences(merged)
+}
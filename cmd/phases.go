@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+)
+
+// applyPhase is the writes of the objects of one kind. The writes of a phase
+// may run in parallel with each other, but a phase only starts once every
+// phase it depends on has completed without error.
+type applyPhase struct {
+	kind      string
+	dependsOn []string
+	count     int
+	apply     func(i int) error
+}
+
+// runPhases runs the phases one at a time, in an order satisfying their
+// dependencies and otherwise in the order given, running up to concurrency
+// writes of a phase in parallel. The first phase to fail stops the ones after
+// it, so no object is written before the objects it refers to exist.
+func runPhases(phases []applyPhase, concurrency int) error {
+	ordered, err := orderPhases(phases)
+	if err != nil {
+		return err
+	}
+
+	for _, phase := range ordered {
+		if err := runParallel(phase.count, concurrency, phase.apply); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// orderPhases sorts the phases so that each comes after the phases it
+// depends on, keeping the given order between independent phases. An unknown
+// dependency or a dependency cycle is an error.
+func orderPhases(phases []applyPhase) ([]applyPhase, error) {
+	known := map[string]bool{}
+	for _, phase := range phases {
+		known[phase.kind] = true
+	}
+	for _, phase := range phases {
+		for _, dependency := range phase.dependsOn {
+			if !known[dependency] {
+				return nil, fmt.Errorf("%s depends on unknown phase %s", phase.kind, dependency)
+			}
+		}
+	}
+
+	ordered := []applyPhase{}
+	done := map[string]bool{}
+	for len(ordered) < len(phases) {
+		progress := false
+		for _, phase := range phases {
+			if done[phase.kind] || !dependenciesDone(phase, done) {
+				continue
+			}
+			ordered = append(ordered, phase)
+			done[phase.kind] = true
+			progress = true
+			break
+		}
+		if !progress {
+			return nil, fmt.Errorf("dependency cycle between phases")
+		}
+	}
+
+	return ordered, nil
+}
+
+// dependenciesDone reports whether every phase phase depends on is done.
+func dependenciesDone(phase applyPhase, done map[string]bool) bool {
+	for _, dependency := range phase.dependsOn {
+		if !done[dependency] {
+			return false
+		}
+	}
+
+	return true
+}
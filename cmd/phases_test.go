@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRunPhasesOrderingParallel(t *testing.T) {
+	var mu sync.Mutex
+	events := []string{}
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+	phase := func(kind string, dependsOn ...string) applyPhase {
+		return applyPhase{
+			kind:      kind,
+			dependsOn: dependsOn,
+			count:     5,
+			apply: func(i int) error {
+				record("start " + kind)
+				time.Sleep(time.Millisecond)
+				record("end " + kind)
+				return nil
+			},
+		}
+	}
+
+	// Given in the reverse order of their dependencies
+	err := runPhases([]applyPhase{
+		phase("Secret", "ServiceAccount"),
+		phase("RoleBinding", "ServiceAccount"),
+		phase("ServiceAccount"),
+	}, 4)
+	if err != nil {
+		t.Fatalf("runPhases: %v", err)
+	}
+
+	lastServiceAccount, firstDependent := -1, len(events)
+	for i, event := range events {
+		switch event {
+		case "end ServiceAccount":
+			lastServiceAccount = i
+		case "start RoleBinding", "start Secret":
+			if i < firstDependent {
+				firstDependent = i
+			}
+		}
+	}
+	if len(events) != 30 {
+		t.Errorf("got %d events, want 30", len(events))
+	}
+	if lastServiceAccount > firstDependent {
+		t.Errorf("got a dependent write started before every service account was written: %v", events)
+	}
+}
+
+func TestRunPhasesFailureStopsDependents(t *testing.T) {
+	applied := map[string]int{}
+	var mu sync.Mutex
+	phase := func(kind string, err error, dependsOn ...string) applyPhase {
+		return applyPhase{
+			kind:      kind,
+			dependsOn: dependsOn,
+			count:     3,
+			apply: func(i int) error {
+				mu.Lock()
+				applied[kind]++
+				mu.Unlock()
+				return err
+			},
+		}
+	}
+
+	err := runPhases([]applyPhase{
+		phase("ServiceAccount", errors.New("denied")),
+		phase("RoleBinding", nil, "ServiceAccount"),
+	}, 4)
+	if err == nil {
+		t.Errorf("got no error, want the service account error")
+	}
+	if applied["RoleBinding"] != 0 {
+		t.Errorf("got %d role bindings written, want none", applied["RoleBinding"])
+	}
+}
+
+func TestOrderPhasesErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		phases []applyPhase
+	}{
+		{
+			name:   "unknown dependency",
+			phases: []applyPhase{{kind: "RoleBinding", dependsOn: []string{"ServiceAccount"}}},
+		},
+		{
+			name: "cycle",
+			phases: []applyPhase{
+				{kind: "RoleBinding", dependsOn: []string{"ServiceAccount"}},
+				{kind: "ServiceAccount", dependsOn: []string{"RoleBinding"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := orderPhases(test.phases); err == nil {
+				t.Errorf("got no error, want an error")
+			}
+		})
+	}
+}
+
+func TestReconcileCreatesServiceAccountsFirst(t *testing.T) {
+	setupWorkflowsFlags(t, func() {
+		perNamespaceConcurrency = 4
+	})
+	defer func() { perNamespaceConcurrency = 1 }()
+
+	kubeClient, err := runWorkflowsOnce(t, newNamespace("team-a"), newAdminsRoleBinding("team-a",
+		groupSubject("team-a-admins"), groupSubject("team-a-readers"), groupSubject("team-a-operators"),
+	))
+	if err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+
+	lastServiceAccount, firstDependent := -1, len(kubeClient.Actions())
+	for i, action := range kubeClient.Actions() {
+		create, ok := action.(k8stesting.CreateAction)
+		if !ok {
+			continue
+		}
+		switch object := create.GetObject().(type) {
+		case *corev1.ServiceAccount:
+			lastServiceAccount = i
+		case *corev1.Secret:
+			if object.Type == corev1.SecretTypeServiceAccountToken && i < firstDependent {
+				firstDependent = i
+			}
+		default:
+			if create.GetResource().Resource == "rolebindings" && i < firstDependent {
+				firstDependent = i
+			}
+		}
+	}
+	if lastServiceAccount < 0 || firstDependent == len(kubeClient.Actions()) {
+		t.Fatalf("got writes %v, want service accounts, role bindings and token secrets created", writes(kubeClient))
+	}
+	if lastServiceAccount > firstDependent {
+		t.Errorf("got a role binding or token secret created before every service account: %v", writes(kubeClient))
+	}
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// permission is an API permission the controller needs.
+type permission struct {
+	group     string
+	resource  string
+	verbs     []string
+	namespace string
+}
+
+// workflowsPermissions returns the permissions needed by the workflows
+// controller, scoped to --watch-namespace if it is set.
+func workflowsPermissions() []permission {
+	permissions := []permission{
+		{resource: "serviceaccounts", verbs: []string{"get", "list", "watch", "create", "update", "delete"}, namespace: watchNamespace},
+		{group: "rbac.authorization.k8s.io", resource: "rolebindings", verbs: []string{"get", "list", "watch", "create", "update", "delete"}, namespace: watchNamespace},
+		{resource: "secrets", verbs: []string{"get", "list", "watch", "create", "update", "delete"}, namespace: watchNamespace},
+	}
+
+	// Adopted objects are taken over with a server-side apply
+	if adoptExisting {
+		for i := range permissions {
+			permissions[i].verbs = append(permissions[i].verbs, "patch")
+		}
+	}
+
+	// Namespaces are only read, and annotated with the reconcile status,
+	// when watching the whole cluster
+	if watchNamespace == "" {
+		permissions = append(permissions, permission{resource: "namespaces", verbs: []string{"list", "watch", "patch"}})
+	}
+
+	// The storage credentials are copied from their source namespace
+	if secretProviderName == "secret" {
+		permissions = append(permissions, permission{resource: "secrets", verbs: []string{"list", "watch"}, namespace: secretProviderNamespace})
+	}
+
+	// The group annotations are read from their ConfigMap
+	if groupAnnotationsConfigMap != "" {
+		permissions = append(permissions, permission{resource: "configmaps", verbs: []string{"list", "watch"}, namespace: groupAnnotationsConfigMapNamespace})
+	}
+
+	// The referenced cluster roles are watched cluster-wide
+	if watchClusterRoles {
+		permissions = append(permissions, permission{group: "rbac.authorization.k8s.io", resource: "clusterroles", verbs: []string{"list", "watch"}})
+	}
+
+	return permissions
+}
+
+// imagePullSecretsPermissions returns the permissions needed by the image
+// pull secrets controller, scoped to --watch-namespace if it is set.
+func imagePullSecretsPermissions() []permission {
+	permissions := []permission{
+		{resource: "serviceaccounts", verbs: []string{"get", "list", "watch", "update"}, namespace: watchNamespace},
+	}
+
+	// Copying the image pull secret from its source namespace
+	if imagePullSecretSourceNamespace != "" {
+		permissions = append(permissions,
+			permission{resource: "secrets", verbs: []string{"list", "watch"}, namespace: imagePullSecretSourceNamespace},
+			permission{resource: "secrets", verbs: []string{"list", "watch", "create", "update", "delete"}, namespace: watchNamespace},
+		)
+	}
+
+	// Selecting the default service accounts by the labels of their
+	// namespace
+	if includeDefaultServiceAccount && defaultServiceAccountNamespaceSelectorFlag != "" {
+		permissions = append(permissions, permission{resource: "namespaces", verbs: []string{"list", "watch"}})
+	}
+
+	return permissions
+}
+
+// leaderElectionPermissions returns the permissions needed to hold the
+// leader election lease in --leader-elect-namespace.
+func leaderElectionPermissions() []permission {
+	return []permission{
+		{group: "coordination.k8s.io", resource: "leases", verbs: []string{"get", "create", "update"}, namespace: leaderElectNamespace},
+	}
+}
+
+// freezePermissions returns the permissions needed to watch
+// --freeze-configmap.
+func freezePermissions() []permission {
+	return []permission{
+		{resource: "configmaps", verbs: []string{"list", "watch"}, namespace: freezeConfigMapNamespace},
+	}
+}
+
+// preflight checks with SelfSubjectAccessReviews that the controller holds
+// the given permissions, and logs a table of the results. An error listing
+// the denied permissions is returned if any are missing.
+func preflight(kubeClient kubernetes.Interface, permissions []permission) error {
+	var table bytes.Buffer
+	w := tabwriter.NewWriter(&table, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERB\tRESOURCE\tNAMESPACE\tRESULT")
+
+	denied := []string{}
+	for _, p := range permissions {
+		resource := p.resource
+		if p.group != "" {
+			resource = p.resource + "." + p.group
+		}
+		namespace := p.namespace
+		if namespace == "" {
+			namespace = "*"
+		}
+
+		for _, verb := range p.verbs {
+			review, err := kubeClient.AuthorizationV1().SelfSubjectAccessReviews().Create(context.Background(), &authorizationv1.SelfSubjectAccessReview{
+				Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorizationv1.ResourceAttributes{
+						Namespace: p.namespace,
+						Verb:      verb,
+						Group:     p.group,
+						Resource:  p.resource,
+					},
+				},
+			}, metav1.CreateOptions{})
+
+			result := "allowed"
+			switch {
+			case err != nil:
+				result = fmt.Sprintf("unknown (%v)", err)
+				denied = append(denied, fmt.Sprintf("%s %s in %s", verb, resource, namespace))
+			case !review.Status.Allowed:
+				result = "denied"
+				denied = append(denied, fmt.Sprintf("%s %s in %s", verb, resource, namespace))
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", verb, resource, namespace, result)
+		}
+	}
+	w.Flush()
+
+	klog.Info("preflight permission checks:")
+	for _, line := range strings.Split(strings.TrimSpace(table.String()), "\n") {
+		klog.Info(line)
+	}
+
+	if len(denied) > 0 {
+		return fmt.Errorf("missing permissions: %s", strings.Join(denied, ", "))
+	}
+
+	return nil
+}
+
+// runPreflight runs the preflight checks, failing startup on missing
+// permissions under --strict-preflight and only warning otherwise.
+func runPreflight(kubeClient kubernetes.Interface, permissions []permission) {
+	if err := preflight(kubeClient, permissions); err != nil {
+		if strictPreflight {
+			klog.Fatalf("preflight failed: %v", err)
+		}
+
+		klog.Warningf("preflight failed, continuing anyway: %v", err)
+	}
+}
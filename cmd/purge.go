@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+)
+
+var purgeConfirm bool
+var purgeDryRun bool
+
+var workflowsPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Delete every resource created by the workflows controller",
+	Long: `Delete every service account, role binding and secret created by the
+workflows controller, in all namespaces or only in --watch-namespace.
+Resources are found by their app.kubernetes.io/managed-by=argo-controller label.
+The argo-workflows.aurora/cleanup finalizer is removed from every role binding
+carrying it, so that deleting them is not left blocked.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if !purgeConfirm && !purgeDryRun {
+			return fmt.Errorf("--confirm is required to delete resources; use --dry-run to list what would be deleted")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Create Kubernetes config
+		cfg, err := buildConfig()
+		if err != nil {
+			klog.Fatalf("error building kubeconfig: %v", err)
+		}
+
+		kubeClient, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			klog.Fatalf("error building kubernetes clientset: %v", err)
+		}
+
+		if err := purge(context.Background(), kubeClient, cmd.OutOrStdout(), purgeDryRun); err != nil {
+			klog.Fatal(err)
+		}
+	},
+}
+
+// purge deletes every object managed by the controller, in every namespace
+// or only in --watch-namespace, and removes the cleanup finalizer from the
+// role bindings carrying it, since no controller is left to release them.
+// With dryRun nothing is persisted. A summary of each namespace is printed
+// to out.
+func purge(ctx context.Context, kubeClient kubernetes.Interface, out io.Writer, dryRun bool) error {
+	deleteOptions := metav1.DeleteOptions{}
+	updateOptions := metav1.UpdateOptions{}
+	if dryRun {
+		deleteOptions.DryRun = []string{metav1.DryRunAll}
+		updateOptions.DryRun = []string{metav1.DryRunAll}
+	}
+	listOptions := metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(managedByLabels).String(),
+	}
+
+	// Number of deleted resources, by namespace and then by kind
+	deleted := map[string]map[string]int{}
+	record := func(namespace, kind string) {
+		if deleted[namespace] == nil {
+			deleted[namespace] = map[string]int{}
+		}
+		deleted[namespace][kind]++
+	}
+
+	// ignoreNotFound tolerates objects removed in the meantime, such as
+	// token secrets garbage collected with their service account.
+	ignoreNotFound := func(err error) error {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	// Release the namespace admins role bindings first, whose deletion, or
+	// that of their namespace, would otherwise stay blocked
+	allRoleBindings, err := kubeClient.RbacV1().RoleBindings(watchNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing role bindings: %v", err)
+	}
+	for _, roleBinding := range allRoleBindings.Items {
+		if !hasFinalizer(roleBinding.Finalizers, adminsFinalizer) {
+			continue
+		}
+		klog.Infof("removing finalizer from role binding %s/%s", roleBinding.Namespace, roleBinding.Name)
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			live, err := kubeClient.RbacV1().RoleBindings(roleBinding.Namespace).Get(ctx, roleBinding.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			live.Finalizers = removeFinalizer(live.Finalizers, adminsFinalizer)
+			_, err = kubeClient.RbacV1().RoleBindings(live.Namespace).Update(ctx, live, updateOptions)
+			return err
+		})
+		if err := ignoreNotFound(err); err != nil {
+			return fmt.Errorf("error removing finalizer from role binding %s/%s: %v", roleBinding.Namespace, roleBinding.Name, err)
+		}
+		record(roleBinding.Namespace, "finalizers")
+	}
+
+	roleBindings, err := kubeClient.RbacV1().RoleBindings(watchNamespace).List(ctx, listOptions)
+	if err != nil {
+		return fmt.Errorf("error listing role bindings: %v", err)
+	}
+	for _, roleBinding := range roleBindings.Items {
+		klog.Infof("deleting role binding %s/%s", roleBinding.Namespace, roleBinding.Name)
+		if err := ignoreNotFound(kubeClient.RbacV1().RoleBindings(roleBinding.Namespace).Delete(ctx, roleBinding.Name, deleteOptions)); err != nil {
+			return fmt.Errorf("error deleting role binding %s/%s: %v", roleBinding.Namespace, roleBinding.Name, err)
+		}
+		record(roleBinding.Namespace, "role bindings")
+	}
+
+	serviceAccounts, err := kubeClient.CoreV1().ServiceAccounts(watchNamespace).List(ctx, listOptions)
+	if err != nil {
+		return fmt.Errorf("error listing service accounts: %v", err)
+	}
+	for _, serviceAccount := range serviceAccounts.Items {
+		klog.Infof("deleting service account %s/%s", serviceAccount.Namespace, serviceAccount.Name)
+		if err := ignoreNotFound(kubeClient.CoreV1().ServiceAccounts(serviceAccount.Namespace).Delete(ctx, serviceAccount.Name, deleteOptions)); err != nil {
+			return fmt.Errorf("error deleting service account %s/%s: %v", serviceAccount.Namespace, serviceAccount.Name, err)
+		}
+		record(serviceAccount.Namespace, "service accounts")
+	}
+
+	secrets, err := kubeClient.CoreV1().Secrets(watchNamespace).List(ctx, listOptions)
+	if err != nil {
+		return fmt.Errorf("error listing secrets: %v", err)
+	}
+	for _, secret := range secrets.Items {
+		klog.Infof("deleting secret %s/%s", secret.Namespace, secret.Name)
+		if err := ignoreNotFound(kubeClient.CoreV1().Secrets(secret.Namespace).Delete(ctx, secret.Name, deleteOptions)); err != nil {
+			return fmt.Errorf("error deleting secret %s/%s: %v", secret.Namespace, secret.Name, err)
+		}
+		record(secret.Namespace, "secrets")
+	}
+
+	// Print a summary
+	namespaces := make([]string, 0, len(deleted))
+	for namespace := range deleted {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	verb, released := "deleted", "removed the finalizer from"
+	if dryRun {
+		verb, released = "would delete", "would remove the finalizer from"
+	}
+
+	for _, namespace := range namespaces {
+		counts := deleted[namespace]
+		fmt.Fprintf(out, "%s: %s %d service accounts, %d role bindings, %d secrets, %s %d role bindings\n", namespace, verb, counts["service accounts"], counts["role bindings"], counts["secrets"], released, counts["finalizers"])
+	}
+	if len(namespaces) == 0 {
+		fmt.Fprintln(out, "no resources managed by argo-controller found")
+	}
+
+	return nil
+}
+
+func init() {
+	workflowsPurgeCmd.Flags().BoolVar(&purgeConfirm, "confirm", false, "Confirm the deletion of every resource created by the controller.")
+	workflowsPurgeCmd.Flags().BoolVar(&purgeDryRun, "dry-run", false, "List the resources which would be deleted, without deleting them.")
+
+	workflowsCmd.AddCommand(workflowsPurgeCmd)
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func purgeObjects() []runtime.Object {
+	admins := newAdminsRoleBinding("team-a", groupSubject("team-a-admins"))
+	admins.Finalizers = []string{"example.com/keep", adminsFinalizer}
+
+	return []runtime.Object{
+		admins,
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "argo-workflows", Namespace: "team-a", Labels: managedByLabels}},
+		&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "argo-workflows", Namespace: "team-a", Labels: managedByLabels}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "argo-workflows-team-a-admins", Namespace: "team-a", Labels: managedByLabels}},
+		&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "team-a"}},
+	}
+}
+
+func TestPurge(t *testing.T) {
+	defer func(namespace string) { watchNamespace = namespace }(watchNamespace)
+	watchNamespace = ""
+
+	kubeClient := fake.NewSimpleClientset(purgeObjects()...)
+	var out bytes.Buffer
+	if err := purge(context.Background(), kubeClient, &out, false); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+
+	want := "team-a: deleted 1 service accounts, 1 role bindings, 1 secrets, removed the finalizer from 1 role bindings\n"
+	if out.String() != want {
+		t.Errorf("got summary %q, want %q", out.String(), want)
+	}
+
+	admins, err := kubeClient.RbacV1().RoleBindings("team-a").Get(context.Background(), "namespace-admins", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting the namespace admins role binding: %v", err)
+	}
+	if !equalStrings(admins.Finalizers, []string{"example.com/keep"}) {
+		t.Errorf("got finalizers %v, want only the foreign one kept", admins.Finalizers)
+	}
+
+	roleBindings, err := kubeClient.RbacV1().RoleBindings("team-a").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("listing role bindings: %v", err)
+	}
+	names := []string{}
+	for _, roleBinding := range roleBindings.Items {
+		names = append(names, roleBinding.Name)
+	}
+	if !equalStrings(names, []string{"namespace-admins", "unrelated"}) {
+		t.Errorf("got role bindings %v, want the unmanaged ones kept", names)
+	}
+}
+
+func TestPurgeDryRun(t *testing.T) {
+	defer func(namespace string) { watchNamespace = namespace }(watchNamespace)
+	watchNamespace = ""
+
+	kubeClient := fake.NewSimpleClientset(purgeObjects()...)
+	var out bytes.Buffer
+	if err := purge(context.Background(), kubeClient, &out, true); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+
+	want := "team-a: would delete 1 service accounts, 1 role bindings, 1 secrets, would remove the finalizer from 1 role bindings\n"
+	if out.String() != want {
+		t.Errorf("got summary %q, want %q", out.String(), want)
+	}
+}
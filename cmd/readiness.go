@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+var waitFirstReconcile bool
+
+// readiness is the state reported on /readyz. The checks are those of the
+// current term, registered by the controllers as they are created.
+var readiness = struct {
+	mu      sync.Mutex
+	leading bool
+	checks  map[string]func() bool
+}{checks: map[string]func() bool{}}
+
+// startTerm records that this instance has started reconciling, without any
+// readiness checks yet.
+func startTerm() {
+	readiness.mu.Lock()
+	defer readiness.mu.Unlock()
+
+	readiness.leading = true
+	readiness.checks = map[string]func() bool{}
+}
+
+// endTerm records that this instance has stopped reconciling.
+func endTerm() {
+	readiness.mu.Lock()
+	defer readiness.mu.Unlock()
+
+	readiness.leading = false
+	readiness.checks = map[string]func() bool{}
+}
+
+// setReadyCheck registers a check which must pass for the current term to be
+// ready, replacing any check of the same name.
+func setReadyCheck(name string, check func() bool) {
+	readiness.mu.Lock()
+	defer readiness.mu.Unlock()
+
+	readiness.checks[name] = check
+}
+
+// syncedCheck returns a readiness check passing once every informer has
+// synced.
+func syncedCheck(synced []cache.InformerSynced) func() bool {
+	return func() bool {
+		for _, hasSynced := range synced {
+			if !hasSynced() {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// readyzHandler serves the readiness of the controller. A replica waiting for
+// leadership is ready, as it has nothing to do; the leader is ready once every
+// check of its term passes.
+func readyzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		readiness.mu.Lock()
+		leading := readiness.leading
+		failing := []string{}
+		for name, check := range readiness.checks {
+			if !check() {
+				failing = append(failing, name)
+			}
+		}
+		started := len(readiness.checks) > 0
+		readiness.mu.Unlock()
+		sort.Strings(failing)
+
+		switch {
+		case !leading && leaderElect:
+			fmt.Fprintln(w, "ok: waiting for leadership")
+		case !leading || !started:
+			http.Error(w, "not ready: starting", http.StatusServiceUnavailable)
+		case len(failing) > 0:
+			http.Error(w, "not ready: "+strings.Join(failing, ", "), http.StatusServiceUnavailable)
+		default:
+			fmt.Fprintln(w, "ok")
+		}
+	})
+}
@@ -1,21 +1,110 @@
 package cmd
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/spf13/cobra"
 )
 
 var apiserver string
 var kubeconfig string
+var kubeContext string
+var watchNamespace string
+var otelEndpoint string
+var metricsAddr string
+var impersonateUser string
+var impersonateGroups []string
+var impersonateUID string
+var kubeAPIQPS float32
+var kubeAPIBurst int
+var strictPreflight bool
+var serverDryRun bool
+var enableDebugEndpoints bool
+var debugToken string
+var enablePprof bool
+var debugAddr string
+var startupTimeout time.Duration
+var cacheSyncTimeout time.Duration
 
 var rootCmd = &cobra.Command{
 	Use:   "argo-controller",
 	Short: "A series of controllers for configuring namespaces to accomodate Argo",
 	Long:  `A series of controllers for configuring namespaces to accomodate Argo`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if impersonateUser == "" && (len(impersonateGroups) > 0 || impersonateUID != "") {
+			return fmt.Errorf("--as-group and --as-uid require --as")
+		}
+		if kubeAPIQPS <= 0 {
+			return fmt.Errorf("--kube-api-qps: must be positive, got %v", kubeAPIQPS)
+		}
+		if kubeAPIBurst < 1 {
+			return fmt.Errorf("--kube-api-burst: must be at least 1, got %d", kubeAPIBurst)
+		}
+		if startupTimeout < 0 {
+			return fmt.Errorf("--startup-timeout: must not be negative, got %s", startupTimeout)
+		}
+		if cacheSyncTimeout < 0 {
+			return fmt.Errorf("--cache-sync-timeout: must not be negative, got %s", cacheSyncTimeout)
+		}
+		if enableDebugEndpoints && metricsAddr == "" {
+			return fmt.Errorf("--enable-debug-endpoints: requires --metrics-addr")
+		}
+		if debugToken != "" && !enableDebugEndpoints {
+			return fmt.Errorf("--debug-token: requires --enable-debug-endpoints")
+		}
+		if enablePprof && debugAddr == "" {
+			return fmt.Errorf("--enable-pprof: requires --debug-addr")
+		}
+		if enablePprof && debugAddr == metricsAddr {
+			return fmt.Errorf("--debug-addr: must differ from --metrics-addr, got %q", debugAddr)
+		}
+		if err := validateFreezeFlags(); err != nil {
+			return err
+		}
+		if leaderElect && leaderElectNamespace == "" {
+			return fmt.Errorf("--leader-elect: requires --leader-elect-namespace")
+		}
+		if leaderElectNamespace != "" {
+			if err := validateNamespace("leader-elect-namespace", leaderElectNamespace); err != nil {
+				return err
+			}
+		}
+		if watchNamespace != "" {
+			if err := validateNamespace("watch-namespace", watchNamespace); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
 }
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&apiserver, "apiserver", "", "URL to the Kubernetes API server")
 	rootCmd.PersistentFlags().StringVar(&kubeconfig, "kubeconfig", "", "Path to the Kubeconfig file")
+	rootCmd.PersistentFlags().StringVar(&kubeContext, "context", "", "The kubeconfig context to use, instead of its current context. The kubeconfig is read from --kubeconfig, else KUBECONFIG or ~/.kube/config.")
+	rootCmd.PersistentFlags().Float32Var(&kubeAPIQPS, "kube-api-qps", 5, "Maximum queries per second to the Kubernetes API server")
+	rootCmd.PersistentFlags().IntVar(&kubeAPIBurst, "kube-api-burst", 10, "Maximum burst of queries to the Kubernetes API server")
+	rootCmd.PersistentFlags().StringVar(&impersonateUser, "as", "", "Username to impersonate for all API requests")
+	rootCmd.PersistentFlags().StringArrayVar(&impersonateGroups, "as-group", []string{}, "Group to impersonate for all API requests. May be repeated.")
+	rootCmd.PersistentFlags().StringVar(&impersonateUID, "as-uid", "", "UID to impersonate for all API requests")
+	rootCmd.PersistentFlags().StringVar(&metricsAddr, "metrics-addr", ":8080", "Address to serve Prometheus metrics on. Set to an empty string to disable.")
+	rootCmd.PersistentFlags().BoolVar(&enableDebugEndpoints, "enable-debug-endpoints", false, "Serve the state of the workqueues and informer caches as JSON on /debug/controller, on the metrics address.")
+	rootCmd.PersistentFlags().StringVar(&debugToken, "debug-token", "", "Bearer token of POST /reconcile?namespace=NAME, which reconciles a namespace on demand. The endpoint is only served when set, with --enable-debug-endpoints. Use env:VARIABLE to read it from an environment variable.")
+	rootCmd.PersistentFlags().BoolVar(&enablePprof, "enable-pprof", false, "Serve the Go runtime profiles on /debug/pprof/, on the debug address.")
+	rootCmd.PersistentFlags().StringVar(&debugAddr, "debug-addr", "localhost:6060", "Address to serve the Go runtime profiles on when --enable-pprof is set. Must differ from --metrics-addr.")
+	rootCmd.PersistentFlags().StringVar(&otelEndpoint, "otel-endpoint", "", "OTLP endpoint to export traces to (e.g. http://otel-collector:4318), using the protocol set by OTEL_EXPORTER_OTLP_PROTOCOL. Defaults to OTEL_EXPORTER_OTLP_ENDPOINT; tracing is disabled when neither is set.")
+	rootCmd.PersistentFlags().DurationVar(&startupTimeout, "startup-timeout", time.Minute*2, "How long to keep retrying to reach the API server, and to wait for the informer caches to sync, at startup before exiting. Set to 0 to exit on the first failure.")
+	rootCmd.PersistentFlags().DurationVar(&cacheSyncTimeout, "cache-sync-timeout", 0, "How long to wait for the informer caches to sync at startup before exiting, naming the informers which have not synced. Defaults to --startup-timeout.")
+	rootCmd.PersistentFlags().BoolVar(&strictPreflight, "strict-preflight", false, "Exit at startup if the preflight checks find the controller is missing any required permission. Otherwise missing permissions are only logged.")
+	rootCmd.PersistentFlags().BoolVar(&serverDryRun, "server-dry-run", false, "Send every create, update and delete to the API server as a dry run, so validation and admission webhooks run without persisting anything. The outcome of each request is logged.")
+	rootCmd.PersistentFlags().StringVar(&auditLog, "audit-log", "", "Write a versioned JSON record of every create, update and delete to this destination: stdout, or the path of a file to append to. Secret values are recorded as hashes.")
+	rootCmd.PersistentFlags().BoolVar(&freeze, "freeze", false, "Start frozen: every create, update, patch and delete is sent as a server dry run and logged, but nothing is persisted. Can be changed at runtime with --freeze-configmap.")
+	rootCmd.PersistentFlags().StringVar(&freezeConfigMap, "freeze-configmap", "", "ConfigMap (namespace/name) whose \"frozen\" key, set to true or false, freezes or unfreezes the controller at runtime. Overrides --freeze while the key is set.")
+	rootCmd.PersistentFlags().BoolVar(&leaderElect, "leader-elect", false, "Elect a leader among the replicas with a Lease, so that only one reconciles at a time. Requires --leader-elect-namespace.")
+	rootCmd.PersistentFlags().StringVar(&leaderElectNamespace, "leader-elect-namespace", "", "Namespace of the leader election Lease.")
+	rootCmd.PersistentFlags().StringVar(&watchNamespace, "watch-namespace", "", "Restrict the controller to a single namespace. When unset, all namespaces are watched.")
 }
 
 // Execute executes the root command.
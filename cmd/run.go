@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gccloudone-aurora/argo-controller/pkg/debug"
+	"github.com/gccloudone-aurora/argo-controller/pkg/metrics"
+	"github.com/gccloudone-aurora/argo-controller/pkg/signals"
+	"github.com/gccloudone-aurora/argo-controller/pkg/tracing"
+	"github.com/spf13/cobra"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Run the workflows and image pull secrets controllers in a single process",
+	Long: `Run the workflows and image pull secrets controllers in a single process,
+sharing one Kubernetes client, informer cache and metrics endpoint. Both
+controllers accept the same flags as their standalone commands.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if runOnce {
+			return fmt.Errorf("--run-once: not supported by run, use workflows --run-once instead")
+		}
+		if err := validateWorkflowsFlags(); err != nil {
+			return err
+		}
+
+		return validateImagePullSecretsFlags()
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Setup signals so we can shutdown cleanly
+		stopCh, _ := signals.SetupSignalHandler()
+		reloadCh := signals.SetupReloadHandler()
+
+		kubeClient, forbidden := setupController(stopCh, append(workflowsPermissions(), imagePullSecretsPermissions()...))
+
+		runAsLeader(kubeClient, "argo-controller-run", stopCh, func(stopCh <-chan struct{}) {
+			kubeInformerFactory := newInformerFactory(kubeClient)
+
+			// Setup controllers
+			workflowsController, workflowsSynced := newWorkflowsController(stopCh, reloadCh, kubeClient, kubeInformerFactory, forbidden)
+			imagePullSecretsController, imagePullSecretsSynced := newImagePullSecretsController(stopCh, kubeClient, kubeInformerFactory, forbidden)
+			setReadyCheck("informers", syncedCheck(append(workflowsSynced, imagePullSecretsSynced...)))
+			if waitFirstReconcile {
+				setReadyCheck("first-reconcile", workflowsController.FirstReconcileComplete)
+			}
+
+			// Start informers
+			kubeInformerFactory.Start(stopCh)
+
+			// Wait for caches
+			klog.Info("Waiting for informer caches to sync")
+			if ok := waitForCacheSync(stopCh, append(workflowsSynced, imagePullSecretsSynced...)...); !ok {
+				if stopped(stopCh) {
+					return
+				}
+				klog.Fatalf("failed to wait for caches to sync within %s, see --cache-sync-timeout", cacheSyncTimeoutOrDefault())
+			}
+
+			// Periodically reconcile every namespace, regardless of informer events
+			if fullResyncInterval > 0 {
+				go workflowsController.RunFullResync(fullResyncInterval, stopCh)
+			}
+
+			// Run the controllers
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := imagePullSecretsController.Run(imagePullSecretWorkers, stopCh); err != nil && !stopped(stopCh) {
+					klog.Fatalf("error running image pull secrets controller: %v", err)
+				}
+			}()
+			if err := workflowsController.Run(workflowWorkers, stopCh); err != nil && !stopped(stopCh) {
+				klog.Fatalf("error running workflows controller: %v", err)
+			}
+			wg.Wait()
+		})
+	},
+}
+
+// setupController prepares everything a controller needs before it is
+// created: tracing, the metrics and debug endpoints and the Kubernetes
+// client, whose permissions are checked by the preflight. It returns the
+// client and a forbiddenReporter.
+func setupController(stopCh <-chan struct{}, permissions []permission) (kubernetes.Interface, *forbiddenReporter) {
+	// Setup tracing
+	tracing.Setup(otelEndpoint, stopCh)
+
+	// Serve metrics, and the debug endpoints if enabled
+	if metricsAddr != "" {
+		mux := http.NewServeMux()
+		if enableDebugEndpoints {
+			klog.Warning("debug endpoints enabled on /debug/controller")
+			mux.Handle("/debug/controller", debug.Handler())
+			if token := resolveSecretValue(debugToken); token != "" {
+				klog.Warning("on-demand reconcile enabled on /reconcile")
+				mux.Handle("/reconcile", debug.ReconcileHandler(token))
+			}
+		}
+		mux.Handle("/readyz", readyzHandler())
+		metrics.Serve(metricsAddr, mux, stopCh)
+	}
+
+	// Serve the runtime profiles on their own listener if enabled
+	if enablePprof {
+		klog.Warningf("pprof enabled on %s/debug/pprof/", debugAddr)
+		debug.ServePprof(debugAddr, stopCh)
+	}
+
+	// Open the audit log before any write is made
+	if err := openAuditLog(); err != nil {
+		klog.Fatalf("%v", err)
+	}
+
+	// Create the Kubernetes client, waiting for the API server if needed
+	cfg, kubeClient, err := newKubeClient()
+	if err != nil {
+		klog.Fatalf("%v", err)
+	}
+	verifyImpersonation(cfg)
+
+	// Check the controller's permissions before doing any work
+	if leaderElect {
+		permissions = append(permissions, leaderElectionPermissions()...)
+	}
+	if freezeConfigMap != "" {
+		permissions = append(permissions, freezePermissions()...)
+	}
+	runPreflight(kubeClient, permissions)
+
+	// Apply the freeze state before any write is made
+	if ok := waitForCacheSync(stopCh, watchFreezeConfigMap(kubeClient, stopCh)); !ok {
+		klog.Fatalf("failed to sync --freeze-configmap %s within %s, see --cache-sync-timeout", freezeConfigMap, cacheSyncTimeoutOrDefault())
+	}
+
+	forbidden := newForbiddenReporter(newEventRecorder(kubeClient))
+
+	return kubeClient, forbidden
+}
+
+// newInformerFactory returns an informer factory scoped to --watch-namespace.
+// Every leadership term gets a new factory, so that its caches are synced
+// afresh.
+func newInformerFactory(kubeClient kubernetes.Interface) kubeinformers.SharedInformerFactory {
+	return kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, time.Minute*5, kubeinformers.WithNamespace(watchNamespace))
+}
+
+func init() {
+	addWorkflowsFlags(runCmd.Flags())
+	addImagePullSecretsFlags(runCmd.Flags())
+
+	runCmd.MarkFlagRequired("argo-workflows-cluster-role-name")
+
+	rootCmd.AddCommand(runCmd)
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+)
+
+var cacheManagedSecretsOnly bool
+
+// newManagedSecretsInformerFactory returns an informer factory which only
+// watches the secrets carrying the managed-by label, so that the data of the
+// other secrets of the cluster is not held in memory.
+func newManagedSecretsInformerFactory(kubeClient kubernetes.Interface) kubeinformers.SharedInformerFactory {
+	return kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, time.Minute*5,
+		kubeinformers.WithNamespace(watchNamespace),
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = labels.SelectorFromSet(managedByLabels).String()
+		}),
+	)
+}
+
+// newStorageSecretInformerFactory returns an informer factory which only
+// watches the secrets named by ARGO_SECRET_NAME, which may have been created
+// before the controller and so lack the managed-by label.
+func newStorageSecretInformerFactory(kubeClient kubernetes.Interface) kubeinformers.SharedInformerFactory {
+	return kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, time.Minute*5,
+		kubeinformers.WithNamespace(watchNamespace),
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", os.Getenv("ARGO_SECRET_NAME")).String()
+		}),
+	)
+}
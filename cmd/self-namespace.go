@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// serviceAccountNamespaceFile holds the namespace of the pod, mounted along
+// with the service account token.
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+var skipSelfNamespace bool
+
+// selfNamespace is the namespace the controller runs in, set by
+// validateSelfNamespace. It is empty when it cannot be determined, such as
+// out of cluster.
+var selfNamespace string
+
+// validateSelfNamespace looks up the namespace of the controller when
+// --skip-self-namespace is set: the POD_NAMESPACE environment variable, set
+// through the downward API, else the namespace of the mounted service
+// account.
+func validateSelfNamespace() error {
+	selfNamespace = ""
+	if !skipSelfNamespace {
+		return nil
+	}
+
+	selfNamespace = os.Getenv("POD_NAMESPACE")
+	if selfNamespace == "" {
+		if content, err := ioutil.ReadFile(serviceAccountNamespaceFile); err == nil {
+			selfNamespace = strings.TrimSpace(string(content))
+		}
+	}
+	if selfNamespace != "" && selfNamespace == watchNamespace {
+		return fmt.Errorf("--skip-self-namespace: --watch-namespace %q is the namespace of the controller; set --skip-self-namespace=false to reconcile it", watchNamespace)
+	}
+
+	return nil
+}
+
+// isSelfNamespace reports whether namespace is the one the controller runs
+// in and is skipped.
+func isSelfNamespace(namespace string) bool {
+	return selfNamespace != "" && namespace == selfNamespace
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/gccloudone-aurora/argo-controller/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	rbacv1listers "k8s.io/client-go/listers/rbac/v1"
+	"k8s.io/klog"
+)
+
+var migrateOnNameChange bool
+var pruneOnAdminRBRemoval bool
+
+// staleObject is a managed object which is no longer generated.
+type staleObject struct {
+	kind     string
+	resource string
+	object   metav1.Object
+}
+
+// staleListers are the listers of the kinds checked for stale objects.
+type staleListers struct {
+	serviceAccounts corev1listers.ServiceAccountLister
+	roleBindings    rbacv1listers.RoleBindingLister
+	secrets         corev1listers.SecretLister
+}
+
+// findStaleObjects returns the service accounts, role bindings and token
+// secrets of the namespace managed by the controller which are not among the
+// generated ones, such as those named after an earlier name template. The
+// storage secrets are pruned on their own, and the image pull secret copies
+// are not token secrets, so neither is reported.
+func findStaleObjects(namespace *corev1.Namespace, listers staleListers, serviceAccounts []*corev1.ServiceAccount, roleBindings []*rbacv1.RoleBinding, secrets []*corev1.Secret) ([]staleObject, error) {
+	selector := labels.SelectorFromSet(managedByLabels)
+	stale := []staleObject{}
+
+	generated := map[string]bool{}
+	for _, serviceAccount := range serviceAccounts {
+		generated[serviceAccount.Name] = true
+	}
+	currentServiceAccounts, err := listers.serviceAccounts.ServiceAccounts(namespace.Name).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	for _, serviceAccount := range currentServiceAccounts {
+		if !generated[serviceAccount.Name] {
+			stale = append(stale, staleObject{kind: "ServiceAccount", resource: "serviceaccounts", object: serviceAccount})
+		}
+	}
+
+	generated = map[string]bool{}
+	for _, roleBinding := range roleBindings {
+		generated[roleBinding.Name] = true
+	}
+	currentRoleBindings, err := listers.roleBindings.RoleBindings(namespace.Name).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	for _, roleBinding := range currentRoleBindings {
+		if !generated[roleBinding.Name] {
+			stale = append(stale, staleObject{kind: "RoleBinding", resource: "rolebindings", object: roleBinding})
+		}
+	}
+
+	generated = map[string]bool{}
+	for _, secret := range secrets {
+		generated[secret.Name] = true
+	}
+	currentSecrets, err := listers.secrets.Secrets(namespace.Name).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range currentSecrets {
+		if secret.Type == corev1.SecretTypeServiceAccountToken && !generated[secret.Name] {
+			stale = append(stale, staleObject{kind: "Secret", resource: "secrets", object: secret})
+		}
+	}
+
+	return stale, nil
+}
+
+// reconcileStaleObjects reports the stale objects of the namespace in logs and
+// metrics and, with --migrate-on-name-change, deletes them. It is called once
+// the generated objects are applied, so that their replacements exist first.
+// Without a namespace admins role binding, found is false and nothing is
+// generated, so every managed object is stale; they are then deleted with
+// --prune-on-admin-rb-removal instead.
+func reconcileStaleObjects(ctx context.Context, kubeClient kubernetes.Interface, forbidden *forbiddenReporter, namespace *corev1.Namespace, stale []staleObject, found bool) error {
+	counts := map[string]int{"ServiceAccount": 0, "RoleBinding": 0, "Secret": 0}
+	for _, obj := range stale {
+		counts[obj.kind]++
+	}
+	for kind, count := range counts {
+		metrics.StaleObjects.Set(float64(count), namespace.Name, kind)
+	}
+
+	prune := migrateOnNameChange
+	if !found {
+		prune = pruneOnAdminRBRemoval
+	}
+	if prune && !found && len(stale) > 0 {
+		klog.Warningf("revoking Argo access in namespace %s, which no longer has a namespace admins role binding; running workflows keep the tokens already mounted in their pods, but new steps cannot start", namespace.Name)
+	}
+
+	for _, obj := range stale {
+		switch {
+		case !prune && !found:
+			klog.Warningf("%s %s/%s is managed by argo-controller but the namespace has no namespace admins role binding; set --prune-on-admin-rb-removal to delete it", obj.kind, obj.object.GetNamespace(), obj.object.GetName())
+			continue
+		case !prune:
+			klog.Warningf("%s %s/%s is managed by argo-controller but no longer generated, such as after a name template change; set --migrate-on-name-change to delete it", obj.kind, obj.object.GetNamespace(), obj.object.GetName())
+			continue
+		}
+
+		klog.Infof("deleting %s %s/%s, which is no longer generated", obj.kind, obj.object.GetNamespace(), obj.object.GetName())
+		options := metav1.DeleteOptions{
+			Preconditions: metav1.NewUIDPreconditions(string(obj.object.GetUID())),
+			DryRun:        dryRun(),
+		}
+		apiSpan := startAPISpan(ctx, "Delete", obj.kind, obj.object)
+		var err error
+		switch obj.kind {
+		case "ServiceAccount":
+			err = kubeClient.CoreV1().ServiceAccounts(obj.object.GetNamespace()).Delete(ctx, obj.object.GetName(), options)
+		case "RoleBinding":
+			err = kubeClient.RbacV1().RoleBindings(obj.object.GetNamespace()).Delete(ctx, obj.object.GetName(), options)
+		case "Secret":
+			err = kubeClient.CoreV1().Secrets(obj.object.GetNamespace()).Delete(ctx, obj.object.GetName(), options)
+		}
+		apiSpan.End(err)
+		if err != nil && !errors.IsNotFound(err) {
+			return forbidden.check(namespace, "delete", obj.resource, obj.object.GetNamespace(), err)
+		}
+		if !writesDisabled() {
+			metrics.StaleObjects.Add(-1, namespace.Name, obj.kind)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gccloudone-aurora/argo-controller/pkg/debug"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// startupBackoff is the delay between attempts to reach the API server at
+// startup.
+var startupBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    10,
+	Cap:      time.Second * 30,
+}
+
+// newKubeClient builds the Kubernetes client and checks the API server
+// answers, retrying for up to --startup-timeout so that a controller started
+// slightly before the API server does not crash. Errors in the configuration,
+// such as an unreadable kubeconfig, will not fix themselves and are returned
+// at once.
+func newKubeClient() (*rest.Config, kubernetes.Interface, error) {
+	cfg, err := buildConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error building kubeconfig: %v", err)
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error building kubernetes clientset: %v", err)
+	}
+
+	err = retryStartup("reach the Kubernetes API server", func() error {
+		_, err := kubeClient.Discovery().ServerVersion()
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, kubeClient, nil
+}
+
+// retryStartup calls fn until it succeeds, backing off exponentially between
+// attempts, for up to --startup-timeout. Every failed attempt is logged.
+func retryStartup(action string, fn func() error) error {
+	deadline := time.Now().Add(startupTimeout)
+	backoff := startupBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		delay := backoff.Step()
+		if time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("unable to %s after %d attempts: %v", action, attempt, err)
+		}
+
+		klog.Warningf("unable to %s (attempt %d), retrying in %s: %v", action, attempt, delay.Round(time.Millisecond), err)
+		time.Sleep(delay)
+	}
+}
+
+// cacheSyncTimeoutOrDefault returns how long to wait for the informer caches
+// to sync: --cache-sync-timeout, or --startup-timeout when it is unset.
+func cacheSyncTimeoutOrDefault() time.Duration {
+	if cacheSyncTimeout > 0 {
+		return cacheSyncTimeout
+	}
+
+	return startupTimeout
+}
+
+// waitForCacheSync waits for the informer caches to sync, for up to
+// cacheSyncTimeoutOrDefault if it is set. The informers retry their own list
+// and watch requests in the meantime. On timeout, the informers which have not
+// synced are logged, as the usual cause is a missing list or watch permission.
+func waitForCacheSync(stopCh <-chan struct{}, synced ...cache.InformerSynced) bool {
+	timeout := cacheSyncTimeoutOrDefault()
+	if timeout <= 0 {
+		return cache.WaitForCacheSync(stopCh, synced...)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ok := cache.WaitForCacheSync(ctx.Done(), synced...)
+	if !ok && !stopped(stopCh) {
+		if unsynced := debug.UnsyncedInformers(); len(unsynced) > 0 {
+			klog.Errorf("informers not synced within %s: %s; check the controller is permitted to list and watch them", timeout, strings.Join(unsynced, ", "))
+		}
+	}
+
+	return ok
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestNewKubeClientConfigErrorNotRetried(t *testing.T) {
+	defer func(timeout time.Duration) { startupTimeout = timeout }(startupTimeout)
+	defer func() { kubeconfig, kubeContext, apiserver = "", "", "" }()
+	startupTimeout = time.Minute
+	kubeconfig, kubeContext, apiserver = filepath.Join(t.TempDir(), "missing"), "", ""
+
+	begin := time.Now()
+	if _, _, err := newKubeClient(); err == nil {
+		t.Fatal("got no error, want the kubeconfig error")
+	}
+	if elapsed := time.Since(begin); elapsed > 500*time.Millisecond {
+		t.Errorf("took %s to fail, want no retry", elapsed)
+	}
+}
+
+func TestRetryStartup(t *testing.T) {
+	defer func(timeout time.Duration, backoff wait.Backoff) {
+		startupTimeout, startupBackoff = timeout, backoff
+	}(startupTimeout, startupBackoff)
+	startupBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 10}
+
+	tests := []struct {
+		name      string
+		timeout   time.Duration
+		failures  int
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "unreachable then reachable", timeout: time.Minute, failures: 2, wantCalls: 3},
+		{name: "no retry without a timeout", timeout: 0, failures: 2, wantCalls: 1, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			startupTimeout = test.timeout
+
+			calls := 0
+			err := retryStartup("reach the Kubernetes API server", func() error {
+				calls++
+				if calls <= test.failures {
+					return errors.New("connection refused")
+				}
+				return nil
+			})
+
+			if (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %t", err, test.wantErr)
+			}
+			if calls != test.wantCalls {
+				t.Errorf("got %d calls, want %d", calls, test.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gccloudone-aurora/argo-controller/pkg/controllers/namespaces"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxStatusReasonLength bounds the failure reason recorded on a namespace.
+const maxStatusReasonLength = 256
+
+// recordReconcileStatus annotates the namespace with the time and outcome of
+// the reconcile that just finished. Failing to do so is logged but does not
+// fail the reconcile.
+func recordReconcileStatus(ctx context.Context, kubeClient kubernetes.Interface, namespace *corev1.Namespace, reconcileErr error) {
+	status := "Succeeded"
+	if reconcileErr != nil {
+		reason := reconcileErr.Error()
+		if len(reason) > maxStatusReasonLength {
+			reason = reason[:maxStatusReasonLength] + "..."
+		}
+		status = fmt.Sprintf("Failed: %s", reason)
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				namespaces.LastReconciledAnnotation:  time.Now().UTC().Format(time.RFC3339),
+				namespaces.ReconcileStatusAnnotation: status,
+			},
+		},
+	})
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	if _, err := kubeClient.CoreV1().Namespaces().Patch(ctx, namespace.Name, types.MergePatchType, patch, patchOptions()); err != nil {
+		utilruntime.HandleError(fmt.Errorf("error recording reconcile status of namespace %s: %v", namespace.Name, err))
+	}
+}
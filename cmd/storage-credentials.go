@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+)
+
+var secretProviderNamespace string
+var secretProviderSelector string
+
+// storageCredentialsSelector is the parsed --secret-provider-selector, set by
+// validateWorkflowsFlags.
+var storageCredentialsSelector labels.Selector
+
+// newStorageCredentialsInformerFactory returns an informer factory which only
+// watches the secrets of --secret-provider-namespace matching
+// --secret-provider-selector, so that the other secrets of the source
+// namespace are not cached.
+func newStorageCredentialsInformerFactory(kubeClient kubernetes.Interface) kubeinformers.SharedInformerFactory {
+	return kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 0,
+		kubeinformers.WithNamespace(secretProviderNamespace),
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = storageCredentialsSelector.String()
+		}),
+	)
+}
@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gccloudone-aurora/argo-controller/pkg/secretprovider"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog"
+)
+
+// storageSecretLabel marks the storage secrets generated by the controller,
+// so that the ones no longer configured can be pruned.
+const storageSecretLabel = "argo-workflows.aurora/storage-secret"
+
+var storageSecretSpecFlags []string
+var storageSecretType string
+var noStorageSecretSelectorFlag string
+
+// noStorageSecretSelector is the parsed --no-storage-secret-selector, set by
+// validateStorageSecretSpecs. It matches nothing when the flag is empty.
+var noStorageSecretSelector labels.Selector
+
+// storageSecretTypeKeys holds the secret types accepted by
+// --storage-secret-type, with the data keys the API server requires of each.
+// Any one of the keys of kubernetes.io/basic-auth is enough.
+var storageSecretTypeKeys = map[corev1.SecretType][]string{
+	corev1.SecretTypeOpaque:           nil,
+	corev1.SecretTypeDockercfg:        {corev1.DockerConfigKey},
+	corev1.SecretTypeDockerConfigJson: {corev1.DockerConfigJsonKey},
+	corev1.SecretTypeBasicAuth:        {corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey},
+	corev1.SecretTypeSSHAuth:          {corev1.SSHAuthPrivateKey},
+	corev1.SecretTypeTLS:              {corev1.TLSCertKey, corev1.TLSPrivateKeyKey},
+}
+
+// validateStorageSecretType checks --storage-secret-type is a secret type
+// which can hold storage credentials, and that the keys of the storage secret
+// include those the type requires.
+func validateStorageSecretType() error {
+	required, ok := storageSecretTypeKeys[corev1.SecretType(storageSecretType)]
+	if !ok {
+		types := []string{}
+		for secretType := range storageSecretTypeKeys {
+			types = append(types, string(secretType))
+		}
+		sort.Strings(types)
+		return fmt.Errorf("--storage-secret-type: unknown type %q, must be one of %s", storageSecretType, strings.Join(types, ", "))
+	}
+
+	keys := map[string]bool{storageSecretUserKey: true, storageSecretPasswordKey: true}
+	for key := range storageSecretExtra {
+		keys[key] = true
+	}
+	missing := []string{}
+	for _, key := range required {
+		if !keys[key] {
+			missing = append(missing, key)
+		}
+	}
+	switch {
+	case corev1.SecretType(storageSecretType) == corev1.SecretTypeBasicAuth && len(missing) == len(required):
+		return fmt.Errorf("--storage-secret-type: %s requires the key %s or %s, set with --storage-secret-user-key, --storage-secret-password-key or --storage-secret-extra", storageSecretType, corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey)
+	case corev1.SecretType(storageSecretType) != corev1.SecretTypeBasicAuth && len(missing) > 0:
+		return fmt.Errorf("--storage-secret-type: %s requires the keys %s, set with --storage-secret-user-key, --storage-secret-password-key or --storage-secret-extra", storageSecretType, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// storageSecretSpec is a storage secret generated in every namespace.
+type storageSecretSpec struct {
+	name     string
+	provider secretprovider.Provider
+
+	// secretType is the type of the secret, Opaque unless set by
+	// --storage-secret-type
+	secretType corev1.SecretType
+
+	// extra adds the --storage-secret-extra keys
+	extra bool
+}
+
+// additionalStorageSecrets holds the parsed --storage-secret specs.
+var additionalStorageSecrets []storageSecretSpec
+
+// validateStorageSecretSpecs parses the --storage-secret specs, checking
+// their names are distinct from each other and from the default storage
+// secret.
+func validateStorageSecretSpecs() error {
+	additionalStorageSecrets = []storageSecretSpec{}
+	seen := map[string]bool{os.Getenv("ARGO_SECRET_NAME"): true}
+	for _, flag := range storageSecretSpecFlags {
+		spec, err := parseStorageSecretSpec(flag)
+		if err != nil {
+			return fmt.Errorf("--storage-secret: %v", err)
+		}
+		if seen[spec.name] {
+			return fmt.Errorf("--storage-secret: name %q is already used by another storage secret", spec.name)
+		}
+		seen[spec.name] = true
+		additionalStorageSecrets = append(additionalStorageSecrets, spec)
+	}
+
+	noStorageSecretSelector = labels.Nothing()
+	if noStorageSecretSelectorFlag != "" {
+		selector, err := labels.Parse(noStorageSecretSelectorFlag)
+		if err != nil {
+			return fmt.Errorf("--no-storage-secret-selector: %v", err)
+		}
+		noStorageSecretSelector = selector
+	}
+
+	return nil
+}
+
+// wantsStorageSecrets reports whether the storage secrets are generated in
+// namespace, which they are not when it matches --no-storage-secret-selector.
+func wantsStorageSecrets(namespace *corev1.Namespace) bool {
+	return !noStorageSecretSelector.Matches(labels.Set(namespace.Labels))
+}
+
+// parseStorageSecretSpec parses a spec of the form
+// name=NAME,provider=env|file[,dir=DIR][,user-env=VAR][,password-env=VAR].
+func parseStorageSecretSpec(flag string) (storageSecretSpec, error) {
+	fields := map[string]string{}
+	for _, field := range strings.Split(flag, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return storageSecretSpec{}, fmt.Errorf("field %q of %q must be of the form key=value", field, flag)
+		}
+		switch parts[0] {
+		case "name", "provider", "dir", "user-env", "password-env":
+			fields[parts[0]] = parts[1]
+		default:
+			return storageSecretSpec{}, fmt.Errorf("unknown field %q in %q", parts[0], flag)
+		}
+	}
+
+	if err := validateName("storage-secret", fields["name"]); err != nil {
+		return storageSecretSpec{}, err
+	}
+
+	keys := secretprovider.Keys{User: storageSecretUserKey, Password: storageSecretPasswordKey}
+	spec := storageSecretSpec{name: fields["name"], secretType: corev1.SecretTypeOpaque}
+	switch fields["provider"] {
+	case "env":
+		userEnv, passwordEnv := fields["user-env"], fields["password-env"]
+		if userEnv == "" || passwordEnv == "" {
+			return storageSecretSpec{}, fmt.Errorf("%q: the env provider requires user-env and password-env", flag)
+		}
+		spec.provider = secretprovider.NewEnvVars(keys, userEnv, passwordEnv)
+	case "file":
+		if fields["dir"] == "" {
+			return storageSecretSpec{}, fmt.Errorf("%q: the file provider requires dir", flag)
+		}
+		spec.provider = secretprovider.NewFile(keys, fields["dir"])
+	default:
+		return storageSecretSpec{}, fmt.Errorf("%q: provider must be env or file", flag)
+	}
+
+	return spec, nil
+}
+
+// pruneStorageSecrets deletes the storage secrets of the namespace generated
+// by the controller which are not among keep, such as after a --storage-secret
+// is removed.
+func pruneStorageSecrets(ctx context.Context, kubeClient kubernetes.Interface, secretsLister corev1listers.SecretLister, forbidden *forbiddenReporter, namespace *corev1.Namespace, keep map[string]bool) error {
+	secrets, err := secretsLister.Secrets(namespace.Name).List(labels.SelectorFromSet(labels.Set{storageSecretLabel: "true"}))
+	if err != nil {
+		return err
+	}
+
+	for _, secret := range secrets {
+		if keep[secret.Name] || !isManaged(secret) {
+			continue
+		}
+
+		klog.Infof("deleting storage secret %s/%s, which is no longer configured", secret.Namespace, secret.Name)
+		apiSpan := startAPISpan(ctx, "Delete", "Secret", secret)
+		err := kubeClient.CoreV1().Secrets(secret.Namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{
+			Preconditions: metav1.NewUIDPreconditions(string(secret.UID)),
+			DryRun:        dryRun(),
+		})
+		apiSpan.End(err)
+		if err != nil && !errors.IsNotFound(err) {
+			return forbidden.check(namespace, "delete", "secrets", secret.Namespace, err)
+		}
+	}
+
+	return nil
+}
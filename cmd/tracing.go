@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/gccloudone-aurora/argo-controller/pkg/tracing"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// apiCall is a write to the Kubernetes API in progress.
+type apiCall struct {
+	span      *tracing.Span
+	verb      string
+	kind      string
+	namespace string
+	name      string
+
+	// changes collects the successful writes of the reconcile, if any
+	changes *reconcileChanges
+
+	// The group affected, and the object before and after the write, for
+	// the audit log
+	group         string
+	before, after metav1.Object
+}
+
+// startAPISpan begins a span for a write to the Kubernetes API. obj is the
+// object written, or the object deleted.
+func startAPISpan(ctx context.Context, verb, kind string, obj metav1.Object) *apiCall {
+	_, span := tracing.StartClient(ctx, verb,
+		tracing.String("k8s.resource.kind", kind),
+		tracing.String("k8s.resource.name", obj.GetName()),
+		tracing.String("k8s.namespace.name", obj.GetNamespace()),
+	)
+
+	call := &apiCall{
+		span:      span,
+		verb:      verb,
+		kind:      kind,
+		namespace: obj.GetNamespace(),
+		name:      obj.GetName(),
+		changes:   changesFrom(ctx),
+		group:     auditGroupFrom(ctx),
+	}
+	if verb == "Delete" {
+		call.before = obj
+	} else {
+		call.after = obj
+	}
+
+	return call
+}
+
+// previous records the object as it was before an update, for the audit
+// log.
+func (c *apiCall) previous(obj metav1.Object) *apiCall {
+	c.before = obj
+	return c
+}
+
+// End completes the call, recording err as its outcome. Under
+// --server-dry-run, or while frozen, the outcome is also logged, as nothing
+// is persisted.
+func (c *apiCall) End(err error) {
+	c.span.End(err)
+	if err == nil {
+		c.changes.recordAction(c.verb, c.kind, c.name)
+		c.audit()
+	}
+
+	if !writesDisabled() {
+		return
+	}
+	if err != nil {
+		klog.Warningf("server dry-run: %s %s %s/%s rejected: %v", c.verb, c.kind, c.namespace, c.name, err)
+		return
+	}
+	klog.Infof("server dry-run: %s %s %s/%s accepted", c.verb, c.kind, c.namespace, c.name)
+}
+
+// audit writes the audit record of the successful write, if --audit-log is
+// set.
+func (c *apiCall) audit() {
+	if audit == nil {
+		return
+	}
+
+	record := auditRecord{
+		APIVersion: auditAPIVersion,
+		Time:       time.Now().UTC(),
+		Actor:      auditActor(),
+		Verb:       c.verb,
+		Kind:       c.kind,
+		Namespace:  c.namespace,
+		Name:       c.name,
+		Group:      c.group,
+		DryRun:     writesDisabled(),
+	}
+	if c.before != nil {
+		record.Before = summarize(c.before)
+	}
+	if c.after != nil {
+		record.After = summarize(c.after)
+	}
+	audit.record(record)
+}
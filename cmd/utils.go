@@ -1,9 +1,15 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
+	"sync"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/kubectl/pkg/scheme"
 )
 
@@ -30,3 +36,159 @@ func addTypeInformationToObject(obj runtime.Object) error {
 
 	return nil
 }
+
+// mergeMaps returns a new map containing the entries of all the given maps.
+// Later maps take precedence over earlier ones.
+func mergeMaps(maps ...map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, m := range maps {
+		for key, value := range m {
+			merged[key] = value
+		}
+	}
+
+	return merged
+}
+
+// setAnnotation sets a single annotation on obj.
+func setAnnotation(obj metav1.Object, key, value string) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[key] = value
+	obj.SetAnnotations(annotations)
+}
+
+// mergeAnnotations sets each of the given annotations on obj, leaving any
+// other annotations untouched.
+func mergeAnnotations(obj metav1.Object, annotations map[string]string) {
+	for key, value := range annotations {
+		setAnnotation(obj, key, value)
+	}
+}
+
+// mergeLabels sets each of the given labels on obj, leaving any other labels
+// untouched.
+func mergeLabels(obj metav1.Object, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+
+	merged := obj.GetLabels()
+	if merged == nil {
+		merged = map[string]string{}
+	}
+	for key, value := range labels {
+		merged[key] = value
+	}
+	obj.SetLabels(merged)
+}
+
+// mergeData sets each of the given keys in the data of secret, leaving any
+// other keys untouched.
+func mergeData(secret *corev1.Secret, data map[string][]byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	for key, value := range data {
+		secret.Data[key] = value
+	}
+}
+
+// hasData reports whether the data of secret holds each of the given keys
+// with the same value.
+func hasData(secret *corev1.Secret, data map[string][]byte) bool {
+	for key, value := range data {
+		if existing, ok := secret.Data[key]; !ok || !bytes.Equal(existing, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasLabels reports whether obj carries each of the given labels.
+func hasLabels(obj metav1.Object, labels map[string]string) bool {
+	current := obj.GetLabels()
+	for key, value := range labels {
+		if existing, ok := current[key]; !ok || existing != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasAnnotations reports whether obj carries each of the given annotations.
+func hasAnnotations(obj metav1.Object, annotations map[string]string) bool {
+	current := obj.GetAnnotations()
+	for key, value := range annotations {
+		if existing, ok := current[key]; !ok || existing != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// runParallel calls fn for each index in [0, n), running at most concurrency
+// calls at a time. It waits for all calls to finish and returns their
+// errors aggregated; a single error is returned as is. With a concurrency of
+// 1 the calls run one after the other and the first error stops the rest, so
+// that it is returned unwrapped.
+func runParallel(n, concurrency int, fn func(i int) error) error {
+	if concurrency <= 1 {
+		for i := 0; i < n; i++ {
+			if err := fn(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	errs := []error{}
+	semaphore := make(chan struct{}, concurrency)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			if err := fn(i); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	return utilerrors.Reduce(utilerrors.NewAggregate(errs))
+}
+
+// resourceVersionChanged reports whether an informer update event carries a
+// new version of the object. It does not assume the concrete type of the
+// objects, so unexpected values (such as tombstones) are treated as changes
+// and passed on to the handler to decode.
+func resourceVersionChanged(old, new interface{}) bool {
+	oldObject, err := meta.Accessor(old)
+	if err != nil {
+		return true
+	}
+
+	newObject, err := meta.Accessor(new)
+	if err != nil {
+		return true
+	}
+
+	return oldObject.GetResourceVersion() != newObject.GetResourceVersion()
+}
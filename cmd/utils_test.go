@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gccloudone-aurora/argo-controller/pkg/reconcile"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func forbiddenError(name string) error {
+	return apierrors.NewForbidden(schema.GroupResource{Resource: "serviceaccounts"}, name, errors.New("denied"))
+}
+
+func TestRunParallelTwoForbiddenWrites(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		wantCalls   int32
+	}{
+		{name: "sequential stops at the first error", concurrency: 1, wantCalls: 1},
+		{name: "parallel runs every write", concurrency: 2, wantCalls: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int32
+			err := runParallel(2, test.concurrency, func(i int) error {
+				atomic.AddInt32(&calls, 1)
+				return reconcile.Wrap(reconcile.PhaseApply, "ServiceAccount", forbiddenError("sa"))
+			})
+
+			if calls != test.wantCalls {
+				t.Errorf("got %d calls, want %d", calls, test.wantCalls)
+			}
+			if !reconcile.IsForbidden(err) {
+				t.Errorf("got %v, want a Forbidden error", err)
+			}
+			if test.concurrency == 1 && !apierrors.IsForbidden(err) {
+				t.Errorf("got %v, want an unwrapped Forbidden error", err)
+			}
+		})
+	}
+}
+
+func TestRunParallelNoError(t *testing.T) {
+	var calls int32
+	err := runParallel(5, 3, func(i int) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+
+	if err != nil {
+		t.Errorf("got %v, want no error", err)
+	}
+	if calls != 5 {
+		t.Errorf("got %d calls, want 5", calls)
+	}
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// validateName checks that a required object name supplied through flag is
+// a valid RFC 1123 subdomain.
+func validateName(flag, name string) error {
+	if name == "" {
+		return fmt.Errorf("--%s: is required", flag)
+	}
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return fmt.Errorf("--%s: invalid name %q: %s", flag, name, strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// validateNamespace checks that a namespace supplied through flag is a valid
+// RFC 1123 label.
+func validateNamespace(flag, namespace string) error {
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return fmt.Errorf("--%s: invalid namespace %q: %s", flag, namespace, strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// validateLabels checks that labels supplied through flag are valid
+// Kubernetes labels.
+func validateLabels(flag string, labels map[string]string) error {
+	for key, value := range labels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("--%s: invalid label key %q: %s", flag, key, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("--%s: invalid value for label %q: %s", flag, key, strings.Join(errs, "; "))
+		}
+	}
+
+	return nil
+}
+
+// validateAnnotations checks that annotations supplied through flag have
+// valid keys and do not override any of the reserved annotations.
+func validateAnnotations(flag string, annotations map[string]string, reserved []string) error {
+	for key := range annotations {
+		if errs := validation.IsQualifiedName(strings.ToLower(key)); len(errs) > 0 {
+			return fmt.Errorf("--%s: invalid annotation key %q: %s", flag, key, strings.Join(errs, "; "))
+		}
+
+		for _, reservedKey := range reserved {
+			if key == reservedKey {
+				return fmt.Errorf("--%s: annotation %q is managed by the controller and cannot be set", flag, key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateSecretKey checks that a secret data key supplied through flag is valid.
+func validateSecretKey(flag, key string) error {
+	if errs := validation.IsConfigMapKey(key); len(errs) > 0 {
+		return fmt.Errorf("--%s: invalid secret key %q: %s", flag, key, strings.Join(errs, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,308 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gccloudone-aurora/argo-controller/pkg/metrics"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+// webhookSignatureHeader holds the HMAC-SHA256 of the body of a webhook
+// notification, keyed with --webhook-secret.
+const webhookSignatureHeader = "X-Argo-Controller-Signature"
+
+// webhookRetryDelay is the delay before the first retry of a notification,
+// doubled on each further retry up to webhookMaxRetryDelay.
+const webhookRetryDelay = time.Second
+const webhookMaxRetryDelay = time.Second * 30
+
+var webhookURL string
+var webhookSecret string
+
+// webhookQueueSize bounds the notifications waiting to be delivered. Further
+// notifications are dropped rather than blocking reconciles.
+var webhookQueueSize int
+var webhookTimeout time.Duration
+var webhookMaxRetries int
+
+// webhookAction is a write made by a reconcile.
+type webhookAction struct {
+	Verb string `json:"verb"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// webhookPayload is the body of a webhook notification.
+type webhookPayload struct {
+	Namespace     string          `json:"namespace"`
+	GroupsAdded   []string        `json:"groupsAdded"`
+	GroupsRemoved []string        `json:"groupsRemoved"`
+	Actions       []webhookAction `json:"actions"`
+	Time          time.Time       `json:"time"`
+}
+
+// reconcileChanges collects the writes made by a reconcile. It is carried in
+// the context of the reconcile, and is safe for concurrent use.
+type reconcileChanges struct {
+	mu            sync.Mutex
+	actions       []webhookAction
+	skipped       []webhookAction
+	groupsRemoved []string
+}
+
+type reconcileChangesKey struct{}
+
+// withChanges returns a context collecting the writes made with it.
+func withChanges(ctx context.Context) (context.Context, *reconcileChanges) {
+	changes := &reconcileChanges{}
+	return context.WithValue(ctx, reconcileChangesKey{}, changes), changes
+}
+
+// changesFrom returns the changes collected by ctx, or nil.
+func changesFrom(ctx context.Context) *reconcileChanges {
+	changes, _ := ctx.Value(reconcileChangesKey{}).(*reconcileChanges)
+	return changes
+}
+
+// recordAction records a successful write.
+func (c *reconcileChanges) recordAction(verb, kind, name string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.actions = append(c.actions, webhookAction{Verb: verb, Kind: kind, Name: name})
+}
+
+// recordSkipped records an object left alone rather than written.
+func (c *reconcileChanges) recordSkipped(kind, name string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.skipped = append(c.skipped, webhookAction{Verb: "Skip", Kind: kind, Name: name})
+}
+
+// summary returns the number of objects created, updated, deleted and
+// skipped, in total and by kind, as space separated key=value pairs. Kinds
+// with nothing to report are left out. It also reports whether anything was
+// written or skipped.
+func (c *reconcileChanges) summary() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	verbs := []struct{ verb, key string }{
+		{"Create", "created"},
+		{"Update", "updated"},
+		{"Delete", "deleted"},
+		{"Skip", "skipped"},
+	}
+	totals := map[string]int{}
+	byKind := map[string]int{}
+	for _, action := range append(append([]webhookAction{}, c.actions...), c.skipped...) {
+		totals[action.Verb]++
+		byKind[strings.ToLower(action.Kind)+"."+action.Verb]++
+	}
+
+	var pairs []string
+	for _, v := range verbs {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", v.key, totals[v.verb]))
+	}
+	for _, kind := range []string{"serviceaccount", "rolebinding", "secret"} {
+		for _, v := range verbs {
+			if count := byKind[kind+"."+v.verb]; count > 0 {
+				pairs = append(pairs, fmt.Sprintf("%s.%s=%d", kind, v.key, count))
+			}
+		}
+	}
+
+	return strings.Join(pairs, " "), len(c.actions)+len(c.skipped) > 0
+}
+
+// recordGroupRemoved records that the objects of a group were deleted.
+func (c *reconcileChanges) recordGroupRemoved(group string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.groupsRemoved = append(c.groupsRemoved, group)
+}
+
+// payload returns the notification describing the changes made to a
+// namespace provisioned for groups, and whether anything changed. A group is
+// added when its service account was created.
+func (c *reconcileChanges) payload(namespace string, groups []string) (webhookPayload, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.actions) == 0 {
+		return webhookPayload{}, false
+	}
+
+	created := map[string]bool{}
+	for _, action := range c.actions {
+		if action.Verb == "Create" && action.Kind == "ServiceAccount" {
+			created[action.Name] = true
+		}
+	}
+
+	payload := webhookPayload{
+		Namespace:     namespace,
+		GroupsAdded:   []string{},
+		GroupsRemoved: append([]string{}, c.groupsRemoved...),
+		Actions:       append([]webhookAction{}, c.actions...),
+		Time:          time.Now().UTC(),
+	}
+	for _, group := range groups {
+		names, err := namesForGroup(namespace, group)
+		if err == nil && created[names.serviceAccount] {
+			payload.GroupsAdded = append(payload.GroupsAdded, group)
+		}
+	}
+	sort.Strings(payload.GroupsRemoved)
+
+	return payload, true
+}
+
+// webhookNotifier delivers notifications to --webhook-url in the
+// background, one at a time.
+type webhookNotifier struct {
+	url    string
+	secret []byte
+	client *http.Client
+	queue  chan webhookPayload
+	stopCh <-chan struct{}
+}
+
+// newWebhookNotifier returns a notifier delivering to url until stopCh is
+// closed. Notifications are signed with secret, unless it is empty.
+func newWebhookNotifier(url, secret string, stopCh <-chan struct{}) *webhookNotifier {
+	notifier := &webhookNotifier{
+		url:    url,
+		secret: []byte(secret),
+		client: &http.Client{Timeout: webhookTimeout},
+		queue:  make(chan webhookPayload, webhookQueueSize),
+		stopCh: stopCh,
+	}
+	go notifier.run(stopCh)
+
+	return notifier
+}
+
+// notify queues a notification without waiting for it to be delivered. It
+// does nothing on a nil notifier.
+func (n *webhookNotifier) notify(payload webhookPayload) {
+	if n == nil {
+		return
+	}
+
+	select {
+	case n.queue <- payload:
+	default:
+		klog.Warningf("webhook queue full, dropping notification for namespace %s", payload.Namespace)
+		metrics.WebhookNotifications.Inc("dropped")
+	}
+}
+
+// run delivers the queued notifications until stopCh is closed.
+func (n *webhookNotifier) run(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		case payload := <-n.queue:
+			n.deliver(payload)
+		}
+	}
+}
+
+// deliver posts a notification, retrying up to --webhook-max-retries times
+// with an exponential backoff. Retries are abandoned once stopCh is closed.
+// Failures are only logged and counted.
+func (n *webhookNotifier) deliver(payload webhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		klog.Errorf("error encoding webhook notification for namespace %s: %v", payload.Namespace, err)
+		metrics.WebhookNotifications.Inc("failed")
+		return
+	}
+
+	backoff := wait.Backoff{
+		Duration: webhookRetryDelay,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    webhookMaxRetries,
+		Cap:      webhookMaxRetryDelay,
+	}
+	for attempt := 0; ; attempt++ {
+		err = n.post(body)
+		if err == nil {
+			metrics.WebhookNotifications.Inc("delivered")
+			return
+		}
+		if attempt >= webhookMaxRetries {
+			break
+		}
+
+		delay := backoff.Step()
+		klog.V(2).Infof("error delivering webhook notification for namespace %s, retrying in %s: %v", payload.Namespace, delay.Round(time.Millisecond), err)
+		select {
+		case <-n.stopCh:
+			metrics.WebhookNotifications.Inc("failed")
+			return
+		case <-time.After(delay):
+		}
+	}
+
+	klog.Errorf("error delivering webhook notification for namespace %s after %d attempts: %v", payload.Namespace, webhookMaxRetries+1, err)
+	metrics.WebhookNotifications.Inc("failed")
+}
+
+// post sends a single request, failing on any non-2xx response.
+func (n *webhookNotifier) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(n.secret) > 0 {
+		mac := hmac.New(sha256.New, n.secret)
+		mac.Write(body)
+		req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response %s", resp.Status)
+	}
+
+	return nil
+}
@@ -3,217 +3,973 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"os"
-	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gccloudone-aurora/argo-controller/pkg/controllers/namespaces"
+	"github.com/gccloudone-aurora/argo-controller/pkg/debug"
+	"github.com/gccloudone-aurora/argo-controller/pkg/metrics"
+	"github.com/gccloudone-aurora/argo-controller/pkg/reconcile"
+	"github.com/gccloudone-aurora/argo-controller/pkg/secretprovider"
 	"github.com/gccloudone-aurora/argo-controller/pkg/signals"
+	"github.com/gccloudone-aurora/argo-controller/pkg/tracing"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	kubeinformers "k8s.io/client-go/informers"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	rbacv1informers "k8s.io/client-go/informers/rbac/v1"
 	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
 	rbacv1listers "k8s.io/client-go/listers/rbac/v1"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog"
 )
 
 var namespaceAdminsRB string
+var namespaceAdminsRBPattern string
+var namespaceAdminsRBRegexp *regexp.Regexp
+var adminRoleName string
 var argoUserInterfaceCR string
+var argoUserInterfaceCRNames []string
+
+// userInterfaceCRs are the cluster roles every group is bound to, from
+// --user-interface-cluster-role-name followed by
+// --user-interface-cluster-role-names.
+var userInterfaceCRs []string
+var defaultUIGroups []string
 var workflowsCR string
+var fullResyncInterval time.Duration
+var requeueAfterSuccess time.Duration
+var resyncJitter float64
+var perNamespaceConcurrency int
+var runOnce bool
+var runOnceConcurrency int
+var workflowWorkers int
+var disableUIAccess bool
+var namespaceAllowlistFile string
+var useFinalizers bool
+var watchClusterRoles bool
+var manageStorageSecret bool
+var secretProviderName string
+var secretProviderDir string
+
+// storageSecretProvider supplies the storage account credentials, as
+// selected by --secret-provider. It is nil for the secret provider, which
+// newWorkflowsController creates with its informer.
+var storageSecretProvider secretprovider.Provider
+var tokenSecretAnnotations map[string]string
+var commonLabels map[string]string
+var secretLabels map[string]string
+var commonAnnotations map[string]string
+var roleBindingAnnotations map[string]string
+var workflowServiceAccountAnnotations map[string]string
+var roleRefKind string
+var storageSecretUserKey string
+var storageSecretPasswordKey string
+var storageSecretExtra map[string]string
+var tokenSecretMaxAge time.Duration
+var adoptExisting bool
+var forceApply bool
+var rbacRulePrecedence int
+var groupPrecedenceFlag map[string]string
+var groupTierPrecedenceFlag map[string]string
+
+// groupPrecedences holds the parsed --group-precedence overrides.
+var groupPrecedences map[string]int
+
+// groupTierPrecedences holds the parsed --group-tier-precedence values.
+var groupTierPrecedences map[string]int
+
+// uiAccessAnnotation, set on a namespace to "disabled", provisions only the
+// runner service account and its role binding in the namespace, without any
+// per-group user interface service accounts. Set to "enabled", it overrides
+// --disable-ui-access.
+const uiAccessAnnotation = "argo-workflows.aurora/ui-access"
+
+// excludeGroupsAnnotation, set on a namespace admins role binding to a comma
+// separated list of groups, keeps those subjects of the role binding from
+// being given user interface access.
+const excludeGroupsAnnotation = "argo-workflows.aurora/exclude-groups"
+
+// managedByLabels are added to every generated object, so the objects
+// created by the controller can be found again, e.g. by "workflows purge".
+var managedByLabels = map[string]string{
+	"app.kubernetes.io/managed-by": "argo-controller",
+}
+
+// reservedAnnotations are the annotations the controller relies on to
+// function. They cannot be set through user supplied annotations.
+var reservedAnnotations = []string{
+	specHashAnnotation,
+	ownedAnnotationsAnnotation,
+	"workflows.argoproj.io/rbac-rule",
+	"workflows.argoproj.io/rbac-rule-precedence",
+	"kubernetes.io/service-account.name",
+}
 
 var workflowsCmd = &cobra.Command{
 	Use:   "workflows",
 	Short: "Configure access control resources for Argo Workflows",
 	Long:  `Configure access control resources for Argo Workflows.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return validateWorkflowsFlags()
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		// Setup signals so we can shutdown cleanly
-		stopCh := signals.SetupSignalHandler()
+		stopCh, _ := signals.SetupSignalHandler()
+		reloadCh := signals.SetupReloadHandler()
+
+		kubeClient, forbidden := setupController(stopCh, workflowsPermissions())
+
+		runAsLeader(kubeClient, "argo-controller-workflows", stopCh, func(stopCh <-chan struct{}) {
+			kubeInformerFactory := newInformerFactory(kubeClient)
 
-		// Create Kubernetes config
-		cfg, err := clientcmd.BuildConfigFromFlags(apiserver, kubeconfig)
+			// Setup controller
+			controller, synced := newWorkflowsController(stopCh, reloadCh, kubeClient, kubeInformerFactory, forbidden)
+			setReadyCheck("informers", syncedCheck(synced))
+			if waitFirstReconcile {
+				setReadyCheck("first-reconcile", controller.FirstReconcileComplete)
+			}
+
+			// Start informers
+			kubeInformerFactory.Start(stopCh)
+
+			// Wait for caches
+			klog.Info("Waiting for informer caches to sync")
+			if ok := waitForCacheSync(stopCh, synced...); !ok {
+				if stopped(stopCh) {
+					return
+				}
+				klog.Fatalf("failed to wait for caches to sync within %s, see --cache-sync-timeout", cacheSyncTimeoutOrDefault())
+			}
+
+			// Reconcile every namespace once and exit
+			if runOnce {
+				if err := controller.RunOnce(stopCh, runOnceConcurrency); err != nil {
+					klog.Fatalf("error reconciling namespaces: %v", err)
+				}
+
+				klog.Info("Reconciled all namespaces")
+				return
+			}
+
+			// Periodically reconcile every namespace, regardless of informer events
+			if fullResyncInterval > 0 {
+				go controller.RunFullResync(fullResyncInterval, stopCh)
+			}
+
+			// Run the controller
+			if err := controller.Run(workflowWorkers, stopCh); err != nil && !stopped(stopCh) {
+				klog.Fatalf("error running controller: %v", err)
+			}
+		})
+	},
+}
+
+// validateWorkflowsFlags validates the flags of the workflows controller.
+func validateWorkflowsFlags() error {
+	if namespaceAdminsRBPattern != "" {
+		pattern, err := regexp.Compile("^(?:" + namespaceAdminsRBPattern + ")$")
 		if err != nil {
-			klog.Fatalf("error building kubeconfig: %v", err)
+			return fmt.Errorf("--namespace-admins-role-binding-pattern: invalid regular expression: %v", err)
 		}
+		namespaceAdminsRBRegexp = pattern
 
-		kubeClient, err := kubernetes.NewForConfig(cfg)
+		if namespaceAdminsRB != "" {
+			if err := validateName("namespace-admins-role-binding-name", namespaceAdminsRB); err != nil {
+				return err
+			}
+		}
+	} else if namespaceAdminsRB != "" || adminRoleName == "" {
+		if err := validateName("namespace-admins-role-binding-name", namespaceAdminsRB); err != nil {
+			return err
+		}
+	}
+	if adminRoleName != "" {
+		if err := validateName("admin-role-name", adminRoleName); err != nil {
+			return err
+		}
+	}
+	userInterfaceCRs = []string{}
+	seenCRs := map[string]bool{}
+	if argoUserInterfaceCR != "" {
+		if err := validateName("user-interface-cluster-role-name", argoUserInterfaceCR); err != nil {
+			return err
+		}
+		userInterfaceCRs = append(userInterfaceCRs, argoUserInterfaceCR)
+		seenCRs[argoUserInterfaceCR] = true
+	}
+	for _, name := range argoUserInterfaceCRNames {
+		if err := validateName("user-interface-cluster-role-names", name); err != nil {
+			return err
+		}
+		if !seenCRs[name] {
+			userInterfaceCRs = append(userInterfaceCRs, name)
+			seenCRs[name] = true
+		}
+	}
+	if len(userInterfaceCRs) == 0 {
+		return fmt.Errorf("--user-interface-cluster-role-name or --user-interface-cluster-role-names is required")
+	}
+	for _, group := range defaultUIGroups {
+		if strings.TrimSpace(group) == "" {
+			return fmt.Errorf("--default-ui-groups: group names must not be empty")
+		}
+	}
+	if err := validateName("argo-workflows-cluster-role-name", workflowsCR); err != nil {
+		return err
+	}
+	if webhookURL != "" {
+		if u, err := url.Parse(webhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("--webhook-url: must be an absolute http or https URL, got %q", webhookURL)
+		}
+	} else if webhookSecret != "" {
+		return fmt.Errorf("--webhook-secret: requires --webhook-url")
+	}
+	if webhookQueueSize < 1 {
+		return fmt.Errorf("--webhook-queue-size: must be at least 1, got %d", webhookQueueSize)
+	}
+	if webhookTimeout <= 0 {
+		return fmt.Errorf("--webhook-timeout: must be positive, got %s", webhookTimeout)
+	}
+	if webhookMaxRetries < 0 {
+		return fmt.Errorf("--webhook-max-retries: must not be negative, got %d", webhookMaxRetries)
+	}
+	if err := validateNameTemplates(); err != nil {
+		return err
+	}
+	if fullResyncInterval < 0 {
+		return fmt.Errorf("--full-resync-interval: must not be negative, got %s", fullResyncInterval)
+	}
+	if runOnce && leaderElect {
+		return fmt.Errorf("--run-once: cannot be combined with --leader-elect")
+	}
+	if runOnceConcurrency < 1 {
+		return fmt.Errorf("--run-once-concurrency: must be at least 1, got %d", runOnceConcurrency)
+	}
+	if requeueAfterSuccess < 0 {
+		return fmt.Errorf("--requeue-after-success: must not be negative, got %s", requeueAfterSuccess)
+	}
+	if resyncJitter < 0 || resyncJitter > 1 {
+		return fmt.Errorf("--resync-jitter: must be between 0 and 1, got %g", resyncJitter)
+	}
+	if tokenSecretMaxAge < 0 {
+		return fmt.Errorf("--token-secret-max-age: must not be negative, got %s", tokenSecretMaxAge)
+	}
+	if workflowWorkers < 1 {
+		return fmt.Errorf("--workflow-workers: must be at least 1, got %d", workflowWorkers)
+	}
+	if perNamespaceConcurrency < 1 {
+		return fmt.Errorf("--per-namespace-concurrency: must be at least 1, got %d", perNamespaceConcurrency)
+	}
+	if roleRefKind != "ClusterRole" && roleRefKind != "Role" {
+		return fmt.Errorf("--role-ref-kind: must be ClusterRole or Role, got %q", roleRefKind)
+	}
+	if watchClusterRoles && roleRefKind != "ClusterRole" {
+		return fmt.Errorf("--watch-cluster-roles: requires --role-ref-kind=ClusterRole")
+	}
+	groupPrecedences = map[string]int{}
+	for group, value := range groupPrecedenceFlag {
+		precedence, err := strconv.Atoi(value)
 		if err != nil {
-			klog.Fatalf("Error building kubernetes clientset: %s", err.Error())
+			return fmt.Errorf("--group-precedence: precedence of group %q must be an integer, got %q", group, value)
 		}
+		groupPrecedences[group] = precedence
+	}
+	groupTierPrecedences = map[string]int{}
+	for tier, value := range groupTierPrecedenceFlag {
+		if tier == "" {
+			return fmt.Errorf("--group-tier-precedence: tier must not be empty")
+		}
+		precedence, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("--group-tier-precedence: precedence of tier %q must be an integer, got %q", tier, value)
+		}
+		groupTierPrecedences[tier] = precedence
+	}
 
-		// Setup informers
-		kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Minute*5)
+	if err := validateSecretKey("storage-secret-user-key", storageSecretUserKey); err != nil {
+		return err
+	}
+	if err := validateSecretKey("storage-secret-password-key", storageSecretPasswordKey); err != nil {
+		return err
+	}
+	if storageSecretUserKey == storageSecretPasswordKey {
+		return fmt.Errorf("--storage-secret-user-key and --storage-secret-password-key must differ")
+	}
+	provider, err := secretprovider.New(secretProviderName, secretprovider.Keys{User: storageSecretUserKey, Password: storageSecretPasswordKey}, secretProviderDir)
+	if err != nil {
+		return fmt.Errorf("--secret-provider: %v, must be one of %s", err, strings.Join(secretprovider.Names, ", "))
+	}
+	storageSecretProvider = provider
+	if err := validateStorageSecretSpecs(); err != nil {
+		return err
+	}
+	if secretProviderName == "secret" {
+		if secretProviderNamespace == "" {
+			return fmt.Errorf("--secret-provider: secret requires --secret-provider-namespace")
+		}
+		if err := validateNamespace("secret-provider-namespace", secretProviderNamespace); err != nil {
+			return err
+		}
+		selector, err := labels.Parse(secretProviderSelector)
+		if err != nil {
+			return fmt.Errorf("--secret-provider-selector: %v", err)
+		}
+		if selector.Empty() {
+			return fmt.Errorf("--secret-provider-selector: must not be empty")
+		}
+		storageCredentialsSelector = selector
+	}
+	for key := range storageSecretExtra {
+		if err := validateSecretKey("storage-secret-extra", key); err != nil {
+			return err
+		}
+		if key == storageSecretUserKey || key == storageSecretPasswordKey {
+			return fmt.Errorf("--storage-secret-extra: key %q is already used for the storage account credentials", key)
+		}
+	}
+	if err := validateStorageSecretType(); err != nil {
+		return err
+	}
 
-		// Namespaces informer
-		namespaceInformer := kubeInformerFactory.Core().V1().Namespaces()
+	if err := validateLabels("common-labels", commonLabels); err != nil {
+		return err
+	}
+	if err := validateLabels("secret-labels", secretLabels); err != nil {
+		return err
+	}
+	for key := range managedByLabels {
+		if _, ok := commonLabels[key]; ok {
+			return fmt.Errorf("--common-labels: label %q is managed by the controller and cannot be set", key)
+		}
+		if _, ok := secretLabels[key]; ok {
+			return fmt.Errorf("--secret-labels: label %q is managed by the controller and cannot be set", key)
+		}
+	}
+	if err := validateAnnotations("common-annotations", commonAnnotations, reservedAnnotations); err != nil {
+		return err
+	}
+	if err := validateAnnotations("workflow-sa-annotations", workflowServiceAccountAnnotations, reservedAnnotations); err != nil {
+		return err
+	}
+	if err := validateAnnotations("token-secret-annotations", tokenSecretAnnotations, reservedAnnotations); err != nil {
+		return err
+	}
+	if err := validateAnnotations("rolebinding-annotations", roleBindingAnnotations, reservedAnnotations); err != nil {
+		return err
+	}
+	if err := validateGroupAnnotationsFlags(); err != nil {
+		return err
+	}
+	if err := validateWorkloadIdentityFlags(); err != nil {
+		return err
+	}
+	if err := validateSelfNamespace(); err != nil {
+		return err
+	}
+	if waitFirstReconcile && metricsAddr == "" {
+		return fmt.Errorf("--wait-first-reconcile: requires --metrics-addr, which serves /readyz")
+	}
 
-		// Serviceaccount informer
-		serviceAccountsInformer := kubeInformerFactory.Core().V1().ServiceAccounts()
-		serviceAccountsLister := serviceAccountsInformer.Lister()
+	return nil
+}
 
-		// Rolebinding informer
-		roleBindingInformer := kubeInformerFactory.Rbac().V1().RoleBindings()
-		roleBindingLister := roleBindingInformer.Lister()
+// newWorkflowsController creates the workflows controller, registering the
+// informers it needs with kubeInformerFactory. It returns the controller and
+// the functions reporting whether those informers have synced. The namespace
+// allowlist, if any, is watched until stopCh is closed, and the configuration
+// is reloaded whenever reloadCh receives.
+func newWorkflowsController(stopCh, reloadCh <-chan struct{}, kubeClient kubernetes.Interface, kubeInformerFactory kubeinformers.SharedInformerFactory, forbidden *forbiddenReporter) (*namespaces.Controller, []cache.InformerSynced) {
+	// Serviceaccount informer
+	serviceAccountsInformer := kubeInformerFactory.Core().V1().ServiceAccounts()
+	serviceAccountsLister := serviceAccountsInformer.Lister()
 
-		// Secrets informer
-		secretsInformer := kubeInformerFactory.Core().V1().Secrets()
-		secretsLister := secretsInformer.Lister()
+	// Rolebinding informer
+	roleBindingInformer := kubeInformerFactory.Rbac().V1().RoleBindings()
+	roleBindingLister := roleBindingInformer.Lister()
 
-		// Setup controller
-		controller := namespaces.NewController(
-			namespaceInformer,
-			func(namespace *corev1.Namespace) error {
-				// Generate SA
-				serviceAccounts, err := generateServiceAccounts(namespace, roleBindingLister)
-				if err != nil {
-					return err
-				}
+	// Secrets informer. With --cache-managed-secrets-only, the managed
+	// secrets and the storage secret are cached by informers of their own,
+	// as the shared factory also caches the unlabelled role bindings.
+	secretsInformer := kubeInformerFactory.Core().V1().Secrets()
+	var secretsFactory, storageSecretFactory kubeinformers.SharedInformerFactory
+	var storageSecretInformer corev1informers.SecretInformer
+	var storageSecretLister corev1listers.SecretLister
+	if cacheManagedSecretsOnly {
+		secretsFactory = newManagedSecretsInformerFactory(kubeClient)
+		secretsInformer = secretsFactory.Core().V1().Secrets()
+		if manageStorageSecret {
+			storageSecretFactory = newStorageSecretInformerFactory(kubeClient)
+			storageSecretInformer = storageSecretFactory.Core().V1().Secrets()
+			storageSecretLister = storageSecretInformer.Lister()
+		}
+	}
+	secretsLister := secretsInformer.Lister()
 
-				// Generate RBAC
-				roleBindings, err := generateRoleBindings(namespace, roleBindingLister)
-				if err != nil {
-					return err
-				}
+	// Cluster role informer, only when the referenced cluster roles are
+	// watched as it needs a cluster-wide watch
+	var clusterRoleInformer rbacv1informers.ClusterRoleInformer
+	if watchClusterRoles {
+		clusterRoleInformer = kubeInformerFactory.Rbac().V1().ClusterRoles()
+	}
 
-				// Generate Secrets
-				secrets, err := generateSecrets(namespace, roleBindingLister)
-				if err != nil {
-					return err
-				}
+	// Change notifications
+	var webhook *webhookNotifier
+	if webhookURL != "" {
+		webhook = newWebhookNotifier(webhookURL, resolveSecretValue(webhookSecret), stopCh)
+	}
 
-				// Create
-				for _, serviceAccount := range serviceAccounts {
-					currentServiceAccount, err := serviceAccountsLister.ServiceAccounts(serviceAccount.Namespace).Get(serviceAccount.Name)
-					if errors.IsNotFound(err) {
-						klog.Infof("creating service account %s/%s", serviceAccount.Namespace, serviceAccount.Name)
-						currentServiceAccount, err = kubeClient.CoreV1().ServiceAccounts(serviceAccount.Namespace).Create(context.Background(), serviceAccount, metav1.CreateOptions{})
-						if err != nil {
-							return err
-						}
-					}
+	// Storage credentials secret informer, only for the secret provider
+	provider := storageSecretProvider
+	var credentialsFactory kubeinformers.SharedInformerFactory
+	var credentialsInformer corev1informers.SecretInformer
+	if secretProviderName == "secret" {
+		credentialsFactory = newStorageCredentialsInformerFactory(kubeClient)
+		credentialsInformer = credentialsFactory.Core().V1().Secrets()
+		provider = secretprovider.NewSecret(
+			secretprovider.Keys{User: storageSecretUserKey, Password: storageSecretPasswordKey},
+			credentialsInformer.Lister(), secretProviderNamespace, storageCredentialsSelector,
+		)
+	}
 
-					if !reflect.DeepEqual(serviceAccount.Annotations, currentServiceAccount.Annotations) || !reflect.DeepEqual(serviceAccount.Secrets, currentServiceAccount.Secrets) {
-						klog.Infof("updating service account %s/%s", serviceAccount.Namespace, serviceAccount.Name)
-						currentServiceAccount.Annotations = serviceAccount.Annotations
-						currentServiceAccount.Secrets = serviceAccount.Secrets
-						_, err = kubeClient.CoreV1().ServiceAccounts(serviceAccount.Namespace).Update(context.Background(), currentServiceAccount, metav1.UpdateOptions{})
-						if err != nil {
-							return err
-						}
-					}
-				}
+	// The storage secrets generated in every namespace: the one named by
+	// ARGO_SECRET_NAME, then any --storage-secret
+	storageSecrets := []storageSecretSpec{}
+	if manageStorageSecret {
+		storageSecrets = append(storageSecrets, storageSecretSpec{name: os.Getenv("ARGO_SECRET_NAME"), provider: provider, secretType: corev1.SecretType(storageSecretType), extra: true})
+	}
+	storageSecrets = append(storageSecrets, additionalStorageSecrets...)
 
-				for _, roleBinding := range roleBindings {
-					currentRoleBinding, err := roleBindingLister.RoleBindings(roleBinding.Namespace).Get(roleBinding.Name)
-					if errors.IsNotFound(err) {
-						klog.Infof("creating role binding %s/%s", roleBinding.Namespace, roleBinding.Name)
-						currentRoleBinding, err = kubeClient.RbacV1().RoleBindings(roleBinding.Namespace).Create(context.Background(), roleBinding, metav1.CreateOptions{})
-						if err != nil {
-							return err
-						}
-					}
+	// Annotations of the service accounts of each group
+	var annotations *groupAnnotations
+	if groupAnnotationsConfigMap != "" {
+		annotations = &groupAnnotations{}
+	}
 
-					if !reflect.DeepEqual(roleBinding.RoleRef, currentRoleBinding.RoleRef) || !reflect.DeepEqual(roleBinding.Subjects, currentRoleBinding.Subjects) {
-						klog.Infof("updating role binding %s/%s", roleBinding.Namespace, roleBinding.Name)
-						currentRoleBinding.RoleRef = roleBinding.RoleRef
-						currentRoleBinding.Subjects = roleBinding.Subjects
+	// Namespace allowlist
+	var allowlist *namespaceAllowlist
+	if namespaceAllowlistFile != "" {
+		allowlist = newNamespaceAllowlist(namespaceAllowlistFile)
+	}
 
-						_, err = kubeClient.RbacV1().RoleBindings(roleBinding.Namespace).Update(context.Background(), currentRoleBinding, metav1.UpdateOptions{})
-						if err != nil {
-							return err
-						}
-					}
-				}
+	// The namespace of the controller is skipped
+	switch {
+	case selfNamespace != "":
+		klog.Infof("skipping namespace %s, which the controller runs in; set --skip-self-namespace=false to reconcile it", selfNamespace)
+	case skipSelfNamespace:
+		klog.Warning("cannot find the namespace the controller runs in, set POD_NAMESPACE for --skip-self-namespace to skip it")
+	}
 
-				for _, secret := range secrets {
-					currentSecret, err := secretsLister.Secrets(secret.Namespace).Get(secret.Name)
-					if errors.IsNotFound(err) {
-						klog.Infof("creating secret %s/%s", secret.Namespace, secret.Name)
-						currentSecret, err = kubeClient.CoreV1().Secrets(secret.Namespace).Create(context.Background(), secret, metav1.CreateOptions{})
-						if err != nil {
-							return err
-						}
-					}
+	// Reconciles in flight are cancelled when stopCh is closed, such as when
+	// leadership is lost
+	stopCtx := stopContext(stopCh)
 
-					if !reflect.DeepEqual(secret.Data, currentSecret.Data) {
-						klog.Infof("updating secret %s/%s", secret.Namespace, secret.Name)
-						currentSecret.Data = secret.Data
+	// Reconcile the Argo resources of a namespace
+	sync := func(namespace *corev1.Namespace) (err error) {
+		if !allowlist.allowed(namespace.Name) {
+			skipNamespace(namespace, metrics.SkipNotAllowlisted)
+			return nil
+		}
+		if isSelfNamespace(namespace.Name) {
+			skipNamespace(namespace, metrics.SkipSelfNamespace)
+			return nil
+		}
 
-						_, err = kubeClient.CoreV1().Secrets(secret.Namespace).Update(context.Background(), currentSecret, metav1.UpdateOptions{})
-						if err != nil {
-							return err
-						}
-					}
-				}
+		ctx, span := tracing.Start(stopCtx, "Reconcile", tracing.String("k8s.namespace.name", namespace.Name))
+		defer func() { span.End(err) }()
+		ctx, changes := withChanges(ctx)
+
+		// Log a single line summing up the writes of the reconcile; each
+		// write is only logged at a higher verbosity
+		defer func() {
+			result := "success"
+			if err != nil {
+				result = "error"
+			}
+			summary, changed := changes.summary()
+			if changed {
+				klog.Infof("reconciled namespace=%s result=%s %s", namespace.Name, result, summary)
+			} else {
+				klog.V(2).Infof("reconciled namespace=%s result=%s %s", namespace.Name, result, summary)
+			}
+		}()
+
+		// Nothing can be created in a terminating namespace
+		terminating := namespace.DeletionTimestamp != nil || namespace.Status.Phase == corev1.NamespaceTerminating
+
+		// Record the outcome on the namespace. This is not possible in
+		// namespaced mode, where the controller cannot update namespaces.
+		if watchNamespace == "" && !terminating {
+			defer func() { recordReconcileStatus(ctx, kubeClient, namespace, err) }()
+		}
+
+		// Record failures as events named after their reason. Forbidden
+		// errors are already reported by the forbiddenReporter.
+		defer func() {
+			if err != nil && !reconcile.IsForbidden(err) {
+				forbidden.recorder.Eventf(namespace, corev1.EventTypeWarning, "Reconcile"+reconcile.Reason(err), "%v", err)
+			}
+		}()
+
+		// Warn about role bindings referencing missing cluster roles
+		if clusterRoleInformer != nil {
+			for _, name := range missingClusterRoles(clusterRoleInformer.Lister()) {
+				forbidden.recorder.Eventf(namespace, corev1.EventTypeWarning, "ClusterRoleNotFound", "cluster role %s referenced by the generated role bindings does not exist", name)
+			}
+		}
+
+		// Clean up after deleted namespace admins role bindings. A failure,
+		// such as the admins role binding not being readable, is returned
+		// once the storage secrets, which do not depend on it, are
+		// reconciled.
+		finalizersErr := reconcileAdminsFinalizers(ctx, kubeClient, namespace, roleBindingLister)
+		if finalizersErr != nil {
+			finalizersErr = reconcile.Wrap(reconcile.PhaseCleanup, "RoleBinding", forbidden.check(namespace, "update", "rolebindings", namespace.Name, finalizersErr))
+		}
+
+		// Skip terminating namespaces once the finalizers are released, as
+		// the API server rejects any object created in them
+		if terminating {
+			if finalizersErr != nil {
+				return finalizersErr
+			}
+			skipNamespace(namespace, metrics.SkipTerminating)
+			return nil
+		}
+
+		// The group each per-group object is provisioned for, by kind and
+		// name, recorded in the audit log. Filled in once the groups are
+		// known.
+		objectGroups := map[string]string{}
+		groupContext := func(kind, name string) context.Context {
+			if group, ok := objectGroups[kind+"/"+name]; ok {
+				return withAuditGroup(ctx, group)
+			}
+			return ctx
+		}
+
+		// Create or update the generated objects
+		serviceAccountApplier := newServiceAccountApplier(kubeClient, serviceAccountsLister, forbidden, namespace)
+		appliedServiceAccounts := newServiceAccountUIDs()
+		if tokenSecretOwnerReferences {
+			serviceAccountApplier.Applied = appliedServiceAccounts.record
+		}
+		applyServiceAccount := func(serviceAccount *corev1.ServiceAccount) error {
+			setOwnedAnnotations(serviceAccount)
+			if _, err := setSpecHash(serviceAccount, serviceAccount.Labels, serviceAccount.Annotations, serviceAccount.Secrets); err != nil {
+				return err
+			}
+
+			return serviceAccountApplier.Apply(groupContext("ServiceAccount", serviceAccount.Name), serviceAccount)
+		}
+
+		roleBindingApplier := newRoleBindingApplier(kubeClient, roleBindingLister, forbidden, namespace)
+		applyRoleBinding := func(roleBinding *rbacv1.RoleBinding) error {
+			if _, err := setSpecHash(roleBinding, roleBinding.Labels, roleBinding.Annotations, roleBinding.RoleRef, roleBinding.Subjects); err != nil {
+				return err
+			}
+
+			return roleBindingApplier.Apply(groupContext("RoleBinding", roleBinding.Name), roleBinding)
+		}
+
+		secretApplier := newSecretApplier(kubeClient, secretsLister, storageSecretLister, forbidden, namespace)
+		applySecret := func(secret *corev1.Secret) error {
+			// The owner references are only hashed when set, so that the
+			// other secrets are not updated
+			fields := []interface{}{secret.Labels, secret.Annotations, secret.Data}
+			if len(secret.OwnerReferences) > 0 {
+				fields = append(fields, secret.OwnerReferences)
+			}
+			if _, err := setSpecHash(secret, fields...); err != nil {
+				return err
+			}
+
+			return secretApplier.Apply(groupContext("Secret", secret.Name), secret)
+		}
+
+		// Reconcile the storage secrets on their own, so that artifact
+		// storage works even when the namespace admins cannot be read.
+		// Namespaces bringing their own artifact configuration get none,
+		// and any generated earlier is pruned.
+		keepStorageSecrets := map[string]bool{}
+		namespaceStorageSecrets := storageSecrets
+		if !wantsStorageSecrets(namespace) {
+			namespaceStorageSecrets = nil
+		}
+		for _, spec := range namespaceStorageSecrets {
+			storageSecret, err := generateStorageSecret(ctx, spec, namespace)
+			if err != nil {
+				return reconcile.Wrap(reconcile.PhaseGenerate, "Secret", err)
+			}
+			if err := applySecret(storageSecret); err != nil {
+				return reconcile.Wrap(reconcile.PhaseApply, "Secret", err)
+			}
+			keepStorageSecrets[spec.name] = true
+		}
+		if err := pruneStorageSecrets(ctx, kubeClient, secretsLister, forbidden, namespace, keepStorageSecrets); err != nil {
+			return reconcile.Wrap(reconcile.PhaseCleanup, "Secret", err)
+		}
+		if finalizersErr != nil {
+			return finalizersErr
+		}
+
+		// Generate SA
+		serviceAccounts, err := generateServiceAccounts(namespace, roleBindingLister, annotations)
+		if err != nil {
+			return reconcile.Wrap(reconcile.PhaseGenerate, "ServiceAccount", err)
+		}
+
+		// Generate RBAC
+		roleBindings, err := generateRoleBindings(namespace, roleBindingLister)
+		if err != nil {
+			return reconcile.Wrap(reconcile.PhaseGenerate, "RoleBinding", err)
+		}
 
-				return nil
+		// Generate Secrets
+		secrets, err := generateTokenSecrets(namespace, roleBindingLister)
+		if err != nil {
+			return reconcile.Wrap(reconcile.PhaseGenerate, "Secret", err)
+		}
+
+		groups, found, err := uiAccessGroups(namespace, roleBindingLister)
+		if err != nil {
+			return reconcile.Wrap(reconcile.PhaseGenerate, "RoleBinding", err)
+		}
+		for _, group := range groups {
+			names, err := namesForGroup(namespace.Name, group)
+			if err != nil {
+				return reconcile.Wrap(reconcile.PhaseGenerate, "ServiceAccount", err)
+			}
+			objectGroups["ServiceAccount/"+names.serviceAccount] = group
+			objectGroups["Secret/"+names.secret] = group
+			for _, roleBinding := range names.roleBindings {
+				objectGroups["RoleBinding/"+roleBinding] = group
+			}
+		}
+
+		// The role bindings and token secrets refer to the service
+		// accounts, which must therefore exist first; objects of the same
+		// kind have distinct names and are applied in parallel.
+		if err := runPhases([]applyPhase{
+			{
+				kind:  "ServiceAccount",
+				count: len(serviceAccounts),
+				apply: func(i int) error {
+					return reconcile.Wrap(reconcile.PhaseApply, "ServiceAccount", applyServiceAccount(serviceAccounts[i]))
+				},
 			},
-		)
+			{
+				kind:      "RoleBinding",
+				dependsOn: []string{"ServiceAccount"},
+				count:     len(roleBindings),
+				apply: func(i int) error {
+					return reconcile.Wrap(reconcile.PhaseApply, "RoleBinding", applyRoleBinding(roleBindings[i]))
+				},
+			},
+			{
+				kind:      "Secret",
+				dependsOn: []string{"ServiceAccount"},
+				count:     len(secrets),
+				apply: func(i int) error {
+					if tokenSecretOwnerReferences {
+						appliedServiceAccounts.setOwner(secrets[i])
+					}
+					return reconcile.Wrap(reconcile.PhaseApply, "Secret", applySecret(secrets[i]))
+				},
+			},
+		}, perNamespaceConcurrency); err != nil {
+			return err
+		}
 
-		serviceAccountsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-			UpdateFunc: func(old, new interface{}) {
-				newNP := new.(*corev1.ServiceAccount)
-				oldNP := old.(*corev1.ServiceAccount)
+		// Report, and with --migrate-on-name-change delete, the objects
+		// left behind under names no longer generated. Without a namespace
+		// admins role binding nothing is generated, and they are deleted
+		// with --prune-on-admin-rb-removal instead.
+		stale, err := findStaleObjects(namespace, staleListers{
+			serviceAccounts: serviceAccountsLister,
+			roleBindings:    roleBindingLister,
+			secrets:         secretsLister,
+		}, serviceAccounts, roleBindings, secrets)
+		if err != nil {
+			return reconcile.Wrap(reconcile.PhaseCleanup, "ServiceAccount", err)
+		}
+		if err := reconcileStaleObjects(ctx, kubeClient, forbidden, namespace, stale, found); err != nil {
+			return reconcile.Wrap(reconcile.PhaseCleanup, "ServiceAccount", err)
+		}
 
-				if newNP.ResourceVersion == oldNP.ResourceVersion {
-					return
+		// Record the number of groups with user interface access, or why
+		// there are none
+		switch {
+		case !found:
+			skipNamespace(namespace, metrics.SkipNoAdminsRoleBinding)
+		case !uiAccessEnabled(namespace):
+			skipNamespace(namespace, metrics.SkipUIAccessDisabled)
+		default:
+			metrics.SetProvisionedGroups(namespace.Name, len(groups))
+		}
+
+		// Notify the webhook of what changed. Nothing is persisted under
+		// --server-dry-run or while frozen, so there is nothing to notify.
+		if payload, changed := changes.payload(namespace.Name, groups); changed && !writesDisabled() {
+			webhook.notify(payload)
+		}
+
+		return nil
+	}
+
+	// Setup controller
+	var controller *namespaces.Controller
+	if watchNamespace != "" {
+		klog.Infof("watching namespace %s only", watchNamespace)
+		controller = namespaces.NewNamespacedController("workflows", watchNamespace, time.Minute*5, sync)
+	} else {
+		controller = namespaces.NewController("workflows", kubeInformerFactory.Core().V1().Namespaces(), sync)
+	}
+	controller.SetRequeueAfterSuccess(requeueAfterSuccess)
+	controller.SetResyncJitter(resyncJitter)
+
+	serviceAccountsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			// Periodic resyncs deliver the same version of the object
+			if !resourceVersionChanged(old, new) {
+				return
+			}
+
+			controller.HandleObject(new)
+		},
+		DeleteFunc: controller.HandleObject,
+	})
+
+	roleBindingInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			// Periodic resyncs deliver the same version of the object
+			if !resourceVersionChanged(old, new) {
+				return
+			}
+
+			controller.HandleObject(new)
+		},
+		DeleteFunc: controller.HandleObject,
+	})
+
+	// The namespace-admins role bindings are not owned by the namespace,
+	// so reconcile their namespace directly whenever they change. This way
+	// adding or removing a group takes effect without waiting for a resync.
+	roleBindingInformer.Informer().AddEventHandler(namespaceAdminsRoleBindingHandler(controller))
+
+	secretHandler := cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			// Periodic resyncs deliver the same version of the object
+			if !resourceVersionChanged(old, new) {
+				return
+			}
+
+			controller.HandleObject(new)
+		},
+		DeleteFunc: controller.HandleObject,
+	}
+	secretsInformer.Informer().AddEventHandler(secretHandler)
+	if storageSecretInformer != nil {
+		storageSecretInformer.Informer().AddEventHandler(secretHandler)
+	}
+
+	// Reconcile every namespace when the allowlist changes, so additions
+	// and removals take effect without a restart
+	if allowlist != nil {
+		go allowlist.watch(stopCh, controller.EnqueueAll)
+	}
+
+	// Make the changes held back while frozen once unfrozen
+	go enqueueAllOnThaw(stopCh, controller.EnqueueAll)
+
+	// Reload the file-based configuration on SIGHUP, and reconcile every
+	// namespace so that the change takes effect everywhere
+	go func() {
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-reloadCh:
+				klog.Info("reloading configuration")
+				if allowlist != nil {
+					allowlist.reload()
 				}
+				controller.EnqueueAll()
+			}
+		}
+	}()
+
+	synced := []cache.InformerSynced{
+		serviceAccountsInformer.Informer().HasSynced,
+		roleBindingInformer.Informer().HasSynced,
+		secretsInformer.Informer().HasSynced,
+	}
+	debug.RegisterInformer("serviceaccounts", serviceAccountsInformer.Informer().HasSynced)
+	debug.RegisterInformer("rolebindings", roleBindingInformer.Informer().HasSynced)
+	debug.RegisterInformer("secrets", secretsInformer.Informer().HasSynced)
+	if watchNamespace == "" {
+		// The controller waits for the namespace informer itself, but
+		// without a timeout
+		namespacesSynced := kubeInformerFactory.Core().V1().Namespaces().Informer().HasSynced
+		synced = append(synced, namespacesSynced)
+		debug.RegisterInformer("namespaces", namespacesSynced)
+	}
+	if secretsFactory != nil {
+		secretsFactory.Start(stopCh)
+	}
+	if storageSecretFactory != nil {
+		storageSecretFactory.Start(stopCh)
+		synced = append(synced, storageSecretInformer.Informer().HasSynced)
+		debug.RegisterInformer("storage-secret", storageSecretInformer.Informer().HasSynced)
+	}
 
-				controller.HandleObject(new)
+	// The referenced cluster roles are not owned by any namespace, and any
+	// namespace may refer to them, so reconcile every namespace when they
+	// change.
+	if clusterRoleInformer != nil {
+		clusterRoleInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+			FilterFunc: func(obj interface{}) bool {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				clusterRole, ok := obj.(*rbacv1.ClusterRole)
+				return ok && referencedClusterRole(clusterRole.Name)
+			},
+			Handler: cache.ResourceEventHandlerFuncs{
+				AddFunc: func(obj interface{}) {
+					controller.EnqueueAll()
+				},
+				UpdateFunc: func(old, new interface{}) {
+					// Periodic resyncs deliver the same version of the object
+					if !resourceVersionChanged(old, new) {
+						return
+					}
+
+					controller.EnqueueAll()
+				},
+				DeleteFunc: func(obj interface{}) {
+					controller.EnqueueAll()
+				},
 			},
-			DeleteFunc: controller.HandleObject,
 		})
 
-		roleBindingInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-			UpdateFunc: func(old, new interface{}) {
-				newNP := new.(*rbacv1.RoleBinding)
-				oldNP := old.(*rbacv1.RoleBinding)
+		synced = append(synced, clusterRoleInformer.Informer().HasSynced)
+		debug.RegisterInformer("clusterroles", clusterRoleInformer.Informer().HasSynced)
+	}
 
-				if newNP.ResourceVersion == oldNP.ResourceVersion {
+	if credentialsInformer != nil {
+		// Copy rotated storage credentials into every namespace. Any
+		// change may change which secret is used, so all are handled.
+		credentialsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				controller.EnqueueAll()
+			},
+			UpdateFunc: func(old, new interface{}) {
+				if !resourceVersionChanged(old, new) {
 					return
 				}
 
-				controller.HandleObject(new)
+				klog.Infof("storage credentials secret %s/%s changed", secretProviderNamespace, new.(*corev1.Secret).Name)
+				controller.EnqueueAll()
+			},
+			DeleteFunc: func(obj interface{}) {
+				controller.EnqueueAll()
 			},
-			DeleteFunc: controller.HandleObject,
 		})
 
-		secretsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-			UpdateFunc: func(old, new interface{}) {
-				newNP := new.(*corev1.Secret)
-				oldNP := old.(*corev1.Secret)
+		credentialsFactory.Start(stopCh)
+		synced = append(synced, credentialsInformer.Informer().HasSynced)
+		debug.RegisterInformer("storage-credentials", credentialsInformer.Informer().HasSynced)
+	}
+
+	if annotations != nil {
+		// Annotate the service accounts of every namespace with the
+		// changed group metadata
+		annotationsSynced := annotations.watch(kubeClient, stopCh, controller.EnqueueAll)
+		synced = append(synced, annotationsSynced)
+		debug.RegisterInformer("group-annotations", annotationsSynced)
+	}
+
+	return controller, synced
+}
 
-				if newNP.ResourceVersion == oldNP.ResourceVersion {
+// namespaceAdminsRoleBindingHandler returns the event handler enqueueing the
+// namespace of a namespace-admins role binding whenever it changes.
+func namespaceAdminsRoleBindingHandler(controller *namespaces.Controller) cache.ResourceEventHandler {
+	return cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			roleBinding, ok := obj.(*rbacv1.RoleBinding)
+			return ok && isNamespaceAdminsRoleBinding(roleBinding)
+		},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: controller.EnqueueObjectNamespace,
+			UpdateFunc: func(old, new interface{}) {
+				// Periodic resyncs deliver the same version of the object
+				if !resourceVersionChanged(old, new) {
 					return
 				}
 
-				controller.HandleObject(new)
+				controller.EnqueueObjectNamespace(new)
 			},
-			DeleteFunc: controller.HandleObject,
-		})
+			DeleteFunc: controller.EnqueueObjectNamespace,
+		},
+	}
+}
 
-		// Start informers
-		kubeInformerFactory.Start(stopCh)
+// skipNamespace records that a reconcile did not give a namespace user
+// interface access, logging the reason and counting it in metrics. The
+// provisioned groups series of the namespace is removed.
+func skipNamespace(namespace *corev1.Namespace, reason metrics.SkipReason) {
+	klog.V(2).Infof("skipping namespace %s: %s", namespace.Name, reason)
+	metrics.NamespacesSkipped.Inc(string(reason))
+	metrics.DeleteProvisionedGroups(namespace.Name)
+}
 
-		// Wait for caches
-		klog.Info("Waiting for informer caches to sync")
-		if ok := cache.WaitForCacheSync(stopCh, serviceAccountsInformer.Informer().HasSynced, roleBindingInformer.Informer().HasSynced, secretsInformer.Informer().HasSynced); !ok {
-			klog.Fatalf("failed to wait for caches to sync")
+// referencedClusterRole reports whether the generated role bindings
+// reference the cluster role of the given name.
+func referencedClusterRole(name string) bool {
+	if name == workflowsCR {
+		return true
+	}
+	for _, clusterRole := range userInterfaceCRs {
+		if name == clusterRole {
+			return true
 		}
+	}
 
-		// Run the controller
-		if err = controller.Run(2, stopCh); err != nil {
-			klog.Fatalf("error running controller: %v", err)
+	return false
+}
+
+// missingClusterRoles returns the names of the cluster roles referenced by
+// the generated role bindings which do not exist.
+func missingClusterRoles(clusterRoleLister rbacv1listers.ClusterRoleLister) []string {
+	missing := []string{}
+	for _, name := range append(append([]string{}, userInterfaceCRs...), workflowsCR) {
+		if _, err := clusterRoleLister.Get(name); errors.IsNotFound(err) {
+			missing = append(missing, name)
 		}
-	},
+	}
+
+	return missing
 }
 
-// generateServiceAccounts generates service accounts for argo workflows.
-func generateServiceAccounts(namespace *corev1.Namespace, roleBindingLister rbacv1listers.RoleBindingLister) ([]*corev1.ServiceAccount, error) {
+// generateServiceAccounts generates service accounts for argo workflows. The
+// service account of each group is given its annotations, if any.
+func generateServiceAccounts(namespace *corev1.Namespace, roleBindingLister rbacv1listers.RoleBindingLister, annotations *groupAnnotations) ([]*corev1.ServiceAccount, error) {
 	serviceAccounts := []*corev1.ServiceAccount{}
 
 	if namespace.Name == "argo-workflows-system" {
@@ -221,42 +977,55 @@ func generateServiceAccounts(namespace *corev1.Namespace, roleBindingLister rbac
 	}
 
 	// Find groups in namespace-admins rolebindings
-	roleBinding, err := roleBindingLister.RoleBindings(namespace.Name).Get(namespaceAdminsRB)
+	groups, found, err := uiAccessGroups(namespace, roleBindingLister)
 	if err != nil {
-		if errors.IsNotFound(err) {
-			return []*corev1.ServiceAccount{}, nil
-		}
-
 		return nil, err
 	}
+	if !found {
+		return []*corev1.ServiceAccount{}, nil
+	}
 
 	// The service account that the workflow pods will be attached to
 	serviceAccounts = append(serviceAccounts, &corev1.ServiceAccount{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "ServiceAccount",
+		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "argo-workflows",
-			Namespace: namespace.Name,
+			Name:        "argo-workflows",
+			Namespace:   namespace.Name,
+			Labels:      mergeMaps(commonLabels, workloadIdentityLabels(), managedByLabels),
+			Annotations: mergeMaps(commonAnnotations, workflowServiceAccountAnnotations, workloadIdentityAnnotations()),
 		},
 	})
 
 	// The service accounts of type group used for user interface access
-	for _, subject := range roleBinding.Subjects {
-		if subject.Kind == "Group" {
-			serviceAccounts = append(serviceAccounts, &corev1.ServiceAccount{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      fmt.Sprintf("argo-workflows-%v", subject.Name),
-					Namespace: namespace.Name,
-					Annotations: map[string]string{
-						"workflows.argoproj.io/rbac-rule":            fmt.Sprintf("'%s' in groups", subject.Name),
-						"workflows.argoproj.io/rbac-rule-precedence": "1",
-					},
-				},
-				Secrets: []corev1.ObjectReference{
-					{
-						Name: fmt.Sprintf("argo-workflows-%v", subject.Name),
-					},
-				},
-			})
+	for _, group := range groups {
+		names, err := namesForGroup(namespace.Name, group)
+		if err != nil {
+			return nil, err
 		}
+
+		serviceAccounts = append(serviceAccounts, &corev1.ServiceAccount{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: corev1.SchemeGroupVersion.String(),
+				Kind:       "ServiceAccount",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      names.serviceAccount,
+				Namespace: namespace.Name,
+				Labels:    mergeMaps(commonLabels, managedByLabels),
+				Annotations: mergeMaps(commonAnnotations, annotations.forGroup(group), map[string]string{
+					"workflows.argoproj.io/rbac-rule":            fmt.Sprintf("'%s' in groups", group),
+					"workflows.argoproj.io/rbac-rule-precedence": strconv.Itoa(groupPrecedence(group)),
+				}),
+			},
+			Secrets: []corev1.ObjectReference{
+				{
+					Name: names.secret,
+				},
+			},
+		})
 	}
 
 	return serviceAccounts, nil
@@ -267,33 +1036,56 @@ func generateRoleBindings(namespace *corev1.Namespace, roleBindingLister rbacv1l
 	roleBindings := []*rbacv1.RoleBinding{}
 
 	// Find groups in the namespace admins
-	roleBinding, err := roleBindingLister.RoleBindings(namespace.Name).Get(namespaceAdminsRB)
+	groups, found, err := uiAccessGroups(namespace, roleBindingLister)
 	if err != nil {
-		if errors.IsNotFound(err) {
-			return []*rbacv1.RoleBinding{}, nil
-		}
-
 		return nil, err
 	}
+	if !found {
+		return []*rbacv1.RoleBinding{}, nil
+	}
+
+	// The role bindings to further roles add the role to the name of the
+	// group, which another group may render as well, such as group team
+	// with role submitter and group team-submitter. Two groups sharing a
+	// role binding would overwrite each other's subjects, so the reconcile
+	// fails instead.
+	groupsByName := map[string]string{}
+
+	// Loop over all admin groups and bind the UI service accounts to each of
+	// the user interface roles, one role binding per role.
+	for _, group := range groups {
+		names, err := namesForGroup(namespace.Name, group)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, clusterRole := range userInterfaceCRs {
+			if other, ok := groupsByName[names.roleBindings[i]]; ok {
+				return nil, fmt.Errorf("%w: role binding %q is generated for both group %q and group %q in namespace %q", reconcile.ErrInvalidName, names.roleBindings[i], other, group, namespace.Name)
+			}
+			groupsByName[names.roleBindings[i]] = group
 
-	// Loop over all admin groups and bind the UI service accounts to the argo-workflows-namespace role.
-	for _, subject := range roleBinding.Subjects {
-		if subject.Kind == "Group" {
 			roleBindings = append(roleBindings, &rbacv1.RoleBinding{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: rbacv1.SchemeGroupVersion.String(),
+					Kind:       "RoleBinding",
+				},
 				ObjectMeta: metav1.ObjectMeta{
-					Name:      fmt.Sprintf("argo-workflows-%v", subject.Name),
-					Namespace: namespace.Name,
+					Name:        names.roleBindings[i],
+					Namespace:   namespace.Name,
+					Labels:      mergeMaps(commonLabels, managedByLabels),
+					Annotations: mergeMaps(commonAnnotations, roleBindingAnnotations),
 				},
 				RoleRef: rbacv1.RoleRef{
 					APIGroup: rbacv1.SchemeGroupVersion.Group,
-					Kind:     "ClusterRole",
-					Name:     argoUserInterfaceCR,
+					Kind:     roleRefKind,
+					Name:     clusterRole,
 				},
 				Subjects: []rbacv1.Subject{
 					{
 						APIGroup:  "",
 						Kind:      "ServiceAccount",
-						Name:      fmt.Sprintf("argo-workflows-%v", subject.Name),
+						Name:      names.serviceAccount,
 						Namespace: namespace.Name,
 					},
 				},
@@ -302,14 +1094,23 @@ func generateRoleBindings(namespace *corev1.Namespace, roleBindingLister rbacv1l
 	}
 
 	// Role binding for Argo Workflows
+	if group, ok := groupsByName["argo-workflows"]; ok {
+		return nil, fmt.Errorf("%w: role binding %q of Argo Workflows is generated for group %q in namespace %q as well", reconcile.ErrInvalidName, "argo-workflows", group, namespace.Name)
+	}
 	roleBindings = append(roleBindings, &rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       "RoleBinding",
+		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "argo-workflows",
-			Namespace: namespace.Name,
+			Name:        "argo-workflows",
+			Namespace:   namespace.Name,
+			Labels:      mergeMaps(commonLabels, managedByLabels),
+			Annotations: mergeMaps(commonAnnotations, roleBindingAnnotations),
 		},
 		RoleRef: rbacv1.RoleRef{
 			APIGroup: rbacv1.SchemeGroupVersion.Group,
-			Kind:     "ClusterRole",
+			Kind:     roleRefKind,
 			Name:     workflowsCR,
 		},
 		Subjects: []rbacv1.Subject{
@@ -325,62 +1126,397 @@ func generateRoleBindings(namespace *corev1.Namespace, roleBindingLister rbacv1l
 	return roleBindings, nil
 }
 
-// generateSecrets generates secrets for argo workflows.
-func generateSecrets(namespace *corev1.Namespace, roleBindingLister rbacv1listers.RoleBindingLister) ([]*corev1.Secret, error) {
+// generateTokenSecrets generates the service account token secrets of the
+// user interface service accounts. The storage secret is generated on its own
+// by generateStorageSecret.
+func generateTokenSecrets(namespace *corev1.Namespace, roleBindingLister rbacv1listers.RoleBindingLister) ([]*corev1.Secret, error) {
 	secrets := []*corev1.Secret{}
 
+	// Find groups in namespace-admins rolebindings
+	groups, found, err := uiAccessGroups(namespace, roleBindingLister)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return secrets, nil
+	}
+
+	for _, group := range groups {
+		names, err := namesForGroup(namespace.Name, group)
+		if err != nil {
+			return nil, err
+		}
+
+		secrets = append(secrets, &corev1.Secret{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: corev1.SchemeGroupVersion.String(),
+				Kind:       "Secret",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      names.secret,
+				Namespace: namespace.Name,
+				Labels:    mergeMaps(commonLabels, secretLabels, managedByLabels),
+				Annotations: mergeMaps(commonAnnotations, tokenSecretAnnotations, map[string]string{
+					"kubernetes.io/service-account.name": names.serviceAccount,
+				}),
+			},
+			Type: corev1.SecretTypeServiceAccountToken,
+		})
+	}
+
+	return secrets, nil
+}
+
+// generateStorageSecret generates a secret holding the storage account
+// credentials, as described by spec.
+func generateStorageSecret(ctx context.Context, spec storageSecretSpec, namespace *corev1.Namespace) (*corev1.Secret, error) {
+	data, err := spec.provider.StorageSecretData(ctx, namespace.Name)
+	if err != nil {
+		return nil, err
+	}
+
 	secret := &corev1.Secret{
 		TypeMeta: metav1.TypeMeta{
-			APIVersion: "core/v1",
+			APIVersion: corev1.SchemeGroupVersion.String(),
 			Kind:       "Secret",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      os.Getenv("ARGO_SECRET_NAME"),
-			Namespace: namespace.Name,
-		},
-		Type: corev1.SecretTypeOpaque,
-		Data: map[string][]byte{
-			"root-user":     []byte(os.Getenv("ARGO_STORAGE_ACCOUNT_NAME")),
-			"root-password": []byte(os.Getenv("ARGO_STORAGE_ACCOUNT_KEY")),
+			Name:        spec.name,
+			Namespace:   namespace.Name,
+			Labels:      mergeMaps(commonLabels, secretLabels, managedByLabels, map[string]string{storageSecretLabel: "true"}),
+			Annotations: mergeMaps(commonAnnotations),
 		},
+		Type: spec.secretType,
+		Data: data,
+	}
+
+	// Additional keys, such as the endpoint or bucket of the artifact repository
+	if !spec.extra {
+		return secret, nil
+	}
+	for key, value := range storageSecretExtra {
+		secret.Data[key] = []byte(resolveSecretValue(value))
 	}
 
-	secrets = append(secrets, secret)
+	return secret, nil
+}
 
-	// Find groups in namespace-admins rolebindings
-	roleBinding, err := roleBindingLister.RoleBindings(namespace.Name).Get(namespaceAdminsRB)
+// tokenSecretExpired reports whether secret is a service account token secret
+// older than --token-secret-max-age.
+func tokenSecretExpired(secret *corev1.Secret) bool {
+	if tokenSecretMaxAge <= 0 || secret.Type != corev1.SecretTypeServiceAccountToken {
+		return false
+	}
+
+	return time.Since(secret.CreationTimestamp.Time) > tokenSecretMaxAge
+}
+
+// resolveSecretValue returns the value of a secret entry supplied on the
+// command line. Values of the form env:VARIABLE are read from the environment.
+func resolveSecretValue(value string) string {
+	if strings.HasPrefix(value, "env:") {
+		return os.Getenv(strings.TrimPrefix(value, "env:"))
+	}
+
+	return value
+}
+
+// groupPrecedence returns the Argo Server rbac-rule precedence of a group:
+// its --group-precedence override, else the precedence of its tier, else
+// --rbac-rule-precedence.
+func groupPrecedence(group string) int {
+	if precedence, ok := groupPrecedences[group]; ok {
+		return precedence
+	}
+	if tier, ok := groupTier(group); ok {
+		return groupTierPrecedences[tier]
+	}
+
+	return rbacRulePrecedence
+}
+
+// groupTier returns the tier of a group, given by the suffix of its name: a
+// group named team-a-platform is in the platform tier. When several tiers
+// match, such as readonly and team-readonly, the longest wins.
+func groupTier(group string) (string, bool) {
+	tier := ""
+	for candidate := range groupTierPrecedences {
+		if strings.HasSuffix(group, "-"+candidate) && len(candidate) > len(tier) {
+			tier = candidate
+		}
+	}
+
+	return tier, tier != ""
+}
+
+// isNamespaceAdminsRoleBinding reports whether the role binding lists the
+// namespace admins, either because it has the exact name given by
+// --namespace-admins-role-binding-name, because its name matches
+// --namespace-admins-role-binding-pattern or because it binds the Role named
+// by --admin-role-name.
+func isNamespaceAdminsRoleBinding(roleBinding *rbacv1.RoleBinding) bool {
+	if namespaceAdminsRB != "" && roleBinding.Name == namespaceAdminsRB {
+		return true
+	}
+	if adminRoleName != "" && roleBinding.RoleRef.Kind == "Role" && roleBinding.RoleRef.Name == adminRoleName {
+		return true
+	}
+
+	return namespaceAdminsRBRegexp != nil && namespaceAdminsRBRegexp.MatchString(roleBinding.Name)
+}
+
+// uiAccessGroups returns the admin groups which are given user interface
+// access in the namespace, followed by any --default-ui-groups they do not
+// already include. It returns no groups, but still reports whether the
+// namespace admins role binding was found, when user interface access is
+// disabled for the namespace; the runner service account and role binding are
+// then provisioned without any per-group service accounts. Namespaces without
+// a namespace admins role binding are provisioned for the default groups
+// alone, and reported as found.
+func uiAccessGroups(namespace *corev1.Namespace, roleBindingLister rbacv1listers.RoleBindingLister) (groups []string, found bool, err error) {
+	groups, found, err = adminGroups(namespace, roleBindingLister)
 	if err != nil {
-		if errors.IsNotFound(err) {
-			return secrets, nil
+		return nil, false, err
+	}
+
+	if !uiAccessEnabled(namespace) {
+		if !found {
+			return nil, false, nil
+		}
+		return []string{}, true, nil
+	}
+
+	groups = withDefaultUIGroups(groups)
+	if !found && len(groups) == 0 {
+		return nil, false, nil
+	}
+
+	return groups, true, nil
+}
+
+// withDefaultUIGroups returns groups followed by the --default-ui-groups not
+// already among them.
+func withDefaultUIGroups(groups []string) []string {
+	seen := map[string]bool{}
+	for _, group := range groups {
+		seen[group] = true
+	}
+
+	merged := append([]string{}, groups...)
+	for _, group := range defaultUIGroups {
+		if !seen[group] {
+			merged = append(merged, group)
+			seen[group] = true
+		}
+	}
+
+	return merged
+}
+
+// uiAccessEnabled reports whether groups are given user interface access in
+// the namespace. The ui-access annotation of the namespace, when set to
+// "enabled" or "disabled", takes precedence over --disable-ui-access.
+func uiAccessEnabled(namespace *corev1.Namespace) bool {
+	switch namespace.Annotations[uiAccessAnnotation] {
+	case "enabled":
+		return true
+	case "disabled":
+		return false
+	default:
+		return !disableUIAccess
+	}
+}
+
+// adminGroups returns the groups bound by the namespace admins role binding
+// of the namespace. found is false if the namespace has no such role binding.
+func adminGroups(namespace *corev1.Namespace, roleBindingLister rbacv1listers.RoleBindingLister) (groups []string, found bool, err error) {
+	roleBindings, err := namespaceAdminsRoleBindings(namespace, roleBindingLister)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Role bindings being deleted no longer grant access
+	live := []*rbacv1.RoleBinding{}
+	for _, roleBinding := range roleBindings {
+		if roleBinding.DeletionTimestamp == nil {
+			live = append(live, roleBinding)
+		}
+	}
+	if len(live) == 0 {
+		return nil, false, nil
+	}
+
+	return subjectGroups(live), true, nil
+}
+
+// subjectGroups returns the unique groups bound by the role bindings, leaving
+// out the groups excluded by the exclude-groups annotation of each role
+// binding.
+func subjectGroups(roleBindings []*rbacv1.RoleBinding) []string {
+	subjects := []rbacv1.Subject{}
+	for _, roleBinding := range roleBindings {
+		excluded := excludedGroups(roleBinding)
+		for _, subject := range roleBinding.Subjects {
+			if subject.Kind == "Group" && excluded[subject.Name] {
+				continue
+			}
+			subjects = append(subjects, subject)
 		}
-		return nil, err
 	}
 
-	for _, subject := range roleBinding.Subjects {
+	groups := []string{}
+	for _, subject := range uniqueSubjects(subjects) {
 		if subject.Kind == "Group" {
-			secrets = append(secrets, &corev1.Secret{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      fmt.Sprintf("argo-workflows-%v", subject.Name),
-					Namespace: namespace.Name,
-					Annotations: map[string]string{
-						"kubernetes.io/service-account.name": fmt.Sprintf("argo-workflows-%v", subject.Name),
-					},
-				},
-				Type: corev1.SecretTypeServiceAccountToken,
-			})
+			groups = append(groups, subject.Name)
 		}
 	}
 
-	return secrets, nil
+	return groups
+}
+
+// excludedGroups returns the groups listed in the exclude-groups annotation
+// of a role binding.
+func excludedGroups(roleBinding *rbacv1.RoleBinding) map[string]bool {
+	excluded := map[string]bool{}
+	for _, group := range strings.Split(roleBinding.Annotations[excludeGroupsAnnotation], ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			excluded[group] = true
+		}
+	}
+
+	return excluded
+}
+
+// namespaceAdminsRoleBindings returns the role bindings listing the admins of
+// the namespace, sorted by name. Without a pattern or admin role only the
+// exactly named role binding is read; otherwise every role binding in the
+// namespace is scanned and the exactly named one, if any, is included as well.
+func namespaceAdminsRoleBindings(namespace *corev1.Namespace, roleBindingLister rbacv1listers.RoleBindingLister) ([]*rbacv1.RoleBinding, error) {
+	if namespaceAdminsRBRegexp == nil && adminRoleName == "" {
+		roleBinding, err := roleBindingLister.RoleBindings(namespace.Name).Get(namespaceAdminsRB)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return []*rbacv1.RoleBinding{}, nil
+			}
+
+			return nil, err
+		}
+
+		return []*rbacv1.RoleBinding{roleBinding}, nil
+	}
+
+	all, err := roleBindingLister.RoleBindings(namespace.Name).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	roleBindings := []*rbacv1.RoleBinding{}
+	for _, roleBinding := range all {
+		if isNamespaceAdminsRoleBinding(roleBinding) {
+			roleBindings = append(roleBindings, roleBinding)
+		}
+	}
+
+	// Listers return objects in no particular order
+	sort.Slice(roleBindings, func(i, j int) bool {
+		return roleBindings[i].Name < roleBindings[j].Name
+	})
+
+	return roleBindings, nil
+}
+
+// uniqueSubjects returns the subjects with duplicate (Kind, Name) pairs removed,
+// preserving the order in which they first appear.
+func uniqueSubjects(subjects []rbacv1.Subject) []rbacv1.Subject {
+	type subjectKey struct {
+		kind string
+		name string
+	}
+
+	seen := map[subjectKey]bool{}
+	unique := []rbacv1.Subject{}
+	for _, subject := range subjects {
+		key := subjectKey{kind: subject.Kind, name: subject.Name}
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		unique = append(unique, subject)
+	}
+
+	return unique
 }
 
 func init() {
 	rootCmd.AddCommand(workflowsCmd)
-	workflowsCmd.Flags().StringVar(&namespaceAdminsRB, "namespace-admins-role-binding-name", "", "The name of the role binding that specifies the namespace admins as subjects.")
-	workflowsCmd.Flags().StringVar(&argoUserInterfaceCR, "user-interface-cluster-role-name", "", "The name of the cluster role used for Argo Workflow interface access")
-	workflowsCmd.Flags().StringVar(&workflowsCR, "argo-workflows-cluster-role-name", "", "The name of the role binding that specifies the namespace admins")
+	addWorkflowsFlags(workflowsCmd.Flags())
 
-	workflowsCmd.MarkFlagRequired("namespace-admins-role-binding-name")
-	workflowsCmd.MarkFlagRequired("user-interface-cluster-role-name")
 	workflowsCmd.MarkFlagRequired("argo-workflows-cluster-role-name")
 }
+
+// addWorkflowsFlags registers the flags of the workflows controller.
+func addWorkflowsFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&namespaceAdminsRB, "namespace-admins-role-binding-name", "", "The name of the role binding that specifies the namespace admins as subjects.")
+	flags.StringVar(&namespaceAdminsRBPattern, "namespace-admins-role-binding-pattern", "", "Regular expression matching the names of the role bindings that specify the namespace admins. Must match the whole name. Subjects of every matching role binding, and of the role binding named by --namespace-admins-role-binding-name if set, are combined.")
+	flags.StringVar(&adminRoleName, "admin-role-name", "", "The name of a namespaced Role granted to the namespace admins. Subjects of every role binding to this Role are combined with those of the namespace admins role bindings found by name or pattern.")
+	flags.StringVar(&namespaceAllowlistFile, "namespace-allowlist-file", "", "Path to a file listing the namespaces to reconcile, one per line. The file is reloaded when it changes. A missing or empty file allows every namespace.")
+	flags.BoolVar(&skipSelfNamespace, "skip-self-namespace", true, "Do not reconcile the namespace the controller runs in, read from the POD_NAMESPACE environment variable or the mounted service account.")
+	flags.BoolVar(&useFinalizers, "use-finalizers", false, "Add a finalizer to the namespace admins role bindings, so the objects provisioned for their groups are deleted before the role binding is. Deletion of the role bindings is blocked while the controller is down.")
+	flags.StringVar(&webhookURL, "webhook-url", "", "URL to POST a JSON notification to after every reconcile which changed resources. Delivery is best-effort.")
+	flags.IntVar(&webhookQueueSize, "webhook-queue-size", 100, "Number of webhook notifications waiting to be delivered beyond which further notifications are dropped.")
+	flags.DurationVar(&webhookTimeout, "webhook-timeout", time.Second*10, "Timeout of each webhook request.")
+	flags.IntVar(&webhookMaxRetries, "webhook-max-retries", 3, "Number of times delivery of a webhook notification is retried, with an exponential backoff capped at 30s, before it is dropped.")
+	flags.StringVar(&webhookSecret, "webhook-secret", "", "Key of the HMAC-SHA256 signature of webhook notifications, sent in the "+webhookSignatureHeader+" header. Use env:VARIABLE to read it from an environment variable.")
+	flags.BoolVar(&watchClusterRoles, "watch-cluster-roles", false, "Watch the cluster roles referenced by the generated role bindings, reconciling every namespace when they change and warning when they are missing. Requires a cluster-wide watch on cluster roles.")
+	flags.BoolVar(&disableUIAccess, "disable-ui-access", false, "Do not provision per-group user interface service accounts, only the runner service account and its role binding. Namespaces can override this with the "+uiAccessAnnotation+" annotation.")
+	flags.StringVar(&argoUserInterfaceCR, "user-interface-cluster-role-name", "", "The name of the cluster role used for Argo Workflow interface access")
+	flags.BoolVar(&waitFirstReconcile, "wait-first-reconcile", false, "Only report ready on /readyz once every namespace has been reconciled successfully, instead of as soon as the informer caches have synced.")
+	flags.StringVar(&groupAnnotationsConfigMap, "group-annotations-configmap", "", "ConfigMap (namespace/name) whose \""+groupAnnotationsKey+"\" key maps group names to annotations added to the user interface service account of each group. Changes are applied to every namespace.")
+	flags.BoolVar(&cacheManagedSecretsOnly, "cache-managed-secrets-only", false, "Only cache the secrets carrying the app.kubernetes.io/managed-by=argo-controller label, and the storage secret named by ARGO_SECRET_NAME, instead of every secret of the cluster. Other secrets the controller needs to update are read from the API server.")
+	flags.BoolVar(&tokenSecretOwnerReferences, "token-secret-owner-references", false, "Make each per-group token secret owned by its service account, so that Kubernetes garbage collects the secret when the service account is deleted.")
+	flags.StringSliceVar(&defaultUIGroups, "default-ui-groups", []string{}, "Groups given user interface access in every namespace with user interface access enabled, on top of the namespace admins, comma separated. Namespaces without a namespace admins role binding are provisioned for these groups alone.")
+	flags.StringSliceVar(&argoUserInterfaceCRNames, "user-interface-cluster-role-names", []string{}, "Additional cluster roles used for Argo Workflow interface access, comma separated. Each group gets a role binding to every cluster role, named with the cluster role as a suffix.")
+	flags.StringVar(&workflowsCR, "argo-workflows-cluster-role-name", "", "The name of the role binding that specifies the namespace admins")
+
+	flags.StringVar(&serviceAccountNameTemplate, "sa-name-template", defaultGroupNameTemplate, "Go template of the name of the user interface service account of a group, evaluated with {{.Namespace}} and {{.Group}}.")
+	flags.StringVar(&roleBindingNameTemplate, "rolebinding-name-template", defaultGroupNameTemplate, "Go template of the name of the user interface role binding of a group, evaluated with {{.Namespace}} and {{.Group}}.")
+	flags.StringVar(&secretNameTemplate, "secret-name-template", defaultGroupNameTemplate, "Go template of the name of the service account token secret of a group, evaluated with {{.Namespace}} and {{.Group}}.")
+	flags.BoolVar(&pruneOnAdminRBRemoval, "prune-on-admin-rb-removal", false, "Delete the service accounts, role bindings and token secrets managed by the controller in a namespace, including the shared argo-workflows runner objects, once it no longer has any namespace admins role binding. The storage secrets are kept.")
+	flags.BoolVar(&migrateOnNameChange, "migrate-on-name-change", false, "Delete the service accounts, role bindings and token secrets managed by the controller which are no longer generated, such as those named after an earlier name template, once their replacements are applied. Without it, they are only reported in the logs and the argo_controller_stale_objects metric.")
+
+	flags.StringVar(&storageSecretType, "storage-secret-type", string(corev1.SecretTypeOpaque), "Type of the storage secret named by ARGO_SECRET_NAME, for artifact backends expecting a typed secret such as kubernetes.io/basic-auth. The keys the type requires must be among the storage secret keys. Existing storage secrets are recreated when it changes, as the type of a secret cannot be updated.")
+	flags.StringVar(&storageSecretUserKey, "storage-secret-user-key", "root-user", "The key of the storage account name in the generated storage secret.")
+	flags.StringVar(&storageSecretPasswordKey, "storage-secret-password-key", "root-password", "The key of the storage account key in the generated storage secret.")
+	flags.BoolVar(&manageStorageSecret, "manage-storage-secret", true, "Generate the storage secret named by ARGO_SECRET_NAME in every namespace. Set to false when artifact storage is accessed without static credentials, e.g. with workload identity. Token secrets are still generated.")
+	flags.StringVar(&secretProviderName, "secret-provider", "env", "Source of the storage account credentials: env reads ARGO_STORAGE_ACCOUNT_NAME and ARGO_STORAGE_ACCOUNT_KEY, file reads files named after the storage secret keys in --secret-provider-dir, secret copies them from the secret of --secret-provider-namespace matching --secret-provider-selector.")
+	flags.StringVar(&secretProviderDir, "secret-provider-dir", "/etc/argo-controller/storage", "Directory read by the file secret provider.")
+	flags.StringArrayVar(&storageSecretSpecFlags, "storage-secret", []string{}, "Additional storage secret to generate in every namespace, as name=NAME,provider=env,user-env=VAR,password-env=VAR or name=NAME,provider=file,dir=DIR. May be repeated. Storage secrets no longer configured are deleted.")
+	flags.StringVar(&noStorageSecretSelectorFlag, "no-storage-secret-selector", "", "Label selector of the namespaces which get no storage secrets, such as those with their own artifact repository configuration. Storage secrets generated earlier in matching namespaces are deleted. The token secrets are still generated.")
+	flags.StringVar(&secretProviderNamespace, "secret-provider-namespace", "", "Namespace searched by the secret provider for the secret holding the storage account credentials.")
+	flags.StringVar(&secretProviderSelector, "secret-provider-selector", "argo-workflows.aurora/storage-credentials=true", "Label selector of the secret holding the storage account credentials, used by the secret provider. When several secrets match, the first by name is used.")
+	flags.StringToStringVar(&storageSecretExtra, "storage-secret-extra", map[string]string{}, "Additional keys to add to the generated storage secret, as key=value or key=env:VARIABLE to read the value from an environment variable.")
+	flags.StringVar(&roleRefKind, "role-ref-kind", "ClusterRole", "The kind of role referenced by the generated role bindings: ClusterRole or Role. Roles must already exist in each namespace.")
+	flags.IntVar(&rbacRulePrecedence, "rbac-rule-precedence", 1, "The Argo Server rbac-rule precedence of the generated user interface service accounts.")
+	flags.StringToStringVar(&groupPrecedenceFlag, "group-precedence", map[string]string{}, "Override the rbac-rule precedence of a group (group=precedence). May be repeated.")
+	flags.StringToStringVar(&groupTierPrecedenceFlag, "group-tier-precedence", map[string]string{}, "The rbac-rule precedence of the groups of each tier (tier=precedence), where the tier of a group is the suffix of its name after a dash. Groups of no tier use --rbac-rule-precedence.")
+	flags.BoolVar(&runOnce, "run-once", false, "Reconcile every namespace once and exit, instead of watching for changes. Exits non-zero if any namespace fails.")
+	flags.IntVar(&runOnceConcurrency, "run-once-concurrency", 1, "Number of namespaces reconciled in parallel under --run-once.")
+	flags.IntVar(&workflowWorkers, "workflow-workers", 2, "Number of namespaces reconciled in parallel by the workflows controller.")
+	flags.IntVar(&perNamespaceConcurrency, "per-namespace-concurrency", 1, "Maximum number of API writes issued in parallel while reconciling a single namespace.")
+	flags.DurationVar(&fullResyncInterval, "full-resync-interval", 0, "How often to reconcile every namespace regardless of informer events. Set to 0 to disable.")
+	flags.Float64Var(&resyncJitter, "resync-jitter", 0.1, "Fraction of --full-resync-interval over which the namespaces of a full resync are spread, and at most added at random to --requeue-after-success and the namespaced resync, so namespaces do not all reconcile at once. Set to 0 to disable.")
+	flags.DurationVar(&requeueAfterSuccess, "requeue-after-success", 0, "Reconcile a namespace again this long after it was reconciled successfully, to correct drift the informers missed. Set to 0 to disable.")
+	flags.StringToStringVar(&commonLabels, "common-labels", map[string]string{}, "Labels (key=value) to add to every generated resource.")
+	flags.StringToStringVar(&secretLabels, "secret-labels", map[string]string{}, "Labels (key=value) to add to the generated storage and token secrets, on top of --common-labels. Restored if removed.")
+	flags.StringToStringVar(&commonAnnotations, "common-annotations", map[string]string{}, "Annotations (key=value) to add to every generated resource.")
+	flags.StringToStringVar(&roleBindingAnnotations, "rolebinding-annotations", map[string]string{}, "Annotations (key=value) to add to the generated role bindings, on top of --common-annotations. Restored if removed.")
+	flags.StringToStringVar(&workflowServiceAccountAnnotations, "workflow-sa-annotations", map[string]string{}, "Annotations (key=value) to add to the shared argo-workflows service account used by workflow pods.")
+	flags.StringVar(&workloadIdentityClientID, "azure-workload-identity-client-id", "", "Client ID of the Microsoft Entra application whose identity workflow pods use through Azure Workload Identity. Sets the azure.workload.identity/client-id annotation and the azure.workload.identity/use label on the shared argo-workflows service account.")
+	flags.StringVar(&workloadIdentityTenantID, "azure-workload-identity-tenant-id", "", "Tenant ID of the --azure-workload-identity-client-id application, set as the azure.workload.identity/tenant-id annotation. Defaults to the tenant of the cluster when unset.")
+	flags.DurationVar(&tokenSecretMaxAge, "token-secret-max-age", 0, "Recreate service account token secrets older than this, forcing a fresh token. Set to 0 to disable.")
+	flags.StringToStringVar(&tokenSecretAnnotations, "token-secret-annotations", map[string]string{}, "Additional annotations (key=value) to add to the generated service account token secrets.")
+	flags.BoolVar(&adoptExisting, "adopt-existing", false, "Take over existing service accounts, role bindings and secrets with the names of generated objects but without the managed-by label, by adding the label. Otherwise they are left alone and reported with a Warning event.")
+	flags.BoolVar(&forceApply, "force-apply", false, "With --adopt-existing, take over the fields of adopted objects owned by other field managers. Otherwise an object with such fields is left alone and the conflicts are logged.")
+
+}
@@ -0,0 +1,582 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gccloudone-aurora/argo-controller/pkg/controllers/namespaces"
+	"github.com/gccloudone-aurora/argo-controller/pkg/reconcile"
+	"github.com/gccloudone-aurora/argo-controller/pkg/secretprovider"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	rbacv1informers "k8s.io/client-go/informers/rbac/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// setupWorkflowsFlags sets the required flags of the workflows controller,
+// with the given overrides applied by set, and validates them. The other
+// flags keep the defaults they were registered with.
+func setupWorkflowsFlags(t *testing.T, set func()) {
+	t.Helper()
+
+	namespaceAdminsRB = "namespace-admins"
+	namespaceAdminsRBPattern = ""
+	namespaceAdminsRBRegexp = nil
+	adminRoleName = ""
+	argoUserInterfaceCR = "argo-workflows-ui"
+	argoUserInterfaceCRNames = []string{}
+	defaultUIGroups = []string{}
+	workflowsCR = "argo-workflows"
+	disableUIAccess = false
+	if set != nil {
+		set()
+	}
+
+	if err := validateWorkflowsFlags(); err != nil {
+		t.Fatalf("validateWorkflowsFlags: %v", err)
+	}
+}
+
+func newNamespace(name string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+}
+
+func newAdminsRoleBinding(namespace string, subjects ...rbacv1.Subject) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "namespace-admins", Namespace: namespace},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "admin"},
+		Subjects:   subjects,
+	}
+}
+
+func groupSubject(name string) rbacv1.Subject {
+	return rbacv1.Subject{APIGroup: rbacv1.GroupName, Kind: "Group", Name: name}
+}
+
+func TestGenerateWithDuplicateGroupSubjects(t *testing.T) {
+	setupWorkflowsFlags(t, nil)
+
+	namespace := newNamespace("team-a")
+	lister := newRoleBindingLister(newAdminsRoleBinding("team-a",
+		groupSubject("team-a-admins"),
+		groupSubject("team-a-admins"),
+		rbacv1.Subject{Kind: "User", Name: "alice"},
+	))
+
+	serviceAccounts, err := generateServiceAccounts(namespace, lister, nil)
+	if err != nil {
+		t.Fatalf("generateServiceAccounts: %v", err)
+	}
+	// The runner service account and one for the group
+	if len(serviceAccounts) != 2 {
+		t.Errorf("got %d service accounts, want 2", len(serviceAccounts))
+	}
+
+	roleBindings, err := generateRoleBindings(namespace, lister)
+	if err != nil {
+		t.Fatalf("generateRoleBindings: %v", err)
+	}
+	// The runner role binding and one for the group
+	if len(roleBindings) != 2 {
+		t.Errorf("got %d role bindings, want 2", len(roleBindings))
+	}
+
+	secrets, err := generateTokenSecrets(namespace, lister)
+	if err != nil {
+		t.Fatalf("generateTokenSecrets: %v", err)
+	}
+	if len(secrets) != 1 {
+		t.Errorf("got %d secrets, want 1", len(secrets))
+	}
+}
+
+func TestGenerateRoleBindingsNameClash(t *testing.T) {
+	tests := []struct {
+		name    string
+		groups  []string
+		set     func()
+		wantErr bool
+	}{
+		{
+			name:   "one role",
+			groups: []string{"team", "team-submitter"},
+		},
+		{
+			name:   "distinct names",
+			groups: []string{"team", "other"},
+			set:    func() { argoUserInterfaceCRNames = []string{"submitter"} },
+		},
+		{
+			name:    "role suffix of one group names another",
+			groups:  []string{"team", "team-submitter"},
+			set:     func() { argoUserInterfaceCRNames = []string{"submitter"} },
+			wantErr: true,
+		},
+		{
+			name:    "group named after the runner role binding",
+			groups:  []string{"argo-workflows"},
+			set:     func() { roleBindingNameTemplate = "{{.Group}}" },
+			wantErr: true,
+		},
+	}
+
+	defer func(template string) {
+		roleBindingNameTemplate = template
+		setupWorkflowsFlags(t, nil)
+	}(roleBindingNameTemplate)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			roleBindingNameTemplate = defaultGroupNameTemplate
+			setupWorkflowsFlags(t, test.set)
+
+			subjects := []rbacv1.Subject{}
+			for _, group := range test.groups {
+				subjects = append(subjects, groupSubject(group))
+			}
+			_, err := generateRoleBindings(newNamespace("team-a"), newRoleBindingLister(newAdminsRoleBinding("team-a", subjects...)))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+			if err != nil && !errors.Is(err, reconcile.ErrInvalidName) {
+				t.Errorf("got error %v, want an invalid name", err)
+			}
+		})
+	}
+}
+
+func TestSubjectGroups(t *testing.T) {
+	tests := []struct {
+		name     string
+		subjects []rbacv1.Subject
+		want     []string
+	}{
+		{
+			name:     "duplicates removed in order",
+			subjects: []rbacv1.Subject{groupSubject("b"), groupSubject("a"), groupSubject("b")},
+			want:     []string{"b", "a"},
+		},
+		{
+			name:     "users with a group name ignored",
+			subjects: []rbacv1.Subject{{Kind: "User", Name: "a"}, groupSubject("a")},
+			want:     []string{"a"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := subjectGroups([]*rbacv1.RoleBinding{newAdminsRoleBinding("team-a", test.subjects...)})
+			if !equalStrings(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExcludeGroupsAnnotation(t *testing.T) {
+	setupWorkflowsFlags(t, func() {
+		namespaceAdminsRB = ""
+		namespaceAdminsRBPattern = "namespace-admins.*"
+	})
+
+	tests := []struct {
+		name      string
+		excluded  string
+		secondary string
+		want      []string
+	}{
+		{name: "no annotation", want: []string{"a", "b", "c"}},
+		{name: "subset excluded", excluded: "a,c", want: []string{"b"}},
+		{name: "spaces and empty entries ignored", excluded: " c , ,", want: []string{"a", "b"}},
+		{name: "every group excluded", excluded: "a,b,c", want: []string{}},
+		{name: "unknown groups ignored", excluded: "d", want: []string{"a", "b", "c"}},
+		// An exclusion only applies to its own role binding: a group bound
+		// by another namespace admins role binding keeps its access
+		{name: "group bound by another role binding", excluded: "a", secondary: "a", want: []string{"b", "c", "a"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			roleBinding := newAdminsRoleBinding("team-a", groupSubject("a"), groupSubject("b"), groupSubject("c"))
+			if test.excluded != "" {
+				roleBinding.Annotations = map[string]string{excludeGroupsAnnotation: test.excluded}
+			}
+			objects := []runtime.Object{roleBinding}
+			if test.secondary != "" {
+				secondary := newAdminsRoleBinding("team-a", groupSubject(test.secondary))
+				secondary.Name = "namespace-admins-extra"
+				objects = append(objects, secondary)
+			}
+
+			serviceAccounts, err := generateServiceAccounts(newNamespace("team-a"), newRoleBindingLister(objects...), nil)
+			if err != nil {
+				t.Fatalf("generateServiceAccounts: %v", err)
+			}
+
+			got := []string{}
+			for _, serviceAccount := range serviceAccounts[1:] {
+				rule := serviceAccount.Annotations["workflows.argoproj.io/rbac-rule"]
+				got = append(got, strings.TrimSuffix(strings.TrimPrefix(rule, "'"), "' in groups"))
+			}
+			if !equalStrings(got, test.want) {
+				t.Errorf("got service accounts for groups %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// newNamespacesController returns a controller whose namespace informer cache
+// holds the given namespaces. The informer is not started.
+func newNamespacesController(t *testing.T, names ...string) *namespaces.Controller {
+	t.Helper()
+
+	factory := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	informer := factory.Core().V1().Namespaces()
+	for _, name := range names {
+		if err := informer.Informer().GetIndexer().Add(newNamespace(name)); err != nil {
+			t.Fatalf("adding namespace %s: %v", name, err)
+		}
+	}
+
+	return namespaces.NewController("test", informer, func(*corev1.Namespace) error { return nil })
+}
+
+func TestNamespaceAdminsRoleBindingHandler(t *testing.T) {
+	setupWorkflowsFlags(t, nil)
+
+	old := newAdminsRoleBinding("team-a", groupSubject("team-a-admins"))
+	old.ResourceVersion = "1"
+
+	changed := old.DeepCopy()
+	changed.ResourceVersion = "2"
+	changed.Subjects = append(changed.Subjects, groupSubject("team-a-readers"))
+
+	other := old.DeepCopy()
+	other.Name = "other"
+	otherChanged := changed.DeepCopy()
+	otherChanged.Name = "other"
+
+	tests := []struct {
+		name     string
+		old, new *rbacv1.RoleBinding
+		want     int
+	}{
+		{name: "subjects changed", old: old, new: changed, want: 1},
+		{name: "resync of the same version", old: old, new: old.DeepCopy(), want: 0},
+		{name: "other role binding changed", old: other, new: otherChanged, want: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := newNamespacesController(t, "team-a")
+
+			namespaceAdminsRoleBindingHandler(controller).OnUpdate(test.old, test.new)
+
+			if got := controller.Stats().QueueLength; got != test.want {
+				t.Errorf("got %d namespaces queued, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+// checkTypeMeta fails the test unless obj has the given API version and kind,
+// which the appliers need to build patches and report errors.
+func checkTypeMeta(t *testing.T, obj runtime.Object, apiVersion, kind string) {
+	t.Helper()
+
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	if gotAPIVersion, gotKind := gvk.GroupVersion().String(), gvk.Kind; gotAPIVersion != apiVersion || gotKind != kind {
+		t.Errorf("got %s %s, want %s %s", gotAPIVersion, gotKind, apiVersion, kind)
+	}
+}
+
+func TestGeneratedObjectsTypeMeta(t *testing.T) {
+	setupWorkflowsFlags(t, nil)
+
+	namespace := newNamespace("team-a")
+	lister := newRoleBindingLister(newAdminsRoleBinding("team-a", groupSubject("team-a-admins"), groupSubject("team-a-readers")))
+
+	serviceAccounts, err := generateServiceAccounts(namespace, lister, nil)
+	if err != nil {
+		t.Fatalf("generateServiceAccounts: %v", err)
+	}
+	for _, serviceAccount := range serviceAccounts {
+		checkTypeMeta(t, serviceAccount, "v1", "ServiceAccount")
+	}
+
+	roleBindings, err := generateRoleBindings(namespace, lister)
+	if err != nil {
+		t.Fatalf("generateRoleBindings: %v", err)
+	}
+	for _, roleBinding := range roleBindings {
+		checkTypeMeta(t, roleBinding, "rbac.authorization.k8s.io/v1", "RoleBinding")
+	}
+
+	secrets, err := generateTokenSecrets(namespace, lister)
+	if err != nil {
+		t.Fatalf("generateTokenSecrets: %v", err)
+	}
+	for _, secret := range secrets {
+		checkTypeMeta(t, secret, "v1", "Secret")
+	}
+
+	spec := storageSecretSpec{
+		name:       "azure-storage",
+		provider:   secretprovider.NewEnv(secretprovider.Keys{User: "username", Password: "password"}),
+		secretType: corev1.SecretTypeOpaque,
+	}
+	storageSecret, err := generateStorageSecret(context.Background(), spec, namespace)
+	if err != nil {
+		t.Fatalf("generateStorageSecret: %v", err)
+	}
+	checkTypeMeta(t, storageSecret, "v1", "Secret")
+
+	if len(serviceAccounts) != 3 || len(roleBindings) != 3 || len(secrets) != 2 {
+		t.Errorf("got %d service accounts, %d role bindings and %d secrets, want 3, 3 and 2", len(serviceAccounts), len(roleBindings), len(secrets))
+	}
+}
+
+// runWorkflowsOnce reconciles every namespace of a fake cluster holding
+// objects once, as --run-once does, and returns the fake client and the
+// error of the reconciles.
+func runWorkflowsOnce(t *testing.T, objects ...runtime.Object) (*fake.Clientset, error) {
+	t.Helper()
+
+	return runWorkflowsOnceWith(t, nil, objects...)
+}
+
+// runWorkflowsOnceWith is runWorkflowsOnce, calling setup with the informer
+// factory before the controller registers its informers.
+func runWorkflowsOnceWith(t *testing.T, setup func(kubeinformers.SharedInformerFactory), objects ...runtime.Object) (*fake.Clientset, error) {
+	t.Helper()
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	kubeClient := fake.NewSimpleClientset(objects...)
+	factory := kubeinformers.NewSharedInformerFactory(kubeClient, 0)
+	if setup != nil {
+		setup(factory)
+	}
+	forbidden := newForbiddenReporter(record.NewFakeRecorder(100))
+
+	controller, synced := newWorkflowsController(stopCh, make(chan struct{}), kubeClient, factory, forbidden)
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, synced...) {
+		t.Fatal("failed to wait for caches to sync")
+	}
+	kubeClient.ClearActions()
+
+	return kubeClient, controller.RunOnce(stopCh, 1)
+}
+
+// writes returns the verb and resource of the writes made through
+// kubeClient, such as "create serviceaccounts".
+func writes(kubeClient *fake.Clientset) []string {
+	verbs := []string{}
+	for _, action := range kubeClient.Actions() {
+		// Matched on the verb, as a DeleteAction is a GetAction as well
+		switch action.GetVerb() {
+		case "get", "list", "watch":
+			continue
+		}
+		verbs = append(verbs, action.GetVerb()+" "+action.GetResource().Resource)
+	}
+
+	return verbs
+}
+
+func TestTerminatingNamespaceSkipped(t *testing.T) {
+	setupWorkflowsFlags(t, nil)
+
+	now := metav1.Now()
+	tests := []struct {
+		name       string
+		namespace  *corev1.Namespace
+		wantWrites bool
+	}{
+		{
+			name:       "active",
+			namespace:  newNamespace("team-a"),
+			wantWrites: true,
+		},
+		{
+			name:      "terminating phase",
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}, Status: corev1.NamespaceStatus{Phase: corev1.NamespaceTerminating}},
+		},
+		{
+			name:      "deletion timestamp",
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", DeletionTimestamp: &now}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kubeClient, err := runWorkflowsOnce(t, test.namespace, newAdminsRoleBinding("team-a", groupSubject("team-a-admins")))
+			if err != nil {
+				t.Fatalf("RunOnce: %v", err)
+			}
+
+			if got := writes(kubeClient); (len(got) > 0) != test.wantWrites {
+				t.Errorf("got writes %v, want writes %t", got, test.wantWrites)
+			}
+		})
+	}
+}
+
+// failingIndexer is an informer cache whose reads by key fail.
+type failingIndexer struct {
+	cache.Indexer
+	err error
+}
+
+func (i failingIndexer) GetByKey(key string) (interface{}, bool, error) {
+	return nil, false, i.err
+}
+
+// failingInformer is an informer whose cache reads by key fail, so that
+// listers built from it return err from Get.
+type failingInformer struct {
+	cache.SharedIndexInformer
+	err error
+}
+
+func (i failingInformer) GetIndexer() cache.Indexer {
+	return failingIndexer{Indexer: i.SharedIndexInformer.GetIndexer(), err: i.err}
+}
+
+func TestStorageSecretReconciledWhenAdminsLookupFails(t *testing.T) {
+	t.Setenv("ARGO_SECRET_NAME", "azure-storage")
+	setupWorkflowsFlags(t, nil)
+
+	lookupErr := errors.New("transient error")
+	failRoleBindingLookups := func(factory kubeinformers.SharedInformerFactory) {
+		factory.InformerFor(&rbacv1.RoleBinding{}, func(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+			informer := rbacv1informers.NewRoleBindingInformer(client, metav1.NamespaceAll, resync, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			return failingInformer{SharedIndexInformer: informer, err: lookupErr}
+		})
+	}
+
+	kubeClient, err := runWorkflowsOnceWith(t, failRoleBindingLookups, newNamespace("team-a"), newAdminsRoleBinding("team-a", groupSubject("team-a-admins")))
+	if err == nil || !strings.Contains(err.Error(), lookupErr.Error()) {
+		t.Errorf("got error %v, want the lookup error", err)
+	}
+
+	created := []string{}
+	for _, action := range kubeClient.Actions() {
+		if create, ok := action.(k8stesting.CreateAction); ok {
+			created = append(created, create.GetResource().Resource+"/"+create.GetObject().(metav1.Object).GetName())
+		}
+	}
+	if !equalStrings(created, []string{"secrets/azure-storage"}) {
+		t.Errorf("got created %v, want only the storage secret", created)
+	}
+}
+
+func TestStorageSecretKeepsExternalKeys(t *testing.T) {
+	t.Setenv("ARGO_SECRET_NAME", "azure-storage")
+	t.Setenv(secretprovider.AccountNameEnv, "account")
+	t.Setenv(secretprovider.AccountKeyEnv, "new-key")
+	setupWorkflowsFlags(t, nil)
+
+	existing := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "azure-storage",
+			Namespace: "team-a",
+			Labels:    mergeMaps(managedByLabels, map[string]string{storageSecretLabel: "true"}),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			storageSecretUserKey:     []byte("account"),
+			storageSecretPasswordKey: []byte("old-key"),
+			"ca.crt":                 []byte("bundle"),
+		},
+	}
+
+	kubeClient, err := runWorkflowsOnce(t, newNamespace("team-a"), existing)
+	if err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+
+	secret, err := kubeClient.CoreV1().Secrets("team-a").Get(context.Background(), "azure-storage", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting the storage secret: %v", err)
+	}
+	want := map[string]string{
+		storageSecretUserKey:     "account",
+		storageSecretPasswordKey: "new-key",
+		"ca.crt":                 "bundle",
+	}
+	for key, value := range want {
+		if got := string(secret.Data[key]); got != value {
+			t.Errorf("key %q: got %q, want %q", key, got, value)
+		}
+	}
+}
+
+func TestRoleBindingRecreatedWhenRoleRefChanges(t *testing.T) {
+	setupWorkflowsFlags(t, nil)
+
+	names, err := namesForGroup("team-a", "team-a-admins")
+	if err != nil {
+		t.Fatalf("namesForGroup: %v", err)
+	}
+	// Generated while --user-interface-cluster-role-name was another role
+	existing := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      names.roleBindings[0],
+			Namespace: "team-a",
+			Labels:    managedByLabels,
+		},
+		RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "argo-workflows-ui-old"},
+		Subjects: []rbacv1.Subject{
+			{Kind: "ServiceAccount", Name: names.serviceAccount, Namespace: "team-a"},
+		},
+	}
+
+	kubeClient, err := runWorkflowsOnce(t, newNamespace("team-a"), newAdminsRoleBinding("team-a", groupSubject("team-a-admins")), existing)
+	if err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+
+	verbs := []string{}
+	for _, action := range kubeClient.Actions() {
+		if action.GetResource().Resource != "rolebindings" {
+			continue
+		}
+		switch action := action.(type) {
+		case k8stesting.DeleteAction:
+			if action.GetName() == existing.Name {
+				verbs = append(verbs, "delete")
+			}
+		case k8stesting.CreateAction:
+			if action.GetObject().(metav1.Object).GetName() == existing.Name {
+				verbs = append(verbs, "create")
+			}
+		case k8stesting.UpdateAction:
+			if action.GetObject().(metav1.Object).GetName() == existing.Name {
+				verbs = append(verbs, "update")
+			}
+		}
+	}
+	if !equalStrings(verbs, []string{"delete", "create"}) {
+		t.Errorf("got %v of the role binding, want it deleted then created", verbs)
+	}
+
+	roleBinding, err := kubeClient.RbacV1().RoleBindings("team-a").Get(context.Background(), existing.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting the role binding: %v", err)
+	}
+	if roleBinding.RoleRef.Name != "argo-workflows-ui" {
+		t.Errorf("got role %q, want argo-workflows-ui", roleBinding.RoleRef.Name)
+	}
+}
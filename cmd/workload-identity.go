@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The annotations and label of Azure Workload Identity, which exchanges the
+// token of a service account for one of a Microsoft Entra application.
+const (
+	workloadIdentityPrefix      = "azure.workload.identity/"
+	workloadIdentityClientIDKey = workloadIdentityPrefix + "client-id"
+	workloadIdentityTenantIDKey = workloadIdentityPrefix + "tenant-id"
+	workloadIdentityUseLabel    = workloadIdentityPrefix + "use"
+)
+
+var workloadIdentityClientID string
+var workloadIdentityTenantID string
+
+// guidRegexp matches the client and tenant IDs of Microsoft Entra.
+var guidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// validateWorkloadIdentityFlags checks the IDs are GUIDs, and that the
+// workload identity annotations are not also set by --workflow-sa-annotations.
+func validateWorkloadIdentityFlags() error {
+	if workloadIdentityTenantID != "" && workloadIdentityClientID == "" {
+		return fmt.Errorf("--azure-workload-identity-tenant-id: requires --azure-workload-identity-client-id")
+	}
+	if workloadIdentityClientID == "" {
+		return nil
+	}
+
+	if !guidRegexp.MatchString(workloadIdentityClientID) {
+		return fmt.Errorf("--azure-workload-identity-client-id: %q is not a GUID", workloadIdentityClientID)
+	}
+	if workloadIdentityTenantID != "" && !guidRegexp.MatchString(workloadIdentityTenantID) {
+		return fmt.Errorf("--azure-workload-identity-tenant-id: %q is not a GUID", workloadIdentityTenantID)
+	}
+	for key := range workflowServiceAccountAnnotations {
+		if strings.HasPrefix(key, workloadIdentityPrefix) {
+			return fmt.Errorf("--workflow-sa-annotations: annotation %q is set by --azure-workload-identity-client-id", key)
+		}
+	}
+
+	return nil
+}
+
+// workloadIdentityAnnotations returns the annotations of the workflow
+// service account configuring Azure Workload Identity, if enabled.
+func workloadIdentityAnnotations() map[string]string {
+	if workloadIdentityClientID == "" {
+		return nil
+	}
+
+	annotations := map[string]string{workloadIdentityClientIDKey: workloadIdentityClientID}
+	if workloadIdentityTenantID != "" {
+		annotations[workloadIdentityTenantIDKey] = workloadIdentityTenantID
+	}
+
+	return annotations
+}
+
+// workloadIdentityLabels returns the labels of the workflow service account
+// enabling Azure Workload Identity, if enabled.
+func workloadIdentityLabels() map[string]string {
+	if workloadIdentityClientID == "" {
+		return nil
+	}
+
+	return map[string]string{workloadIdentityUseLabel: "true"}
+}
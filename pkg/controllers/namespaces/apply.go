@@ -0,0 +1,227 @@
+package namespaces
+
+import (
+	"context"
+
+	"github.com/gccloudone-aurora/argo-controller/pkg/metrics"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+)
+
+// Object is a Kubernetes object which can be applied by an Applier.
+type Object interface {
+	metav1.Object
+	runtime.Object
+}
+
+// Applier creates or updates objects of one kind. Which fields are reconciled
+// is decided by InSync and Merge, so that every kind follows the same
+// get, create, compare and update flow.
+type Applier struct {
+	// Kind is the kind of the objects, as reported in metrics
+	Kind string
+
+	// Noun names the kind in logs, such as "service account"
+	Noun string
+
+	// Cached returns the object from the informer cache
+	Cached func(namespace, name string) (Object, error)
+
+	// Get returns the live object from the API server
+	Get func(ctx context.Context, namespace, name string) (Object, error)
+
+	// Create creates the object, returning it as created
+	Create func(ctx context.Context, obj Object) (Object, error)
+
+	// Update replaces the object. previous is the object as read, before
+	// the reconciled fields were merged into it.
+	Update func(ctx context.Context, obj, previous Object) error
+
+	// Delete deletes the given version of the object. It is only needed
+	// with Expired or Immutable.
+	Delete func(ctx context.Context, obj Object) error
+
+	// InSync reports whether current already holds the reconciled fields of
+	// desired
+	InSync func(current, desired Object) bool
+
+	// Merge copies the reconciled fields of desired onto live, the object
+	// about to be updated
+	Merge func(live, desired Object)
+
+	// Expired, if set, reports whether current must be deleted and created
+	// again rather than updated
+	Expired func(current Object) bool
+
+	// Immutable, if set, reports whether current differs from desired in a
+	// field which cannot be updated, in which case it is deleted and created
+	// again
+	Immutable func(current, desired Object) bool
+
+	// Managed, if set, reports whether current is managed by the controller.
+	// Objects which are not are left alone, so that objects created by users
+	// under the same name are never clobbered, unless Adopt is set.
+	Managed func(current Object) bool
+
+	// Adopt makes objects which are not Managed be updated like any other,
+	// Merge stamping them as managed
+	Adopt bool
+
+	// AdoptApply, if set, adopts objects which are not Managed with a
+	// server-side apply of desired rather than an update, returning the
+	// applied object. It returns a nil object to leave current alone, such
+	// as when other field managers own fields of it.
+	AdoptApply func(ctx context.Context, desired Object) (Object, error)
+
+	// Unmanaged, if set, is called with every object left alone because it
+	// is not Managed
+	Unmanaged func(ctx context.Context, current Object)
+
+	// Applied, if set, is called with the object once it has been created,
+	// found in sync or updated, such as to read back its UID
+	Applied func(current Object)
+
+	// DryRun is set when writes are not persisted. Expired objects are then
+	// not created again, as the old object still exists.
+	DryRun bool
+}
+
+// Apply creates desired if it does not exist, and otherwise updates it if it
+// is not in sync. An object deleted by someone else between the two is
+// created again, and counted in the recreated metric. An object which exists
+// but is not cached is read from the API server.
+func (a *Applier) Apply(ctx context.Context, desired Object) error {
+	namespace, name := desired.GetNamespace(), desired.GetName()
+
+	current, err := a.Cached(namespace, name)
+	created := false
+	if errors.IsNotFound(err) {
+		klog.V(2).Infof("creating %s %s/%s", a.Noun, namespace, name)
+		current, err = a.Create(ctx, desired)
+		if errors.IsAlreadyExists(err) {
+			// Missing from a cache filtered by label, such as an object
+			// created before the controller: carry on with the live object
+			klog.V(2).Infof("%s %s/%s already exists but is not cached", a.Noun, namespace, name)
+			current, err = a.Get(ctx, namespace, name)
+		} else {
+			created = err == nil
+		}
+	}
+
+	switch {
+	case err != nil:
+		return err
+	case created:
+		// Just created from desired, so managed and not expired
+	case a.Managed != nil && !a.Managed(current):
+		// Checked before Expired, so that unmanaged objects are never deleted
+		if !a.Adopt {
+			klog.V(2).Infof("not updating %s %s/%s, which is not managed by the controller", a.Noun, namespace, name)
+			metrics.UnmanagedSkipped.Inc(a.Kind)
+			if a.Unmanaged != nil {
+				a.Unmanaged(ctx, current)
+			}
+			return nil
+		}
+		klog.V(2).Infof("adopting %s %s/%s", a.Noun, namespace, name)
+		if a.AdoptApply != nil {
+			applied, err := a.AdoptApply(ctx, desired)
+			if err != nil {
+				return err
+			}
+			if applied == nil {
+				metrics.UnmanagedSkipped.Inc(a.Kind)
+				return nil
+			}
+			current = applied
+		}
+	case a.Expired != nil && a.Expired(current):
+		klog.V(2).Infof("recreating expired %s %s/%s", a.Noun, namespace, name)
+		if current, err = a.recreate(ctx, current, desired); err != nil || current == nil {
+			return err
+		}
+	case a.Immutable != nil && a.Immutable(current, desired):
+		// Logged at Info, as whatever the object grants is briefly missing
+		klog.Infof("recreating %s %s/%s, as a field which cannot be updated changed", a.Noun, namespace, name)
+		if current, err = a.recreate(ctx, current, desired); err != nil || current == nil {
+			return err
+		}
+	}
+
+	if a.InSync(current, desired) {
+		a.applied(current)
+		return nil
+	}
+
+	klog.V(2).Infof("updating %s %s/%s", a.Noun, namespace, name)
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		// Update the live object rather than the possibly stale cached copy
+		live, err := a.Get(ctx, namespace, name)
+		if err != nil {
+			return err
+		}
+		if a.InSync(live, desired) {
+			return nil
+		}
+
+		// Objects read from the API do not carry their TypeMeta
+		live.GetObjectKind().SetGroupVersionKind(desired.GetObjectKind().GroupVersionKind())
+		previous := live.DeepCopyObject().(Object)
+		a.Merge(live, desired)
+
+		return a.Update(ctx, live, previous)
+	})
+	if errors.IsNotFound(err) {
+		// Deleted by someone else since it was listed
+		klog.V(2).Infof("recreating %s %s/%s", a.Noun, namespace, name)
+		created, err := a.Create(ctx, desired)
+		if err != nil {
+			return err
+		}
+		metrics.Recreated.Inc(a.Kind)
+		a.applied(created)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// An update keeps the UID of the object
+	a.applied(current)
+	return nil
+}
+
+// recreate deletes current and creates desired in its place, returning the
+// created object, or nil when writes are dry runs and the old object is left
+// in place. The new object is created right after the old one is gone to
+// keep the gap short.
+func (a *Applier) recreate(ctx context.Context, current, desired Object) (Object, error) {
+	if err := a.Delete(ctx, current); err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	if a.DryRun {
+		return nil, nil
+	}
+
+	var created Object
+	err := retry.OnError(retry.DefaultBackoff, errors.IsAlreadyExists, func() error {
+		var err error
+		created, err = a.Create(ctx, desired)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// applied calls the Applied hook, if set.
+func (a *Applier) applied(current Object) {
+	if a.Applied != nil {
+		a.Applied(current)
+	}
+}
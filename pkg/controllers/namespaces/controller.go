@@ -19,11 +19,19 @@ package namespaces
 
 import (
 	"fmt"
+	"math/rand"
+	"sync"
 	"time"
 
+	"github.com/gccloudone-aurora/argo-controller/pkg/debug"
+	"github.com/gccloudone-aurora/argo-controller/pkg/metrics"
+	"github.com/gccloudone-aurora/argo-controller/pkg/reconcile"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	corev1informers "k8s.io/client-go/informers/core/v1"
@@ -35,11 +43,34 @@ import (
 
 type namespaceSyncCallback func(*corev1.Namespace) error
 
+const (
+	// LastReconciledAnnotation records when the namespace was last reconciled.
+	LastReconciledAnnotation = "argo-workflows.aurora/last-reconciled"
+
+	// ReconcileStatusAnnotation records the outcome of the last reconcile of
+	// the namespace.
+	ReconcileStatusAnnotation = "argo-workflows.aurora/reconcile-status"
+)
+
+// defaultResyncJitter is the default maximum fraction of the resync and
+// requeue after success delays added at random, so namespaces reconciled
+// together are not all reconciled again at the same time.
+const defaultResyncJitter = 0.1
+
 // Controller struct for informers
 type Controller struct {
+	// name identifies the controller in its workqueue and metrics
+	name string
+
 	namespaceLister corev1listers.NamespaceLister
 	namespaceSynced cache.InformerSynced
 
+	// watchNamespace is set when the controller is scoped to a single
+	// namespace. In that mode there is no namespace informer, so the
+	// namespace is enqueued directly every resyncPeriod.
+	watchNamespace string
+	resyncPeriod   time.Duration
+
 	// Sync callback will run for each object
 	sync namespaceSyncCallback
 
@@ -49,19 +80,44 @@ type Controller struct {
 	// time, and makes it easy to ensure we are never processing the same item
 	// simultaneously in two different workers.
 	workqueue workqueue.RateLimitingInterface
+
+	// tracker records the reconciles in flight and the last reconcile of
+	// every key, for the debug endpoint
+	tracker *debug.Tracker
+
+	// requeueAfterSuccess, if positive, is how long after a successful
+	// reconcile a namespace is reconciled again
+	requeueAfterSuccess time.Duration
+
+	// resyncJitter is the maximum fraction of the resync and requeue
+	// after success delays added at random
+	resyncJitter float64
+
+	// firstPass holds the namespaces listed when the workers started which
+	// have not yet been reconciled successfully. firstPassDone is set once
+	// it is empty.
+	firstPassMu   sync.Mutex
+	firstPass     map[string]bool
+	firstPassDone bool
 }
 
 // NewController func for event handlers
 func NewController(
+	name string,
 	namespaceInformer corev1informers.NamespaceInformer,
 	sync namespaceSyncCallback,
 ) *Controller {
 	controller := &Controller{
+		name:            name,
 		namespaceLister: namespaceInformer.Lister(),
 		namespaceSynced: namespaceInformer.Informer().HasSynced,
 		sync:            sync,
-		workqueue:       workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Namespaces"),
+		workqueue:       workqueue.NewNamedRateLimitingQueue(newRateLimiter(), name),
+		tracker:         debug.NewTracker(),
+		resyncJitter:    defaultResyncJitter,
 	}
+	debug.RegisterController(name, controller.Stats)
+	debug.RegisterEnqueuer(name, controller.Enqueue)
 
 	// Configure event handlers
 	klog.Info("configuring event handlers")
@@ -69,13 +125,64 @@ func NewController(
 	namespaceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: controller.EnqueueNamespace,
 		UpdateFunc: func(old, new interface{}) {
+			// Recording the outcome of a reconcile on the namespace must not
+			// trigger another reconcile.
+			oldNamespace, oldOk := old.(*corev1.Namespace)
+			newNamespace, newOk := new.(*corev1.Namespace)
+			if oldOk && newOk && onlyStatusChanged(oldNamespace, newNamespace) {
+				return
+			}
+
 			controller.EnqueueNamespace(new)
 		},
+		// Deleted namespaces are reconciled once more, so that their
+		// metrics are removed
+		DeleteFunc: func(obj interface{}) {
+			key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+			if err != nil {
+				utilruntime.HandleError(err)
+				return
+			}
+			controller.workqueue.Add(key)
+		},
 	})
 
 	return controller
 }
 
+// NewNamespacedController returns a controller which only reconciles the
+// given namespace. It does not require permission to list or watch
+// namespaces; instead the namespace is enqueued on start and then again
+// every resyncPeriod.
+func NewNamespacedController(
+	name string,
+	namespace string,
+	resyncPeriod time.Duration,
+	sync namespaceSyncCallback,
+) *Controller {
+	controller := &Controller{
+		name: name,
+		namespaceLister: &staticNamespaceLister{
+			namespace: &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: namespace,
+				},
+			},
+		},
+		namespaceSynced: func() bool { return true },
+		watchNamespace:  namespace,
+		resyncPeriod:    resyncPeriod,
+		sync:            sync,
+		workqueue:       workqueue.NewNamedRateLimitingQueue(newRateLimiter(), name),
+		tracker:         debug.NewTracker(),
+		resyncJitter:    defaultResyncJitter,
+	}
+	debug.RegisterController(name, controller.Stats)
+	debug.RegisterEnqueuer(name, controller.Enqueue)
+
+	return controller
+}
+
 // Run will set up the event handlers for types we are interested in, as well
 // as syncing informer caches and starting workers. It will block until stopCh
 // is closed, at which point it will shutdown the workqueue and wait for
@@ -93,24 +200,219 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
+	if err := c.startFirstPass(); err != nil {
+		return err
+	}
+
 	klog.Info("starting workers")
-	// Launch two workers to process Namespace resources
+	// Launch threadiness workers to process Namespace resources
+	var workers sync.WaitGroup
 	for i := 0; i < threadiness; i++ {
-		go wait.Until(c.runWorker, time.Second, stopCh)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			wait.Until(func() { c.runWorker(stopCh) }, time.Second, stopCh)
+		}()
+	}
+
+	// Without a namespace informer nothing else will enqueue the watched
+	// namespace, so do it ourselves.
+	if c.watchNamespace != "" {
+		go wait.JitterUntil(func() {
+			c.workqueue.Add(c.watchNamespace)
+		}, c.resyncPeriod, c.resyncJitter, true, stopCh)
 	}
 
 	klog.Info("Started workers")
 	<-stopCh
 	klog.Info("Shutting down workers")
 
+	// Wait for the items being processed, so that no write outlives Run
+	c.workqueue.ShutDown()
+	workers.Wait()
+
 	return nil
 }
 
+// SetRequeueAfterSuccess makes the controller reconcile every namespace again
+// after delay following a successful reconcile, to correct drift missed by
+// the informers. A delay of zero disables it. The workqueue keeps a single
+// pending requeue per namespace, and a namespace already queued is not
+// queued twice, so requeues do not pile up.
+func (c *Controller) SetRequeueAfterSuccess(delay time.Duration) {
+	c.requeueAfterSuccess = delay
+}
+
+// SetResyncJitter sets the maximum fraction of the resync and requeue after
+// success delays added at random, 0.1 by default. Zero disables the jitter.
+func (c *Controller) SetResyncJitter(factor float64) {
+	c.resyncJitter = factor
+}
+
+// RunFullResync reconciles every namespace every interval until stopCh is
+// closed. Rather than all at once, each namespace is enqueued after a random
+// delay of up to the resync jitter times interval, spreading the load on the
+// API server.
+func (c *Controller) RunFullResync(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		c.enqueueAllSpread(time.Duration(float64(interval) * c.resyncJitter))
+	}, interval, stopCh)
+}
+
+// startFirstPass records the namespaces to reconcile before the first full
+// pass is complete: the watched namespace, or every namespace in the cache.
+func (c *Controller) startFirstPass() error {
+	pending := map[string]bool{}
+	if c.watchNamespace != "" {
+		pending[c.watchNamespace] = true
+	} else {
+		namespaces, err := c.namespaceLister.List(labels.Everything())
+		if err != nil {
+			return err
+		}
+		for _, namespace := range namespaces {
+			pending[namespace.Name] = true
+		}
+	}
+
+	c.firstPassMu.Lock()
+	defer c.firstPassMu.Unlock()
+
+	c.firstPass = pending
+	c.firstPassDone = false
+	metrics.FirstReconcileComplete.Set(0, c.name)
+	c.checkFirstPass()
+
+	return nil
+}
+
+// firstPassReconciled records the successful reconcile of key.
+func (c *Controller) firstPassReconciled(key string) {
+	c.firstPassMu.Lock()
+	defer c.firstPassMu.Unlock()
+
+	if c.firstPassDone {
+		return
+	}
+	delete(c.firstPass, key)
+	c.checkFirstPass()
+}
+
+// checkFirstPass marks the first full pass complete once every namespace has
+// been reconciled. The caller must hold firstPassMu.
+func (c *Controller) checkFirstPass() {
+	if len(c.firstPass) > 0 {
+		return
+	}
+
+	c.firstPassDone = true
+	metrics.FirstReconcileComplete.Set(1, c.name)
+	klog.Infof("%s: first full reconcile complete", c.name)
+}
+
+// FirstReconcileComplete reports whether every namespace present when the
+// workers started has since been reconciled successfully. Namespaces deleted
+// in the meantime count as reconciled once their key is processed.
+func (c *Controller) FirstReconcileComplete() bool {
+	c.firstPassMu.Lock()
+	defer c.firstPassMu.Unlock()
+
+	return c.firstPassDone
+}
+
+// Stats returns the state of the controller for the debug endpoint.
+func (c *Controller) Stats() debug.ControllerStats {
+	return c.tracker.Stats(c.workqueue.Len(), c.namespaceSynced())
+}
+
+// Enqueue puts a namespace onto the workqueue for the on-demand reconcile
+// endpoint. The workqueue does not expose the order of its items, so the
+// position of a newly queued namespace is the queue length once it is added,
+// and other keys may be added or processed in the meantime.
+func (c *Controller) Enqueue(namespace string) (debug.EnqueueResult, error) {
+	if c.workqueue.ShuttingDown() {
+		return debug.EnqueueResult{}, debug.ErrNotRunning
+	}
+	if _, err := c.namespaceLister.Get(namespace); errors.IsNotFound(err) {
+		return debug.EnqueueResult{}, debug.ErrUnknownKey
+	} else if err != nil {
+		return debug.EnqueueResult{}, err
+	}
+
+	before := c.workqueue.Len()
+	c.workqueue.Add(namespace)
+	result := debug.EnqueueResult{Key: namespace, QueueLength: c.workqueue.Len()}
+	switch {
+	case result.QueueLength > before:
+		result.Result = "queued"
+		result.Position = result.QueueLength
+	case c.tracker.InFlight(namespace):
+		result.Result = "in-flight"
+	default:
+		result.Result = "already-queued"
+	}
+
+	return result, nil
+}
+
+// RunOnce reconciles every namespace exactly once, up to concurrency at a
+// time, without starting workers or waiting for further changes. It returns
+// the aggregated errors of the namespaces which failed to reconcile. No
+// namespace is started once stopCh is closed.
+func (c *Controller) RunOnce(stopCh <-chan struct{}, concurrency int) error {
+	klog.Info("waiting for informer caches to sync")
+	if ok := cache.WaitForCacheSync(stopCh, c.namespaceSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	namespaces, err := c.namespaceLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	// The namespaces are read from the cache, which is shared by the
+	// reconciles and never modified
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	errs := []error{}
+	semaphore := make(chan struct{}, concurrency)
+	for _, namespace := range namespaces {
+		select {
+		case <-stopCh:
+		case semaphore <- struct{}{}:
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				defer func() { <-semaphore }()
+
+				if err := c.syncHandler(name); err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("error syncing '%s': %s", name, err.Error()))
+					mu.Unlock()
+					return
+				}
+				klog.Infof("Successfully synced '%s'", name)
+			}(namespace.Name)
+		}
+	}
+	wg.Wait()
+
+	return utilerrors.NewAggregate(errs)
+}
+
 // runWorker is a long-running function that will continually call the
 // processNextWorkItem function in order to read and process a message on the
-// workqueue.
-func (c *Controller) runWorker() {
-	for c.processNextWorkItem() {
+// workqueue, until stopCh is closed.
+func (c *Controller) runWorker(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+		if !c.processNextWorkItem() {
+			return
+		}
 	}
 }
 
@@ -149,15 +451,36 @@ func (c *Controller) processNextWorkItem() bool {
 		}
 		// Run the syncHandler, passing it the namespace/name string of the
 		// Namespace resource to be synced.
-		if err := c.syncHandler(key); err != nil {
-			// Put the item back on the workqueue to handle any transient errors.
+		c.tracker.Start(key)
+		err := c.syncHandler(key)
+		c.tracker.Done(key, err)
+		metrics.ObserveReconcile(c.name, err)
+		if err != nil {
+			// Permission errors are reported by the sync callback, so
+			// requeue them with a longer delay instead of logging and
+			// retrying in a tight loop. Writes applied in parallel fail
+			// with an aggregate, which is checked error by error.
+			metrics.SetNamespaceFailing(c.name, key, true)
+			if reconcile.IsForbidden(err) {
+				c.workqueue.Forget(obj)
+				c.workqueue.AddAfter(key, reconcile.ForbiddenRequeueDelay)
+				return nil
+			}
+
+			// Put the item back on the workqueue to handle any transient
+			// errors. The delay grows with every failure, with jitter.
 			c.workqueue.AddRateLimited(key)
 			return fmt.Errorf("error syncing '%s': %s, requeuing", key, err.Error())
 		}
 		// Finally, if no error occurs we Forget this item so it does not
 		// get queued again until another change happens.
 		c.workqueue.Forget(obj)
+		metrics.SetNamespaceFailing(c.name, key, false)
+		c.firstPassReconciled(key)
 		klog.Infof("Successfully synced '%s'", key)
+		if c.requeueAfterSuccess > 0 {
+			c.workqueue.AddAfter(key, wait.Jitter(c.requeueAfterSuccess, c.resyncJitter))
+		}
 		return nil
 	}(obj)
 
@@ -177,9 +500,10 @@ func (c *Controller) syncHandler(key string) error {
 	namespace, err := c.namespaceLister.Get(key)
 	if err != nil {
 		// The Namespace resource may no longer exist, in which case we stop
-		// processing.
+		// processing and forget its metrics.
 		if errors.IsNotFound(err) {
-			utilruntime.HandleError(fmt.Errorf("namespace '%s' in work queue no longer exists", key))
+			klog.V(2).Infof("namespace '%s' in work queue no longer exists", key)
+			metrics.DeleteProvisionedGroups(key)
 			return nil
 		}
 
@@ -202,26 +526,58 @@ func (c *Controller) EnqueueNamespace(obj interface{}) {
 	c.workqueue.Add(key)
 }
 
+// EnqueueAll puts every namespace known to the controller onto the work
+// queue, regardless of whether it has changed. This is used to force a full
+// reconcile when the desired state depends on inputs other than the watched
+// objects.
+func (c *Controller) EnqueueAll() {
+	namespaces, err := c.namespaceLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	klog.V(4).Infof("Enqueueing %d namespaces for a full resync", len(namespaces))
+	for _, namespace := range namespaces {
+		c.EnqueueNamespace(namespace)
+	}
+}
+
+// enqueueAllSpread puts every namespace known to the controller onto the
+// work queue, each after a random delay of up to window.
+func (c *Controller) enqueueAllSpread(window time.Duration) {
+	if window <= 0 {
+		c.EnqueueAll()
+		return
+	}
+
+	namespaces, err := c.namespaceLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	klog.V(4).Infof("Enqueueing %d namespaces for a full resync over %s", len(namespaces), window)
+	for _, namespace := range namespaces {
+		key, err := cache.MetaNamespaceKeyFunc(namespace)
+		if err != nil {
+			utilruntime.HandleError(err)
+			continue
+		}
+		c.workqueue.AddAfter(key, time.Duration(rand.Int63n(int64(window))))
+	}
+}
+
 // HandleObject will take any resource implementing metav1.Object and attempt
 // to find the Namespace resource that 'owns' it. It does this by looking at the
 // objects metadata.ownerReferences field for an appropriate OwnerReference.
 // It then enqueues that Namespace resource to be processed. If the object does not
-// have an appropriate OwnerReference, it will simply be skipped.
+// have an appropriate OwnerReference, or its Namespace no longer exists or is
+// terminating, it will simply be skipped.
 func (c *Controller) HandleObject(obj interface{}) {
-	var object metav1.Object
-	var ok bool
-	if object, ok = obj.(metav1.Object); !ok {
-		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
-		if !ok {
-			utilruntime.HandleError(fmt.Errorf("error decoding object, invalid type"))
-			return
-		}
-		object, ok = tombstone.Obj.(metav1.Object)
-		if !ok {
-			utilruntime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
-			return
-		}
-		klog.V(4).Infof("Recovered deleted object '%s' from tombstone", object.GetName())
+	object, ok := decodeObject(obj)
+	if !ok {
+		return
 	}
 	klog.V(4).Infof("Processing object: %s", object.GetName())
 	if ownerRef := metav1.GetControllerOf(object); ownerRef != nil {
@@ -231,9 +587,16 @@ func (c *Controller) HandleObject(obj interface{}) {
 			return
 		}
 
+		// Drop the event rather than enqueueing a namespace which cannot be
+		// reconciled: the namespace may be gone, or be terminating, in which
+		// case its objects are about to be deleted with it.
 		namespace, err := c.namespaceLister.Get(ownerRef.Name)
 		if err != nil {
-			klog.V(4).Infof("ignoring orphaned object '%s' of namespace '%s'", object.GetSelfLink(), ownerRef.Name)
+			klog.V(4).Infof("ignoring object '%s/%s' of unresolvable namespace '%s': %v", object.GetNamespace(), object.GetName(), ownerRef.Name, err)
+			return
+		}
+		if namespace.DeletionTimestamp != nil || namespace.Status.Phase == corev1.NamespaceTerminating {
+			klog.V(4).Infof("ignoring object '%s/%s' of terminating namespace '%s'", object.GetNamespace(), object.GetName(), namespace.Name)
 			return
 		}
 
@@ -241,3 +604,93 @@ func (c *Controller) HandleObject(obj interface{}) {
 		return
 	}
 }
+
+// EnqueueObjectNamespace takes any namespaced resource implementing
+// metav1.Object and enqueues the Namespace it lives in. It is used for inputs
+// to the reconcile which are not owned by the Namespace, such as the
+// namespace-admins role binding.
+func (c *Controller) EnqueueObjectNamespace(obj interface{}) {
+	object, ok := decodeObject(obj)
+	if !ok {
+		return
+	}
+
+	namespace, err := c.namespaceLister.Get(object.GetNamespace())
+	if err != nil {
+		klog.V(4).Infof("ignoring object '%s' of unknown namespace '%s'", object.GetName(), object.GetNamespace())
+		return
+	}
+
+	klog.V(4).Infof("Enqueueing namespace '%s' for object '%s'", namespace.Name, object.GetName())
+	c.EnqueueNamespace(namespace)
+}
+
+// decodeObject returns the metav1.Object passed to an event handler,
+// recovering it from a tombstone if the delete event was missed.
+func decodeObject(obj interface{}) (metav1.Object, bool) {
+	if object, ok := obj.(metav1.Object); ok {
+		return object, true
+	}
+
+	tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+	if !ok {
+		utilruntime.HandleError(fmt.Errorf("error decoding object, invalid type"))
+		return nil, false
+	}
+	object, ok := tombstone.Obj.(metav1.Object)
+	if !ok {
+		utilruntime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
+		return nil, false
+	}
+	klog.V(4).Infof("Recovered deleted object '%s' from tombstone", object.GetName())
+
+	return object, true
+}
+
+// onlyStatusChanged reports whether the only difference between two versions
+// of a namespace is in the reconcile status annotations. Resyncs, where both
+// versions are identical, are not considered a status change.
+func onlyStatusChanged(old, new *corev1.Namespace) bool {
+	if old.ResourceVersion == new.ResourceVersion {
+		return false
+	}
+
+	strip := func(namespace *corev1.Namespace) *corev1.Namespace {
+		namespace = namespace.DeepCopy()
+		namespace.ResourceVersion = ""
+		namespace.ManagedFields = nil
+		delete(namespace.Annotations, LastReconciledAnnotation)
+		delete(namespace.Annotations, ReconcileStatusAnnotation)
+		if len(namespace.Annotations) == 0 {
+			namespace.Annotations = nil
+		}
+		return namespace
+	}
+
+	return equality.Semantic.DeepEqual(strip(old), strip(new))
+}
+
+// staticNamespaceLister is a NamespaceLister which only knows about a single
+// namespace. It is used in namespaced mode, where the controller is not
+// permitted to read namespace objects from the API.
+type staticNamespaceLister struct {
+	namespace *corev1.Namespace
+}
+
+// List returns the watched namespace if it matches the selector.
+func (l *staticNamespaceLister) List(selector labels.Selector) ([]*corev1.Namespace, error) {
+	if !selector.Matches(labels.Set(l.namespace.Labels)) {
+		return []*corev1.Namespace{}, nil
+	}
+
+	return []*corev1.Namespace{l.namespace}, nil
+}
+
+// Get returns the watched namespace, or a NotFound error for any other name.
+func (l *staticNamespaceLister) Get(name string) (*corev1.Namespace, error) {
+	if name != l.namespace.Name {
+		return nil, errors.NewNotFound(corev1.Resource("namespaces"), name)
+	}
+
+	return l.namespace, nil
+}
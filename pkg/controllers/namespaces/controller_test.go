@@ -0,0 +1,229 @@
+package namespaces
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newTestController returns a controller whose namespace informer cache holds
+// namespaces. The informer is not started, and the sync callback does nothing.
+func newTestController(t *testing.T, namespaces ...*corev1.Namespace) *Controller {
+	t.Helper()
+
+	factory := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	informer := factory.Core().V1().Namespaces()
+	for _, namespace := range namespaces {
+		if err := informer.Informer().GetIndexer().Add(namespace); err != nil {
+			t.Fatalf("adding namespace %s: %v", namespace.Name, err)
+		}
+	}
+
+	c := NewController("test", informer, func(*corev1.Namespace) error { return nil })
+	t.Cleanup(c.workqueue.ShutDown)
+
+	return c
+}
+
+// ownedServiceAccount returns a service account controlled by the namespace
+// it lives in.
+func ownedServiceAccount(namespace string) *corev1.ServiceAccount {
+	controller := true
+	return &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "argo-workflows",
+			Namespace: namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "v1", Kind: "Namespace", Name: namespace, Controller: &controller},
+			},
+		},
+	}
+}
+
+// queued drains the workqueue of c, returning the keys it held.
+func queued(c *Controller) []string {
+	keys := []string{}
+	for c.workqueue.Len() > 0 {
+		key, _ := c.workqueue.Get()
+		keys = append(keys, key.(string))
+		c.workqueue.Done(key)
+		c.workqueue.Forget(key)
+	}
+
+	return keys
+}
+
+func TestHandleObjectTombstone(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  interface{}
+		want []string
+	}{
+		{
+			name: "object",
+			obj:  ownedServiceAccount("team-a"),
+			want: []string{"team-a"},
+		},
+		{
+			name: "tombstone",
+			obj:  cache.DeletedFinalStateUnknown{Key: "team-a/argo-workflows", Obj: ownedServiceAccount("team-a")},
+			want: []string{"team-a"},
+		},
+		{
+			name: "tombstone of an unknown type",
+			obj:  cache.DeletedFinalStateUnknown{Key: "team-a/argo-workflows", Obj: "not an object"},
+			want: []string{},
+		},
+		{
+			name: "unknown type",
+			obj:  "not an object",
+			want: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestController(t, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}})
+
+			c.HandleObject(test.obj)
+			c.EnqueueObjectNamespace(test.obj)
+
+			if got := queued(c); !equalStrings(got, test.want) {
+				t.Errorf("got %v queued, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHandleObjectUnresolvableNamespace(t *testing.T) {
+	now := metav1.Now()
+	tests := []struct {
+		name      string
+		namespace *corev1.Namespace
+		want      []string
+	}{
+		{
+			name:      "active",
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+			want:      []string{"team-a"},
+		},
+		{
+			name:      "terminating phase",
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}, Status: corev1.NamespaceStatus{Phase: corev1.NamespaceTerminating}},
+			want:      []string{},
+		},
+		{
+			name:      "deletion timestamp",
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", DeletionTimestamp: &now}},
+			want:      []string{},
+		},
+		{
+			name: "gone",
+			want: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			namespaces := []*corev1.Namespace{}
+			if test.namespace != nil {
+				namespaces = append(namespaces, test.namespace)
+			}
+			c := newTestController(t, namespaces...)
+
+			c.HandleObject(ownedServiceAccount("team-a"))
+
+			if got := queued(c); !equalStrings(got, test.want) {
+				t.Errorf("got %v queued, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// TestNoConcurrentReconcileOfNamespace hammers a single namespace with adds
+// from many goroutines while several workers run, and checks the workqueue
+// alone never hands the namespace to two workers at once. Run with -race.
+func TestNoConcurrentReconcileOfNamespace(t *testing.T) {
+	const (
+		workers    = 8
+		enqueuers  = 8
+		reconciles = 50
+	)
+
+	var active, maxActive, total int32
+	c := NewNamespacedController("stress", "team-a", time.Hour, func(*corev1.Namespace) error {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			max := atomic.LoadInt32(&maxActive)
+			if n <= max || atomic.CompareAndSwapInt32(&maxActive, max, n) {
+				break
+			}
+		}
+
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&total, 1)
+		return nil
+	})
+
+	stopCh := make(chan struct{})
+	runDone := make(chan error)
+	go func() { runDone <- c.Run(workers, stopCh) }()
+
+	var enqueued sync.WaitGroup
+	stopEnqueue := make(chan struct{})
+	for i := 0; i < enqueuers; i++ {
+		enqueued.Add(1)
+		go func() {
+			defer enqueued.Done()
+			for {
+				select {
+				case <-stopEnqueue:
+					return
+				default:
+					c.workqueue.Add("team-a")
+				}
+			}
+		}()
+	}
+
+	deadline := time.After(10 * time.Second)
+	for atomic.LoadInt32(&total) < reconciles {
+		select {
+		case <-deadline:
+			t.Fatalf("got %d reconciles before the deadline, want %d", atomic.LoadInt32(&total), reconciles)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	close(stopEnqueue)
+	enqueued.Wait()
+	close(stopCh)
+	if err := <-runDone; err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	if max := atomic.LoadInt32(&maxActive); max != 1 {
+		t.Errorf("namespace reconciled by %d workers at once, want 1", max)
+	}
+}
@@ -0,0 +1,89 @@
+package namespaces
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// requeueBaseDelay is the delay before the first retry of a namespace
+	// which failed to reconcile. It doubles with every further failure.
+	requeueBaseDelay = time.Millisecond * 5
+
+	// requeueMaxDelay bounds the delay between retries of a namespace which
+	// keeps failing to reconcile.
+	requeueMaxDelay = time.Minute * 5
+
+	// requeueJitterFactor is the maximum fraction of the delay added at
+	// random, so namespaces failing for the same reason do not all retry at
+	// the same time.
+	requeueJitterFactor = 0.5
+)
+
+// newRateLimiter returns the rate limiter of the namespaces work queue. It is
+// the same as workqueue.DefaultControllerRateLimiter, except that the per-item
+// exponential backoff is jittered and capped at requeueMaxDelay.
+func newRateLimiter() workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		&jitteredExponentialRateLimiter{
+			baseDelay: requeueBaseDelay,
+			maxDelay:  requeueMaxDelay,
+			failures:  map[interface{}]int{},
+		},
+		// 10 qps, 100 bucket size. This is only for retry speed and its only
+		// the overall factor (not per item)
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+	)
+}
+
+// jitteredExponentialRateLimiter backs off exponentially per item, adding
+// random jitter to every delay.
+type jitteredExponentialRateLimiter struct {
+	baseDelay time.Duration
+	maxDelay  time.Duration
+
+	mu       sync.Mutex
+	failures map[interface{}]int
+}
+
+// When returns how long to wait before retrying item.
+func (r *jitteredExponentialRateLimiter) When(item interface{}) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	exp := r.failures[item]
+	r.failures[item] = exp + 1
+
+	backoff := float64(r.baseDelay) * math.Pow(2, float64(exp))
+	if backoff > float64(r.maxDelay) {
+		backoff = float64(r.maxDelay)
+	}
+
+	delay := wait.Jitter(time.Duration(backoff), requeueJitterFactor)
+	if delay > r.maxDelay {
+		delay = r.maxDelay
+	}
+
+	return delay
+}
+
+// NumRequeues returns how many times item has failed.
+func (r *jitteredExponentialRateLimiter) NumRequeues(item interface{}) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.failures[item]
+}
+
+// Forget resets the backoff of item.
+func (r *jitteredExponentialRateLimiter) Forget(item interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.failures, item)
+}
@@ -20,11 +20,16 @@ package serviceaccounts
 import (
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/gccloudone-aurora/argo-controller/pkg/debug"
+	"github.com/gccloudone-aurora/argo-controller/pkg/metrics"
+	"github.com/gccloudone-aurora/argo-controller/pkg/reconcile"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	corev1informers "k8s.io/client-go/informers/core/v1"
@@ -38,6 +43,9 @@ type serviceAccountSyncCallback func(*corev1.ServiceAccount) error
 
 // Controller struct for informers
 type Controller struct {
+	// name identifies the controller in its workqueue and metrics
+	name string
+
 	serviceAccountLister corev1listers.ServiceAccountLister
 	serviceAccountSynced cache.InformerSynced
 
@@ -50,19 +58,27 @@ type Controller struct {
 	// time, and makes it easy to ensure we are never processing the same item
 	// simultaneously in two different workers.
 	workqueue workqueue.RateLimitingInterface
+
+	// tracker records the reconciles in flight and the last reconcile of
+	// every key, for the debug endpoint
+	tracker *debug.Tracker
 }
 
 // NewController func for event handlers
 func NewController(
+	name string,
 	serviceAccountInformer corev1informers.ServiceAccountInformer,
 	sync serviceAccountSyncCallback,
 ) *Controller {
 	controller := &Controller{
+		name:                 name,
 		serviceAccountLister: serviceAccountInformer.Lister(),
 		serviceAccountSynced: serviceAccountInformer.Informer().HasSynced,
 		sync:                 sync,
-		workqueue:            workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "ServiceAccounts"),
+		workqueue:            workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), name),
+		tracker:              debug.NewTracker(),
 	}
+	debug.RegisterController(name, controller.Stats)
 
 	// Configure event handlers
 	klog.Info("configuring event handlers")
@@ -95,23 +111,45 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 	}
 
 	klog.Info("starting workers")
-	// Launch two workers to process ServiceAccount resources
+	// Launch threadiness workers to process ServiceAccount resources
+	var workers sync.WaitGroup
 	for i := 0; i < threadiness; i++ {
-		go wait.Until(c.runWorker, time.Second, stopCh)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			wait.Until(func() { c.runWorker(stopCh) }, time.Second, stopCh)
+		}()
 	}
 
 	klog.Info("Started workers")
 	<-stopCh
 	klog.Info("Shutting down workers")
 
+	// Wait for the items being processed, so that no write outlives Run
+	c.workqueue.ShutDown()
+	workers.Wait()
+
 	return nil
 }
 
+// Stats returns the state of the controller for the debug endpoint.
+func (c *Controller) Stats() debug.ControllerStats {
+	return c.tracker.Stats(c.workqueue.Len(), c.serviceAccountSynced())
+}
+
 // runWorker is a long-running function that will continually call the
 // processNextWorkItem function in order to read and process a message on the
-// workqueue.
-func (c *Controller) runWorker() {
-	for c.processNextWorkItem() {
+// workqueue, until stopCh is closed.
+func (c *Controller) runWorker(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+		if !c.processNextWorkItem() {
+			return
+		}
 	}
 }
 
@@ -150,7 +188,20 @@ func (c *Controller) processNextWorkItem() bool {
 		}
 		// Run the syncHandler, passing it the serviceaccount/name string of the
 		// ServiceAccount resource to be synced.
-		if err := c.syncHandler(key); err != nil {
+		c.tracker.Start(key)
+		err := c.syncHandler(key)
+		c.tracker.Done(key, err)
+		metrics.ObserveReconcile(c.name, err)
+		if err != nil {
+			// Permission errors are reported by the sync callback, so
+			// requeue them with a longer delay instead of logging and
+			// retrying in a tight loop.
+			if errors.IsForbidden(err) {
+				c.workqueue.Forget(obj)
+				c.workqueue.AddAfter(key, reconcile.ForbiddenRequeueDelay)
+				return nil
+			}
+
 			// Put the item back on the workqueue to handle any transient errors.
 			c.workqueue.AddRateLimited(key)
 			return fmt.Errorf("error syncing '%s': %s, requeuing", key, err.Error())
@@ -205,6 +256,22 @@ func (c *Controller) EnqueueServiceAccount(obj interface{}) {
 	c.workqueue.Add(key)
 }
 
+// EnqueueAll puts every service account known to the controller onto the
+// work queue. This is used to force a reconcile when an input other than the
+// service accounts themselves changes.
+func (c *Controller) EnqueueAll() {
+	serviceAccounts, err := c.serviceAccountLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	klog.V(4).Infof("Enqueueing %d service accounts", len(serviceAccounts))
+	for _, serviceAccount := range serviceAccounts {
+		c.EnqueueServiceAccount(serviceAccount)
+	}
+}
+
 // HandleObject will take any resource implementing metav1.Object and attempt
 // to find the ServiceAccount resource that 'owns' it. It does this by looking at the
 // objects metadata.ownerReferences field for an appropriate OwnerReference.
@@ -0,0 +1,181 @@
+// Package debug exposes the internal state of the controllers, such as their
+// workqueues and informer caches, for troubleshooting.
+package debug
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// Reconcile is the outcome of the last reconcile of a workqueue key.
+type Reconcile struct {
+	Time   time.Time `json:"time"`
+	Result string    `json:"result"`
+}
+
+// ControllerStats is a snapshot of the state of a controller. It only holds
+// workqueue keys and counts, never the content of the reconciled objects.
+type ControllerStats struct {
+	Synced         bool                 `json:"synced"`
+	QueueLength    int                  `json:"queueLength"`
+	InFlight       []string             `json:"inFlight"`
+	LastReconciles map[string]Reconcile `json:"lastReconciles"`
+}
+
+// StatsFunc returns the current stats of a controller.
+type StatsFunc func() ControllerStats
+
+// Tracker records the keys a controller is reconciling and the outcome of
+// the last reconcile of every key. It is safe for concurrent use.
+type Tracker struct {
+	mu             sync.Mutex
+	inFlight       map[string]bool
+	lastReconciles map[string]Reconcile
+}
+
+// NewTracker returns an empty Tracker.
+func NewTracker() *Tracker {
+	return &Tracker{
+		inFlight:       map[string]bool{},
+		lastReconciles: map[string]Reconcile{},
+	}
+}
+
+// InFlight reports whether key is being reconciled.
+func (t *Tracker) InFlight(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.inFlight[key]
+}
+
+// Start records that key is being reconciled.
+func (t *Tracker) Start(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.inFlight[key] = true
+}
+
+// Done records the outcome of the reconcile of key, which failed if err is
+// not nil. The error itself is not kept.
+func (t *Tracker) Done(key string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+
+	delete(t.inFlight, key)
+	t.lastReconciles[key] = Reconcile{Time: time.Now(), Result: result}
+}
+
+// Stats returns the stats of the controller owning the tracker, given the
+// length of its workqueue and whether its informers have synced.
+func (t *Tracker) Stats(queueLength int, synced bool) ControllerStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := ControllerStats{
+		Synced:         synced,
+		QueueLength:    queueLength,
+		InFlight:       []string{},
+		LastReconciles: map[string]Reconcile{},
+	}
+	for key := range t.inFlight {
+		stats.InFlight = append(stats.InFlight, key)
+	}
+	for key, reconcile := range t.lastReconciles {
+		stats.LastReconciles[key] = reconcile
+	}
+
+	return stats
+}
+
+var registry = struct {
+	mu          sync.RWMutex
+	controllers map[string]StatsFunc
+	informers   map[string]cache.InformerSynced
+	enqueuers   map[string]EnqueueFunc
+}{
+	controllers: map[string]StatsFunc{},
+	informers:   map[string]cache.InformerSynced{},
+	enqueuers:   map[string]EnqueueFunc{},
+}
+
+// RegisterController adds a controller to the debug endpoint.
+func RegisterController(name string, stats StatsFunc) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.controllers[name] = stats
+}
+
+// RegisterInformer adds the sync status of an informer to the debug
+// endpoint. Informers shared by several controllers may be registered more
+// than once under the same name.
+func RegisterInformer(name string, synced cache.InformerSynced) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.informers[name] = synced
+}
+
+// UnsyncedInformers returns the names of the registered informers which have
+// not synced, sorted, so that a startup which times out can name them.
+func UnsyncedInformers() []string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	names := []string{}
+	for name, synced := range registry.informers {
+		if !synced() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// state is the document served by Handler.
+type state struct {
+	Controllers map[string]ControllerStats `json:"controllers"`
+	Informers   map[string]bool            `json:"informers"`
+}
+
+// Handler returns an http.Handler serving the state of every registered
+// controller and informer as JSON.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registry.mu.RLock()
+		s := state{
+			Controllers: map[string]ControllerStats{},
+			Informers:   map[string]bool{},
+		}
+		for name, stats := range registry.controllers {
+			controllerStats := stats()
+			sort.Strings(controllerStats.InFlight)
+			s.Controllers[name] = controllerStats
+		}
+		for name, synced := range registry.informers {
+			s.Informers[name] = synced()
+		}
+		registry.mu.RUnlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(s); err != nil {
+			klog.Errorf("error writing debug state: %v", err)
+		}
+	})
+}
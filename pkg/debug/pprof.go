@@ -0,0 +1,35 @@
+package debug
+
+import (
+	"context"
+	"net/http"
+	"net/http/pprof"
+
+	"k8s.io/klog"
+)
+
+// ServePprof serves the Go runtime profiles on /debug/pprof/ at addr until
+// stopCh is closed. The profiles are served on their own listener, so that
+// they are never exposed alongside the metrics.
+func ServePprof(addr string, stopCh <-chan struct{}) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-stopCh
+		server.Shutdown(context.Background())
+	}()
+
+	go func() {
+		klog.Infof("serving pprof on %s", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			klog.Errorf("error serving pprof: %v", err)
+		}
+	}()
+}
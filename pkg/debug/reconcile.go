@@ -0,0 +1,105 @@
+package debug
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// ErrUnknownKey is returned by an EnqueueFunc for a key the controller does
+// not reconcile, such as a namespace which does not exist.
+var ErrUnknownKey = errors.New("unknown key")
+
+// ErrNotRunning is returned by an EnqueueFunc when the controller is not
+// running, such as after losing leadership.
+var ErrNotRunning = errors.New("controller not running")
+
+// EnqueueResult is the outcome of an on-demand reconcile. Result is queued,
+// already-queued, or in-flight when the key is being reconciled and will be
+// reconciled again once done. Position is the approximate position of a
+// newly queued key in the workqueue, counting from 1.
+type EnqueueResult struct {
+	Controller  string `json:"controller"`
+	Key         string `json:"key"`
+	Result      string `json:"result"`
+	Position    int    `json:"position,omitempty"`
+	QueueLength int    `json:"queueLength"`
+}
+
+// EnqueueFunc puts key onto the workqueue of a controller.
+type EnqueueFunc func(key string) (EnqueueResult, error)
+
+// RegisterEnqueuer adds a controller to the on-demand reconcile endpoint,
+// replacing any controller of the same name.
+func RegisterEnqueuer(name string, enqueue EnqueueFunc) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.enqueuers[name] = enqueue
+}
+
+// ReconcileHandler returns an http.Handler enqueueing the namespace of a
+// POST /reconcile?namespace=NAME request in the workflows controller, or in
+// the controller named by the controller parameter. Requests must carry
+// token as a bearer token.
+func ReconcileHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		namespace := r.URL.Query().Get("namespace")
+		if namespace == "" {
+			http.Error(w, "namespace is required", http.StatusBadRequest)
+			return
+		}
+		controller := r.URL.Query().Get("controller")
+		if controller == "" {
+			controller = "workflows"
+		}
+
+		registry.mu.RLock()
+		enqueue, ok := registry.enqueuers[controller]
+		registry.mu.RUnlock()
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown controller %q", controller), http.StatusNotFound)
+			return
+		}
+
+		result, err := enqueue(namespace)
+		switch {
+		case errors.Is(err, ErrUnknownKey):
+			http.Error(w, fmt.Sprintf("namespace %q is not reconciled by controller %q", namespace, controller), http.StatusNotFound)
+			return
+		case errors.Is(err, ErrNotRunning):
+			http.Error(w, fmt.Sprintf("controller %q is not running", controller), http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		result.Controller = controller
+		klog.Infof("on-demand reconcile of %s by controller %s: %s", namespace, controller, result.Result)
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			klog.Errorf("error writing reconcile result: %v", err)
+		}
+	})
+}
@@ -0,0 +1,235 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/gccloudone-aurora/argo-controller/pkg/reconcile"
+	"k8s.io/klog"
+)
+
+var (
+	// ProvisionedGroups is the number of admin groups provisioned with
+	// Argo Workflows user interface access in each namespace.
+	ProvisionedGroups = NewGaugeVec(
+		"argo_controller_provisioned_groups",
+		"Number of groups provisioned with Argo Workflows user interface access, by namespace.",
+		"namespace",
+	)
+
+	// ClusterProvisionedGroups is the number of provisioned groups summed
+	// across all namespaces.
+	ClusterProvisionedGroups = NewGaugeVec(
+		"argo_controller_cluster_provisioned_groups",
+		"Number of groups provisioned with Argo Workflows user interface access across all namespaces.",
+	)
+
+	// FailingNamespaces is the number of namespaces whose last reconcile
+	// failed and which are waiting to be retried, by controller.
+	FailingNamespaces = NewGaugeVec(
+		"argo_controller_failing_namespaces",
+		"Number of namespaces whose last reconcile failed and which are backed off waiting for a retry, by controller.",
+		"controller",
+	)
+
+	// Recreated is the number of objects created again because they were
+	// deleted between being listed and being updated, by kind.
+	Recreated = NewCounterVec(
+		"argo_controller_recreated_total",
+		"Number of objects recreated after being deleted while they were being updated, by kind.",
+		"kind",
+	)
+
+	// UnmanagedSkipped is the number of times an object was left alone
+	// because it exists but is not managed by the controller, by kind.
+	UnmanagedSkipped = NewCounterVec(
+		"argo_controller_unmanaged_skipped_total",
+		"Number of times an existing object not managed by the controller was left alone instead of being updated, by kind.",
+		"kind",
+	)
+
+	// Frozen is 1 while the controller is frozen, and 0 otherwise.
+	Frozen = NewGaugeVec(
+		"argo_controller_frozen",
+		"Whether the controller is frozen, sending every write as a dry run (1) or not (0).",
+	)
+
+	// FirstReconcileComplete is 1 once every namespace has been reconciled
+	// successfully since the controller started leading, by controller.
+	FirstReconcileComplete = NewGaugeVec(
+		"argo_controller_first_reconcile_complete",
+		"Whether every namespace has been reconciled successfully since the controller started (1) or not yet (0), by controller.",
+		"controller",
+	)
+
+	// WebhookNotifications is the number of webhook notifications, by
+	// result.
+	WebhookNotifications = NewCounterVec(
+		"argo_controller_webhook_notifications_total",
+		"Number of webhook notifications, by result (delivered, failed once the retries are exhausted, or dropped because the queue was full).",
+		"result",
+	)
+
+	// StaleObjects is the number of objects managed by the controller but
+	// no longer generated, by namespace and kind.
+	StaleObjects = NewGaugeVec(
+		"argo_controller_stale_objects",
+		"Number of objects managed by the controller but no longer generated, such as after a name template change, by namespace and kind.",
+		"namespace", "kind",
+	)
+
+	// SecretWrites is the number of storage and token secrets created or
+	// updated, by namespace and type.
+	SecretWrites = NewCounterVec(
+		"argo_controller_secret_writes_total",
+		"Number of storage and token secrets created or updated, by namespace and type (storage or token).",
+		"namespace", "type",
+	)
+
+	// Reconciles is the number of reconciles, by controller and result.
+	Reconciles = NewCounterVec(
+		"argo_controller_reconciles_total",
+		"Number of reconciles, by controller and result (success or error).",
+		"controller", "result",
+	)
+
+	// ReconcileErrors is the number of failed reconciles, by controller,
+	// phase and reason.
+	ReconcileErrors = NewCounterVec(
+		"argo_controller_reconcile_errors_total",
+		"Number of failed reconciles, by controller, phase (cleanup, generate or apply) and reason (such as Forbidden, Conflict or InvalidName).",
+		"controller", "phase", "reason",
+	)
+
+	// NamespacesSkipped is the number of reconciles which left a namespace
+	// without user interface access, by reason.
+	NamespacesSkipped = NewCounterVec(
+		"argo_controller_namespaces_skipped_total",
+		"Number of reconciles which did not give a namespace user interface access, by reason (not_allowlisted, self_namespace, terminating, no_admins_role_binding or ui_access_disabled).",
+		"reason",
+	)
+)
+
+// SkipReason is why a reconcile did not give a namespace user interface
+// access. The values are stable, as they are used as a metric label.
+type SkipReason string
+
+const (
+	// SkipNotAllowlisted is reported for namespaces missing from the
+	// namespace allowlist, which are not reconciled at all.
+	SkipNotAllowlisted SkipReason = "not_allowlisted"
+
+	// SkipSelfNamespace is reported for the namespace the controller runs
+	// in, which is not reconciled under --skip-self-namespace.
+	SkipSelfNamespace SkipReason = "self_namespace"
+
+	// SkipTerminating is reported for terminating namespaces, which are not
+	// reconciled beyond releasing their finalizers.
+	SkipTerminating SkipReason = "terminating"
+
+	// SkipNoAdminsRoleBinding is reported for namespaces without a namespace
+	// admins role binding, which get no per-group objects.
+	SkipNoAdminsRoleBinding SkipReason = "no_admins_role_binding"
+
+	// SkipUIAccessDisabled is reported for namespaces with user interface
+	// access disabled, which get no per-group objects.
+	SkipUIAccessDisabled SkipReason = "ui_access_disabled"
+)
+
+// failingNamespaces is the set of namespaces counted by FailingNamespaces,
+// by controller.
+var failingNamespaces = struct {
+	mu         sync.Mutex
+	namespaces map[string]map[string]bool
+}{namespaces: map[string]map[string]bool{}}
+
+// provisionedGroups is the number of groups counted by ProvisionedGroups,
+// by namespace, from which ClusterProvisionedGroups is summed.
+var provisionedGroups = struct {
+	mu         sync.Mutex
+	namespaces map[string]int
+}{namespaces: map[string]int{}}
+
+// SetProvisionedGroups records the number of groups provisioned in a
+// namespace and updates the cluster total.
+func SetProvisionedGroups(namespace string, count int) {
+	provisionedGroups.mu.Lock()
+	defer provisionedGroups.mu.Unlock()
+
+	provisionedGroups.namespaces[namespace] = count
+	ProvisionedGroups.Set(float64(count), namespace)
+	setClusterProvisionedGroups()
+}
+
+// DeleteProvisionedGroups removes the series of a namespace which is gone or
+// no longer provisioned, and updates the cluster total.
+func DeleteProvisionedGroups(namespace string) {
+	provisionedGroups.mu.Lock()
+	defer provisionedGroups.mu.Unlock()
+
+	delete(provisionedGroups.namespaces, namespace)
+	ProvisionedGroups.Delete(namespace)
+	setClusterProvisionedGroups()
+}
+
+// setClusterProvisionedGroups sets ClusterProvisionedGroups to the sum of
+// the namespaces. provisionedGroups.mu must be held.
+func setClusterProvisionedGroups() {
+	total := 0
+	for _, count := range provisionedGroups.namespaces {
+		total += count
+	}
+	ClusterProvisionedGroups.Set(float64(total))
+}
+
+// SetNamespaceFailing records whether the last reconcile of a namespace by
+// controller failed and updates FailingNamespaces.
+func SetNamespaceFailing(controller, namespace string, failing bool) {
+	failingNamespaces.mu.Lock()
+	defer failingNamespaces.mu.Unlock()
+
+	namespaces, ok := failingNamespaces.namespaces[controller]
+	if !ok {
+		namespaces = map[string]bool{}
+		failingNamespaces.namespaces[controller] = namespaces
+	}
+
+	if failing {
+		namespaces[namespace] = true
+	} else {
+		delete(namespaces, namespace)
+	}
+	FailingNamespaces.Set(float64(len(namespaces)), controller)
+}
+
+// ObserveReconcile counts a reconcile by controller, which failed if err is
+// not nil. Failures are also counted by phase and reason.
+func ObserveReconcile(controller string, err error) {
+	if err != nil {
+		Reconciles.Inc(controller, "error")
+		ReconcileErrors.Inc(controller, string(reconcile.PhaseOf(err)), reconcile.Reason(err))
+		return
+	}
+	Reconciles.Inc(controller, "success")
+}
+
+// Serve exposes the metrics on /metrics at addr until stopCh is closed,
+// alongside any other handlers already registered on mux.
+func Serve(addr string, mux *http.ServeMux, stopCh <-chan struct{}) {
+	mux.Handle("/metrics", Handler())
+
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-stopCh
+		server.Shutdown(context.Background())
+	}()
+
+	go func() {
+		klog.Infof("serving metrics on %s", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			klog.Errorf("error serving metrics: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProvisionedGroups(t *testing.T) {
+	SetProvisionedGroups("team-a", 2)
+	SetProvisionedGroups("team-b", 3)
+	if got := testutil.ToFloat64(ClusterProvisionedGroups.vec); got != 5 {
+		t.Errorf("got cluster total %v, want 5", got)
+	}
+
+	DeleteProvisionedGroups("team-a")
+	if got := testutil.ToFloat64(ClusterProvisionedGroups.vec); got != 3 {
+		t.Errorf("got cluster total %v after deleting team-a, want 3", got)
+	}
+	if got := testutil.CollectAndCount(ProvisionedGroups.vec); got != 1 {
+		t.Errorf("got %d namespace series after deleting team-a, want 1", got)
+	}
+
+	// Deleting a namespace which was never provisioned is a no-op
+	DeleteProvisionedGroups("team-c")
+	if got := testutil.ToFloat64(ClusterProvisionedGroups.vec); got != 3 {
+		t.Errorf("got cluster total %v after deleting team-c, want 3", got)
+	}
+}
@@ -0,0 +1,71 @@
+// Package metrics exposes controller metrics in the Prometheus text
+// exposition format.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// registry holds every metric family exposed by the process.
+var registry = prometheus.NewRegistry()
+
+// Handler returns an http.Handler serving all registered metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// GaugeVec is a gauge partitioned by a set of labels.
+type GaugeVec struct {
+	vec *prometheus.GaugeVec
+}
+
+// NewGaugeVec creates and registers a GaugeVec.
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labelNames)
+	registry.MustRegister(vec)
+
+	return &GaugeVec{vec: vec}
+}
+
+// Set sets the gauge for the given label values.
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Set(value)
+}
+
+// Add adds delta to the gauge for the given label values.
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Add(delta)
+}
+
+// Delete removes the gauge for the given label values.
+func (g *GaugeVec) Delete(labelValues ...string) {
+	g.vec.DeleteLabelValues(labelValues...)
+}
+
+// CounterVec is a monotonically increasing counter partitioned by a set of
+// labels.
+type CounterVec struct {
+	vec *prometheus.CounterVec
+}
+
+// NewCounterVec creates and registers a CounterVec.
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	vec := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labelNames)
+	registry.MustRegister(vec)
+
+	return &CounterVec{vec: vec}
+}
+
+// Inc increments the counter for the given label values by one.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.vec.WithLabelValues(labelValues...).Inc()
+}
+
+// Add increments the counter for the given label values by delta, which
+// must not be negative.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.vec.WithLabelValues(labelValues...).Add(delta)
+}
@@ -0,0 +1,114 @@
+// Package reconcile defines the errors returned by reconciles, so that
+// failures can be categorized in metrics and events.
+package reconcile
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// Phase is the step of a reconcile which failed.
+type Phase string
+
+const (
+	// PhaseCleanup removes objects which are no longer needed.
+	PhaseCleanup Phase = "cleanup"
+
+	// PhaseGenerate builds the desired objects.
+	PhaseGenerate Phase = "generate"
+
+	// PhaseApply creates or updates the desired objects.
+	PhaseApply Phase = "apply"
+
+	// PhaseUnknown is reported for errors which are not an Error.
+	PhaseUnknown Phase = "unknown"
+)
+
+// ErrInvalidName is wrapped by the errors of objects whose name is invalid.
+var ErrInvalidName = errors.New("invalid name")
+
+// Error is a reconcile failure, recording the phase and the kind of resource
+// it happened with. The underlying error, such as an API error, can be
+// recovered with errors.As.
+type Error struct {
+	Phase    Phase
+	Resource string
+	Err      error
+}
+
+// Wrap returns err as an Error of the given phase and resource kind, or nil
+// if err is nil.
+func Wrap(phase Phase, resource string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &Error{Phase: phase, Resource: resource, Err: err}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Phase, e.Resource, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// PhaseOf returns the phase err happened in, or PhaseUnknown.
+func PhaseOf(err error) Phase {
+	var reconcileErr *Error
+	if errors.As(first(err), &reconcileErr) {
+		return reconcileErr.Phase
+	}
+
+	return PhaseUnknown
+}
+
+// Reason categorizes err, for use as a metric label or event reason: the
+// reason of the underlying API error, such as Forbidden or Conflict,
+// InvalidName for invalid object names, or Unknown.
+func Reason(err error) string {
+	err = first(err)
+	if errors.Is(err, ErrInvalidName) {
+		return "InvalidName"
+	}
+
+	return string(apierrors.ReasonForError(err))
+}
+
+// ForbiddenRequeueDelay is how long the controllers wait before retrying an
+// item whose sync failed because they lack permission. Missing RBAC will not
+// fix itself within the default rate limiter's short backoff.
+const ForbiddenRequeueDelay = time.Minute * 5
+
+// IsForbidden reports whether err is a Forbidden API error or, for an
+// aggregate as returned when several objects are applied in parallel,
+// whether any of its errors is. apierrors.IsForbidden does not look into
+// aggregates.
+func IsForbidden(err error) bool {
+	if aggregate, ok := err.(utilerrors.Aggregate); ok {
+		for _, err := range aggregate.Errors() {
+			if IsForbidden(err) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return apierrors.IsForbidden(err)
+}
+
+// first returns the first error of an aggregate, as returned when several
+// objects are applied in parallel, and err itself otherwise.
+func first(err error) error {
+	if aggregate, ok := err.(utilerrors.Aggregate); ok && len(aggregate.Errors()) > 0 {
+		return aggregate.Errors()[0]
+	}
+
+	return err
+}
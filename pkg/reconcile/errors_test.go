@@ -0,0 +1,37 @@
+package reconcile
+
+import (
+	"errors"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+func TestIsForbidden(t *testing.T) {
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "secret", errors.New("denied"))
+	conflict := apierrors.NewConflict(schema.GroupResource{Resource: "secrets"}, "secret", errors.New("conflict"))
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "forbidden", err: forbidden, want: true},
+		{name: "wrapped forbidden", err: Wrap(PhaseApply, "Secret", forbidden), want: true},
+		{name: "conflict", err: conflict, want: false},
+		{name: "aggregate of forbidden", err: utilerrors.NewAggregate([]error{Wrap(PhaseApply, "Secret", forbidden), Wrap(PhaseApply, "Secret", forbidden)}), want: true},
+		{name: "aggregate with one forbidden", err: utilerrors.NewAggregate([]error{conflict, forbidden}), want: true},
+		{name: "aggregate without forbidden", err: utilerrors.NewAggregate([]error{conflict, conflict}), want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IsForbidden(test.err); got != test.want {
+				t.Errorf("IsForbidden(%v) = %v, want %v", test.err, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package secretprovider
+
+import (
+	"context"
+	"os"
+)
+
+const (
+	// AccountNameEnv is the environment variable holding the storage account name.
+	AccountNameEnv = "ARGO_STORAGE_ACCOUNT_NAME"
+
+	// AccountKeyEnv is the environment variable holding the storage account key.
+	AccountKeyEnv = "ARGO_STORAGE_ACCOUNT_KEY"
+)
+
+// envProvider reads the storage account credentials from the environment of
+// the controller. Every namespace receives the same credentials.
+type envProvider struct {
+	keys        Keys
+	userEnv     string
+	passwordEnv string
+}
+
+// NewEnv returns a provider reading the storage account credentials from the
+// ARGO_STORAGE_ACCOUNT_NAME and ARGO_STORAGE_ACCOUNT_KEY environment variables.
+func NewEnv(keys Keys) Provider {
+	return NewEnvVars(keys, AccountNameEnv, AccountKeyEnv)
+}
+
+// NewEnvVars returns a provider reading the storage account credentials from
+// the userEnv and passwordEnv environment variables.
+func NewEnvVars(keys Keys, userEnv, passwordEnv string) Provider {
+	return &envProvider{keys: keys, userEnv: userEnv, passwordEnv: passwordEnv}
+}
+
+func (p *envProvider) StorageSecretData(ctx context.Context, namespace string) (map[string][]byte, error) {
+	return map[string][]byte{
+		p.keys.User:     []byte(os.Getenv(p.userEnv)),
+		p.keys.Password: []byte(os.Getenv(p.passwordEnv)),
+	}, nil
+}
@@ -0,0 +1,36 @@
+package secretprovider
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// fileProvider reads the storage account credentials from files named after
+// the data keys, such as a mounted Secret or a directory written by a secrets
+// store CSI driver. The files are read on every reconcile, so rotated
+// credentials are picked up without a restart.
+type fileProvider struct {
+	keys Keys
+	dir  string
+}
+
+// NewFile returns a provider reading the storage account credentials from
+// the files dir/<user key> and dir/<password key>.
+func NewFile(keys Keys, dir string) Provider {
+	return &fileProvider{keys: keys, dir: dir}
+}
+
+func (p *fileProvider) StorageSecretData(ctx context.Context, namespace string) (map[string][]byte, error) {
+	data := map[string][]byte{}
+	for _, key := range []string{p.keys.User, p.keys.Password} {
+		value, err := ioutil.ReadFile(filepath.Join(p.dir, key))
+		if err != nil {
+			return nil, fmt.Errorf("error reading storage secret key %q: %w", key, err)
+		}
+		data[key] = value
+	}
+
+	return data, nil
+}
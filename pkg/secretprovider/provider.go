@@ -0,0 +1,43 @@
+// Package secretprovider supplies the credentials written to the storage
+// secret of each namespace. New backends, such as Azure Key Vault or
+// HashiCorp Vault, are added by implementing Provider and registering a name
+// for it in New.
+package secretprovider
+
+import (
+	"context"
+	"fmt"
+)
+
+// Provider returns the data of the storage secret of a namespace.
+type Provider interface {
+	// StorageSecretData returns the storage account credentials for the
+	// namespace, keyed by the data keys of the storage secret.
+	StorageSecretData(ctx context.Context, namespace string) (map[string][]byte, error)
+}
+
+// Keys are the data keys of the storage secret.
+type Keys struct {
+	User     string
+	Password string
+}
+
+// Names lists the providers which can be selected with New.
+var Names = []string{"env", "file", "secret"}
+
+// New returns the provider with the given name. dir is only used by the file
+// provider. The secret provider reads an informer cache, which is not
+// available yet when the flags are validated, so New returns nil for it and
+// it is created with NewSecret instead.
+func New(name string, keys Keys, dir string) (Provider, error) {
+	switch name {
+	case "env":
+		return NewEnv(keys), nil
+	case "file":
+		return NewFile(keys, dir), nil
+	case "secret":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown secret provider %q", name)
+	}
+}
@@ -0,0 +1,95 @@
+package secretprovider
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var testKeys = Keys{User: "username", Password: "password"}
+
+// checkData fails the test unless data holds exactly want.
+func checkData(t *testing.T, data map[string][]byte, want map[string]string) {
+	t.Helper()
+
+	if len(data) != len(want) {
+		t.Errorf("got %d keys, want %d", len(data), len(want))
+	}
+	for key, value := range want {
+		if got := string(data[key]); got != value {
+			t.Errorf("key %q: got %q, want %q", key, got, value)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "env"},
+		{name: "file"},
+		{name: "secret", wantNil: true},
+		{name: "vault", wantNil: true, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider, err := New(test.name, testKeys, t.TempDir())
+			if (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %t", err, test.wantErr)
+			}
+			if (provider == nil) != test.wantNil {
+				t.Errorf("got provider %v, want nil %t", provider, test.wantNil)
+			}
+		})
+	}
+}
+
+func TestEnv(t *testing.T) {
+	t.Setenv(AccountNameEnv, "account")
+	t.Setenv(AccountKeyEnv, "secret-key")
+
+	data, err := NewEnv(testKeys).StorageSecretData(context.Background(), "team-a")
+	if err != nil {
+		t.Fatalf("StorageSecretData: %v", err)
+	}
+
+	checkData(t, data, map[string]string{"username": "account", "password": "secret-key"})
+}
+
+func TestEnvVars(t *testing.T) {
+	t.Setenv("TEAM_USER", "account")
+	t.Setenv("TEAM_PASSWORD", "secret-key")
+
+	data, err := NewEnvVars(testKeys, "TEAM_USER", "TEAM_PASSWORD").StorageSecretData(context.Background(), "team-a")
+	if err != nil {
+		t.Fatalf("StorageSecretData: %v", err)
+	}
+
+	checkData(t, data, map[string]string{"username": "account", "password": "secret-key"})
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "username"), []byte("account"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The password file is missing
+	if _, err := NewFile(testKeys, dir).StorageSecretData(context.Background(), "team-a"); err == nil {
+		t.Errorf("got no error, want an error for the missing password file")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "password"), []byte("secret-key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	data, err := NewFile(testKeys, dir).StorageSecretData(context.Background(), "team-a")
+	if err != nil {
+		t.Fatalf("StorageSecretData: %v", err)
+	}
+
+	checkData(t, data, map[string]string{"username": "account", "password": "secret-key"})
+}
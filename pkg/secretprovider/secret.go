@@ -0,0 +1,57 @@
+package secretprovider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/labels"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog"
+)
+
+// secretProvider copies the storage account credentials from a central
+// Secret, discovered by label in a source namespace. The Secret is read from
+// an informer cache on every reconcile, so rotated credentials are picked up
+// without a restart.
+type secretProvider struct {
+	keys      Keys
+	lister    corev1listers.SecretLister
+	namespace string
+	selector  labels.Selector
+}
+
+// NewSecret returns a provider copying the storage account credentials from
+// the Secret of namespace matching selector, read through lister. When
+// several Secrets match, the first by name is used, so that every namespace
+// gets the same credentials whatever the order of the cache.
+func NewSecret(keys Keys, lister corev1listers.SecretLister, namespace string, selector labels.Selector) Provider {
+	return &secretProvider{keys: keys, lister: lister, namespace: namespace, selector: selector}
+}
+
+func (p *secretProvider) StorageSecretData(ctx context.Context, namespace string) (map[string][]byte, error) {
+	secrets, err := p.lister.Secrets(p.namespace).List(p.selector)
+	if err != nil {
+		return nil, err
+	}
+	if len(secrets) == 0 {
+		return nil, fmt.Errorf("no storage credentials secret matching %q in namespace %s", p.selector, p.namespace)
+	}
+
+	sort.Slice(secrets, func(i, j int) bool { return secrets[i].Name < secrets[j].Name })
+	source := secrets[0]
+	if len(secrets) > 1 {
+		klog.V(2).Infof("%d storage credentials secrets match %q in namespace %s, using %s", len(secrets), p.selector, p.namespace, source.Name)
+	}
+
+	data := map[string][]byte{}
+	for _, key := range []string{p.keys.User, p.keys.Password} {
+		value, ok := source.Data[key]
+		if !ok {
+			return nil, fmt.Errorf("storage credentials secret %s/%s has no key %q", source.Namespace, source.Name, key)
+		}
+		data[key] = value
+	}
+
+	return data, nil
+}
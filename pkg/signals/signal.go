@@ -17,27 +17,57 @@ limitations under the License.
 package signals
 
 import (
+	"context"
 	"os"
 	"os/signal"
 )
 
-var onlyOneSignalHandler = make(chan struct{})
-
-// SetupSignalHandler registered for SIGTERM and SIGINT. A stop channel is returned
-// which is closed on one of these signals. If a second signal is caught, the program
-// is terminated with exit code 1.
-func SetupSignalHandler() (stopCh <-chan struct{}) {
-	close(onlyOneSignalHandler) // panics when called twice
+// SetupSignalHandler registers for the given signals, or SIGTERM and SIGINT
+// if none are given. A stop channel is returned which is closed on one of
+// these signals, along with a context cancelled at the same time. If a second
+// signal is caught, the program is terminated with exit code 1. Every call
+// registers its own handler, so it may be called more than once, such as
+// from tests.
+func SetupSignalHandler(signals ...os.Signal) (stopCh <-chan struct{}, ctx context.Context) {
+	if len(signals) == 0 {
+		signals = shutdownSignals
+	}
 
 	stop := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
 	c := make(chan os.Signal, 2)
-	signal.Notify(c, shutdownSignals...)
+	signal.Notify(c, signals...)
 	go func() {
 		<-c
 		close(stop)
+		cancel()
 		<-c
 		os.Exit(1) // second signal. Exit directly.
 	}()
 
-	return stop
+	return stop, ctx
+}
+
+// SetupReloadHandler registers for SIGHUP. The returned channel receives a
+// value on each of these signals; signals caught while a value is still
+// pending are merged into it. On platforms without SIGHUP the channel never
+// receives.
+func SetupReloadHandler() (reloadCh <-chan struct{}) {
+	reload := make(chan struct{}, 1)
+	if len(reloadSignals) == 0 {
+		return reload
+	}
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, reloadSignals...)
+	go func() {
+		for range c {
+			select {
+			case reload <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return reload
 }
@@ -0,0 +1,67 @@
+// +build !windows
+
+package signals
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// waitClosed fails the test unless stopCh is closed within a few seconds.
+func waitClosed(t *testing.T, name string, stopCh <-chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-stopCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s: stop channel not closed", name)
+	}
+}
+
+func TestSetupSignalHandlerCustomSignals(t *testing.T) {
+	// Called twice, as tests setting up several controllers do
+	firstStopCh, firstCtx := SetupSignalHandler(syscall.SIGUSR1)
+	secondStopCh, secondCtx := SetupSignalHandler(syscall.SIGUSR1)
+
+	select {
+	case <-firstStopCh:
+		t.Fatal("stop channel closed before any signal")
+	default:
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+
+	waitClosed(t, "first handler", firstStopCh)
+	waitClosed(t, "second handler", secondStopCh)
+	if firstCtx.Err() == nil || secondCtx.Err() == nil {
+		t.Errorf("got contexts not cancelled, want both cancelled")
+	}
+}
+
+// TestSecondSignalExits runs itself in a subprocess, which catches a first
+// signal and must then exit with code 1 on the second.
+func TestSecondSignalExits(t *testing.T) {
+	if os.Getenv("SIGNALS_TEST_SECOND_SIGNAL") == "1" {
+		stopCh, _ := SetupSignalHandler(syscall.SIGUSR2)
+		syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+		<-stopCh
+		syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+		time.Sleep(5 * time.Second)
+		os.Exit(0)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSecondSignalExits$")
+	cmd.Env = append(os.Environ(), "SIGNALS_TEST_SECOND_SIGNAL=1")
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Errorf("got %v, want exit status 1", err)
+	}
+}
@@ -0,0 +1,135 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"k8s.io/klog"
+)
+
+// defaultServiceName is the service name of the spans, unless set by
+// OTEL_SERVICE_NAME or OTEL_RESOURCE_ATTRIBUTES.
+const defaultServiceName = "argo-controller"
+
+// shutdownTimeout bounds the export of the spans left when stopping.
+const shutdownTimeout = time.Second * 10
+
+// Setup enables tracing and starts exporting spans to the given OTLP
+// endpoint (e.g. http://otel-collector:4318) until stopCh is closed. The
+// standard OTEL_* environment variables are respected, such as
+// OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_EXPORTER_OTLP_PROTOCOL,
+// OTEL_EXPORTER_OTLP_HEADERS, OTEL_TRACES_SAMPLER, OTEL_SERVICE_NAME and
+// OTEL_SDK_DISABLED; the endpoint argument takes precedence over the
+// environment. If no endpoint is configured, or the configuration is
+// invalid, tracing stays disabled.
+func Setup(endpoint string, stopCh <-chan struct{}) {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return
+	}
+	if endpoint == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return
+	}
+
+	ctx := context.Background()
+	exporter, err := newExporter(ctx, endpoint)
+	if err != nil {
+		klog.Errorf("error setting up tracing, traces will not be exported: %v", err)
+		return
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(attribute.String("service.name", defaultServiceName)),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		klog.Errorf("error setting up tracing, traces will not be exported: %v", err)
+		return
+	}
+
+	// Export failures are logged; tracing must never hold up reconciliation
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		klog.Errorf("error exporting traces: %v", err)
+	}))
+
+	// The sampler is configured by OTEL_TRACES_SAMPLER
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	tracer = provider.Tracer(instrumentationName)
+
+	klog.Infof("exporting traces using OTLP over %s", tracesProtocol())
+	go func() {
+		<-stopCh
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			klog.Errorf("error exporting traces: %v", err)
+		}
+	}()
+}
+
+// newExporter returns the OTLP exporter of the protocol set by
+// OTEL_EXPORTER_OTLP_TRACES_PROTOCOL or OTEL_EXPORTER_OTLP_PROTOCOL, by
+// default http/protobuf. endpoint, if set, overrides the endpoint of the
+// environment.
+func newExporter(ctx context.Context, endpoint string) (*otlptrace.Exporter, error) {
+	var u *url.URL
+	if endpoint != "" {
+		var err error
+		if u, err = url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("--otel-endpoint: must be an absolute http or https URL, got %q", endpoint)
+		}
+	}
+
+	switch protocol := tracesProtocol(); protocol {
+	case "http/protobuf":
+		options := []otlptracehttp.Option{}
+		if u != nil {
+			options = append(options,
+				otlptracehttp.WithEndpoint(u.Host),
+				otlptracehttp.WithURLPath(strings.TrimSuffix(u.Path, "/")+"/v1/traces"),
+			)
+			if u.Scheme == "http" {
+				options = append(options, otlptracehttp.WithInsecure())
+			}
+		}
+		return otlptracehttp.New(ctx, options...)
+	case "grpc":
+		options := []otlptracegrpc.Option{}
+		if u != nil {
+			options = append(options, otlptracegrpc.WithEndpoint(u.Host))
+			if u.Scheme == "http" {
+				options = append(options, otlptracegrpc.WithInsecure())
+			}
+		}
+		return otlptracegrpc.New(ctx, options...)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q, must be http/protobuf or grpc", protocol)
+	}
+}
+
+// tracesProtocol returns the OTLP protocol spans are exported with.
+func tracesProtocol() string {
+	if protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL"); protocol != "" {
+		return protocol
+	}
+	if protocol := os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"); protocol != "" {
+		return protocol
+	}
+
+	return "http/protobuf"
+}
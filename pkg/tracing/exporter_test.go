@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSetupExportsSpans(t *testing.T) {
+	defer func() { tracer = nil }()
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "authorization=Bearer%20token")
+
+	requests := make(chan *http.Request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case requests <- r:
+		default:
+		}
+	}))
+	defer server.Close()
+
+	stopCh := make(chan struct{})
+	Setup(server.URL, stopCh)
+	if tracer == nil {
+		t.Fatal("tracing is disabled, want enabled")
+	}
+
+	_, span := Start(context.Background(), "reconcile", String("namespace", "team-a"))
+	span.End(nil)
+	close(stopCh)
+
+	select {
+	case r := <-requests:
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("got path %q, want /v1/traces", r.URL.Path)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/x-protobuf" {
+			t.Errorf("got content type %q, want application/x-protobuf", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("got authorization %q, want the decoded %q", got, "Bearer token")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no spans exported")
+	}
+}
+
+func TestSetupSampler(t *testing.T) {
+	defer func() { tracer = nil }()
+	t.Setenv("OTEL_TRACES_SAMPLER", "always_off")
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	Setup("http://127.0.0.1:4318", stopCh)
+
+	_, span := Start(context.Background(), "reconcile")
+	defer span.End(nil)
+	if span == nil {
+		t.Fatal("got no span, want tracing enabled")
+	}
+	if span.span.IsRecording() {
+		t.Error("span is recording, want it dropped by the always_off sampler")
+	}
+}
+
+func TestSetupDisabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		env      map[string]string
+	}{
+		{name: "no endpoint"},
+		{name: "sdk disabled", endpoint: "http://127.0.0.1:4318", env: map[string]string{"OTEL_SDK_DISABLED": "true"}},
+		{name: "unsupported protocol", endpoint: "http://127.0.0.1:4318", env: map[string]string{"OTEL_EXPORTER_OTLP_PROTOCOL": "http/json"}},
+		{name: "invalid endpoint", endpoint: "otel-collector:4318"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() { tracer = nil }()
+			t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+			t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+			Setup(test.endpoint, stopCh)
+
+			if tracer != nil {
+				t.Error("tracing is enabled, want disabled")
+			}
+			if _, span := Start(context.Background(), "reconcile"); span != nil {
+				t.Error("got a span, want nil")
+			}
+		})
+	}
+}
+
+func TestTracesProtocol(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol string
+		traces   string
+		want     string
+	}{
+		{name: "default", want: "http/protobuf"},
+		{name: "general", protocol: "grpc", want: "grpc"},
+		{name: "traces override", protocol: "grpc", traces: "http/protobuf", want: "http/protobuf"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", test.protocol)
+			t.Setenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", test.traces)
+
+			if got := tracesProtocol(); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
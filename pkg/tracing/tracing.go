@@ -0,0 +1,75 @@
+// Package tracing traces reconcile operations with the OpenTelemetry SDK,
+// exporting spans to a collector over OTLP. When no endpoint is configured
+// every operation is a no-op.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the spans of the controller.
+const instrumentationName = "github.com/gccloudone-aurora/argo-controller"
+
+// tracer is the process-wide tracer. It is nil when tracing is disabled.
+var tracer trace.Tracer
+
+// Attribute is a key/value pair attached to a span.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// String returns a string attribute.
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span is a single timed operation. A nil *Span is valid and does nothing,
+// which is what Start returns when tracing is disabled.
+type Span struct {
+	span trace.Span
+}
+
+// Start begins a span named name as a child of the span in ctx, if any. The
+// returned context carries the new span.
+func Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	return start(ctx, name, trace.SpanKindInternal, attributes)
+}
+
+// StartClient begins a span describing a request to a remote service, such
+// as a call to the Kubernetes API.
+func StartClient(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	return start(ctx, name, trace.SpanKindClient, attributes)
+}
+
+func start(ctx context.Context, name string, kind trace.SpanKind, attributes []Attribute) (context.Context, *Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	keyValues := make([]attribute.KeyValue, 0, len(attributes))
+	for _, a := range attributes {
+		keyValues = append(keyValues, attribute.String(a.Key, a.Value))
+	}
+
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(keyValues...))
+
+	return ctx, &Span{span: span}
+}
+
+// End completes the span, recording err as its status if it is not nil.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}